@@ -0,0 +1,133 @@
+package drop
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// time layout of the audit logs of Antrea written by the log package of Go, e.g. "2019/02/04 10:10:12.345678", which
+// has no time zone
+const antreaTimeLayout = "2006/01/02 15:04:05.999999"
+
+// value of the audit log fields which are not set, e.g. the rule name of the default rules
+const antreaNilValue = "<nil>"
+
+// names of the fields decoded from Antrea audit logs, which can be used like the named parts of log prefixes
+const (
+	antreaFieldAction = "action"
+	antreaFieldPolicy = "policy"
+	antreaFieldRule   = "rule"
+	antreaFieldPod    = "pod"
+)
+
+// positions of the fields of Antrea audit logs split by spaces
+const (
+	antreaDate = iota
+	antreaTime
+	antreaTable
+	antreaPolicy
+	antreaRule
+	antreaAction
+	antreaPriority
+	antreaAppliedTo
+	antreaSrcIP
+	antreaSrcPort
+	antreaDstIP
+	antreaDstPort
+	antreaProtocol
+	antreaLength
+	antreaFieldCount // the log label may follow
+)
+
+// actions of Antrea NetworkPolicy rules which drop the packets, the others (e.g. "Allow" and "Pass") are skipped
+var antreaDropActions = map[string]bool{
+	"Drop":   true,
+	"Reject": true,
+}
+
+// Decode the NetworkPolicy audit log of Antrea in given record into PacketDrop, and return it with the text of its
+// table, policy, rule and action, which is matched by the log prefixes, e.g.
+// "2019/02/04 10:10:12.345678 AntreaPolicyIngressRule AntreaNetworkPolicy:default/test-anp test-rule Drop 44900
+// default/web-1 10.10.1.65 35402 10.10.1.66 80 TCP 60 <nil>", where "default/web-1" is the pod the rule is applied
+// to. The host name is the one of the record or of this host, as Antrea doesn't write host names.
+func decodeAntreaLog(format *LogFormat, record Record) (PacketDrop, string, error) {
+	fields := strings.Fields(record.Message)
+	if len(fields) < antreaFieldCount {
+		return PacketDrop{}, "", errors.New("Invalid Antrea audit log: missing fields")
+	}
+	if !antreaDropActions[fields[antreaAction]] {
+		return PacketDrop{}, "", errNotPacketDrop
+	}
+
+	pd := PacketDrop{HostName: record.Host, Source: record.Source}
+	var err error
+	timestamp := fields[antreaDate] + " " + fields[antreaTime]
+	if pd.LogTime, err = format.parseTime(timestamp); err != nil {
+		return PacketDrop{}, "", err
+	}
+	if pd.SrcIP, err = getAntreaIP(fields[antreaSrcIP], "srcIP"); err != nil {
+		return PacketDrop{}, "", err
+	}
+	if pd.DstIP, err = getAntreaIP(fields[antreaDstIP], "dstIP"); err != nil {
+		return PacketDrop{}, "", err
+	}
+	if pd.Proto, err = ParseProtocol(fields[antreaProtocol]); err != nil {
+		return PacketDrop{}, "", &ParseError{Field: "protocol", Value: fields[antreaProtocol]}
+	}
+	if pd.Proto.HasPorts() {
+		if pd.SrcPort, err = getAntreaPort(fields[antreaSrcPort], "srcPort"); err != nil {
+			return PacketDrop{}, "", err
+		}
+		if pd.DstPort, err = getAntreaPort(fields[antreaDstPort], "dstPort"); err != nil {
+			return PacketDrop{}, "", err
+		}
+	}
+	if length, err := strconv.ParseUint(fields[antreaLength], 10, 16); err == nil {
+		pd.Length = uint16(length)
+	}
+	if pd.HostName == "" {
+		pd.HostName = format.getHostName()
+	}
+	pd.Family = getFamily(pd.SrcIP)
+
+	policy, rule := getAntreaValue(fields[antreaPolicy]), getAntreaValue(fields[antreaRule])
+	pd.DropReason = fields[antreaAction] + " by " + policy
+	if rule != "" {
+		pd.DropReason += " rule " + rule
+	}
+	pd.PrefixFields = map[string]string{
+		antreaFieldAction: fields[antreaAction],
+		antreaFieldPolicy: policy,
+		antreaFieldRule:   rule,
+		antreaFieldPod:    getAntreaValue(fields[antreaAppliedTo]),
+	}
+	return pd, strings.Join(fields[antreaTable:antreaAction+1], " "), nil
+}
+
+// Helper function to get the value of given audit log field, which is empty if it's not set
+func getAntreaValue(value string) string {
+	if value == antreaNilValue {
+		return ""
+	}
+	return value
+}
+
+// Helper function to parse the IP address of given audit log field
+func getAntreaIP(value, field string) (net.IP, error) {
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil, &ParseError{Field: field, Value: value}
+	}
+	return ip, nil
+}
+
+// Helper function to parse the port of given audit log field
+func getAntreaPort(value, field string) (uint16, error) {
+	port, err := strconv.ParseUint(value, 10, 16)
+	if err != nil {
+		return 0, &ParseError{Field: field, Value: value, Err: err}
+	}
+	return uint16(port), nil
+}
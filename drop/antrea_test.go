@@ -0,0 +1,122 @@
+package drop
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// log format of the NetworkPolicy audit logs of Antrea written in UTC
+var testAntreaFormat, _ = InitLogFormat(LogFormatAntrea, "", time.UTC)
+
+// audit logs written to /var/log/antrea/networkpolicy/np.log
+const (
+	testAntreaDropLog = "2019/02/04 10:10:12.345678 AntreaPolicyIngressRule AntreaNetworkPolicy:default/deny-web " +
+		"deny-from-test Drop 44900 default/web-1 11.111.11.111 56789 22.222.22.222 1234 TCP 60 <nil>"
+	testAntreaRejectLog = "2019/02/04 10:10:12.345678 IngressDefaultRule K8sNetworkPolicy:default/web <nil> " +
+		"Reject 0 default/web-1 fd00::1 0 fd00::2 0 ICMPv6 104 audit-label"
+	testAntreaAllowLog = "2019/02/04 10:10:12.345678 AntreaPolicyEgressRule AntreaNetworkPolicy:default/allow-dns " +
+		"allow-dns Allow 44900 default/web-1 11.111.11.111 56789 22.222.22.222 53 UDP 60 <nil>"
+)
+
+// Test if the audit logs of Antrea are parsed with the policy metadata as fields of their log prefixes
+func TestParsingAntreaLog(t *testing.T) {
+	channel := make(chan PacketDrop, 1)
+	curTime := time.Now().UTC().Round(time.Microsecond)
+	logPrefixes := getTestLogPrefixes("Drop")
+	message := strings.Replace(testAntreaDropLog, "2019/02/04 10:10:12.345678",
+		curTime.Format("2006/01/02 15:04:05.000000"), 1)
+	record := Record{Message: message, Host: testHostname, Source: "np.log"}
+	expected := PacketDrop{
+		LogTime:    curTime,
+		HostName:   testHostname,
+		LogPrefix:  logPrefixes[0],
+		Family:     FamilyIPv4,
+		SrcIP:      net.ParseIP(testSrcIP),
+		SrcPort:    testSrcPort,
+		DstIP:      net.ParseIP(testDstIP),
+		DstPort:    testDstPort,
+		Proto:      ProtoTCP,
+		Length:     60,
+		DropReason: "Drop by AntreaNetworkPolicy:default/deny-web rule deny-from-test",
+		PrefixFields: map[string]string{
+			"action": "Drop",
+			"policy": "AntreaNetworkPolicy:default/deny-web",
+			"rule":   "deny-from-test",
+			"pod":    "default/web-1",
+		},
+		Source: "np.log",
+	}
+	result, prefix, err := decodeAntreaLog(testAntreaFormat, record)
+	if err != nil {
+		t.Fatalf("Expected %+v, but got error %s", expected, err)
+	}
+	expectedPrefix := "AntreaPolicyIngressRule AntreaNetworkPolicy:default/deny-web deny-from-test Drop"
+	if prefix != expectedPrefix {
+		t.Fatalf("Expected prefix %s, but got result %s", expectedPrefix, prefix)
+	}
+	if err := parse(logPrefixes, testAntreaFormat, record, channel); err != nil {
+		t.Fatalf("Expected %+v, but got error %s", expected, err)
+	}
+	if result = <-channel; !reflect.DeepEqual(result, expected) {
+		t.Fatalf("Expected %+v, but got result %+v", expected, result)
+	}
+
+	// the packets allowed and the packets of other log prefixes are skipped
+	for _, message := range []string{testAntreaAllowLog, testAntreaRejectLog} {
+		if err := parse(logPrefixes, testAntreaFormat, Record{Message: message}, channel); err != nil {
+			t.Fatalf("Expected error nil, but got error %s", err)
+		}
+		if len(channel) != 0 {
+			t.Fatalf("Expected no packet drop of log %s, but got result %+v", message, <-channel)
+		}
+	}
+}
+
+// Test if the audit logs of Antrea without ports nor rule name are decoded
+func TestDecodeAntreaLog(t *testing.T) {
+	result, _, err := decodeAntreaLog(testAntreaFormat, Record{Message: testAntreaRejectLog, Host: testHostname})
+	if err != nil {
+		t.Fatalf("Expected error nil, but got error %s", err)
+	}
+	expected := PacketDrop{
+		LogTime:    time.Date(2019, 2, 4, 10, 10, 12, 345678000, time.UTC),
+		HostName:   testHostname,
+		Family:     FamilyIPv6,
+		SrcIP:      net.ParseIP("fd00::1"),
+		DstIP:      net.ParseIP("fd00::2"),
+		Proto:      ProtoICMPv6,
+		Length:     104,
+		DropReason: "Reject by K8sNetworkPolicy:default/web",
+		PrefixFields: map[string]string{
+			"action": "Reject",
+			"policy": "K8sNetworkPolicy:default/web",
+			"rule":   "",
+			"pod":    "default/web-1",
+		},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("Expected %+v, but got result %+v", expected, result)
+	}
+
+	if _, _, err := decodeAntreaLog(testAntreaFormat, Record{Message: testAntreaAllowLog}); err != errNotPacketDrop {
+		t.Fatalf("Expected error %v, but got error %v", errNotPacketDrop, err)
+	}
+}
+
+// Test if the invalid audit logs of Antrea return errors
+func TestDecodeInvalidAntreaLog(t *testing.T) {
+	for _, message := range []string{
+		"2019/02/04 10:10:12.345678 AntreaPolicyIngressRule AntreaNetworkPolicy:default/deny-web Drop",
+		strings.Replace(testAntreaDropLog, "2019/02/04", "Feb 4", 1),
+		strings.Replace(testAntreaDropLog, "11.111.11.111", "11.111.11", 1),
+		strings.Replace(testAntreaDropLog, " 56789 ", " <nil> ", 1),
+		strings.Replace(testAntreaDropLog, " TCP ", " NONE ", 1),
+	} {
+		if result, _, err := decodeAntreaLog(testAntreaFormat, Record{Message: message}); err == nil {
+			t.Fatalf("Expected error of log %s, but got result %+v", message, result)
+		}
+	}
+}
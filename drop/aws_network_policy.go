@@ -0,0 +1,97 @@
+package drop
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// message of the logs of the flows written by the network policy agent of AWS VPC CNI
+const awsFlowInfoMessage = "Flow Info:"
+
+// verdict of the flows denied by network policies
+const awsVerdictDeny = "DENY"
+
+// awsPolicyAgentEntry is a JSON object written by the network policy agent of AWS VPC CNI to
+// /var/log/aws-routed-eni/network-policy-agent.log, e.g. `{"level": "info", "ts": "2019-02-04T17:10:12.345Z",
+// "logger": "ebpf-client", "msg": "Flow Info: ", "Src IP": "10.0.1.2", "Src Port": 51234, "Dest IP": "10.0.2.3",
+// "Dest Port": 80, "Proto": "TCP", "Verdict": "DENY"}`. The timestamp is either ISO8601 or seconds since epoch.
+type awsPolicyAgentEntry struct {
+	Timestamp json.RawMessage `json:"ts"`
+	Message   string          `json:"msg"`
+	SrcIP     string          `json:"Src IP"`
+	SrcPort   uint16          `json:"Src Port"`
+	DstIP     string          `json:"Dest IP"`
+	DstPort   uint16          `json:"Dest Port"`
+	Proto     string          `json:"Proto"`
+	Verdict   string          `json:"Verdict"`
+}
+
+// Decode the JSON object of the network policy agent of AWS VPC CNI in given record into PacketDrop, and return it
+// with its verdict, which is matched by the log prefixes. Only the flows denied are packet drops, the other logs of
+// the agent are skipped. The host name is the one of the record or of this host, as the agent doesn't write host
+// names.
+func decodeAWSPolicyAgentJSON(format *LogFormat, record Record) (PacketDrop, string, error) {
+	var entry awsPolicyAgentEntry
+	if err := json.Unmarshal([]byte(record.Message), &entry); err != nil {
+		return PacketDrop{}, "", fmt.Errorf("Invalid network policy agent log: %v", err)
+	}
+	if strings.TrimSpace(entry.Message) != awsFlowInfoMessage || entry.Verdict != awsVerdictDeny {
+		return PacketDrop{}, "", errNotPacketDrop
+	}
+
+	pd := PacketDrop{HostName: record.Host, Source: record.Source}
+	var err error
+	if pd.LogTime, err = format.getAWSPolicyAgentTime(entry.Timestamp); err != nil {
+		return PacketDrop{}, "", err
+	}
+	if pd.SrcIP, err = getAWSPolicyAgentIP(entry.SrcIP, "Src IP"); err != nil {
+		return PacketDrop{}, "", err
+	}
+	if pd.DstIP, err = getAWSPolicyAgentIP(entry.DstIP, "Dest IP"); err != nil {
+		return PacketDrop{}, "", err
+	}
+	if pd.Proto, err = ParseProtocol(entry.Proto); err != nil {
+		return PacketDrop{}, "", &ParseError{Field: "Proto", Value: entry.Proto}
+	}
+	if pd.Proto.HasPorts() {
+		pd.SrcPort, pd.DstPort = entry.SrcPort, entry.DstPort
+	}
+	if pd.HostName == "" {
+		pd.HostName = format.getHostName()
+	}
+	pd.Family = getFamily(pd.SrcIP)
+	return pd, entry.Verdict, nil
+}
+
+// Helper function to get the time of given timestamp of the network policy agent, either a string of ISO8601 or the
+// seconds since epoch
+func (format *LogFormat) getAWSPolicyAgentTime(timestamp json.RawMessage) (time.Time, error) {
+	if len(timestamp) == 0 {
+		return time.Time{}, &ParseError{Field: "ts", Err: ErrMissingField}
+	}
+	var seconds float64
+	if err := json.Unmarshal(timestamp, &seconds); err == nil {
+		// float64 of the seconds since epoch is precise to microseconds at most
+		return time.Unix(0, int64(seconds*float64(time.Second))).Round(time.Microsecond), nil
+	}
+	var value string
+	if err := json.Unmarshal(timestamp, &value); err != nil {
+		return time.Time{}, &ParseError{Field: "ts", Value: string(timestamp), Err: err}
+	}
+	return format.parseTime(value)
+}
+
+// Helper function to parse the IP address of given field of the network policy agent
+func getAWSPolicyAgentIP(value, field string) (net.IP, error) {
+	if value == "" {
+		return nil, &ParseError{Field: field, Err: ErrMissingField}
+	}
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil, &ParseError{Field: field, Value: value}
+	}
+	return ip, nil
+}
@@ -0,0 +1,101 @@
+package drop
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// log format of the JSON objects written by the network policy agent of AWS VPC CNI
+var testAWSPolicyAgentFormat, _ = InitLogFormat(LogFormatAWSPolicyAgent, "", time.UTC)
+
+// lines recorded from /var/log/aws-routed-eni/network-policy-agent.log
+const (
+	testAWSFlowDeny = `{"level":"info","ts":"2019-02-04T17:10:12.345Z","logger":"ebpf-client",` +
+		`"caller":"events/events.go:193","msg":"Flow Info: ","Src IP":"11.111.11.111","Src Port":56789,` +
+		`"Dest IP":"22.222.22.222","Dest Port":1234,"Proto":"TCP","Verdict":"DENY"}`
+	testAWSFlowAccept = `{"level":"info","ts":"2019-02-04T17:10:12.345Z","logger":"ebpf-client",` +
+		`"caller":"events/events.go:193","msg":"Flow Info: ","Src IP":"11.111.11.111","Src Port":56789,` +
+		`"Dest IP":"22.222.22.222","Dest Port":53,"Proto":"UDP","Verdict":"ACCEPT"}`
+	testAWSPolicyAgentLog = `{"level":"info","ts":"2019-02-04T17:10:12.345Z","logger":"controllers.policyEndpoints",` +
+		`"caller":"controllers/policyendpoints_controller.go:140","msg":"Received a new reconcile request",` +
+		`"req":{"name":"web-policy-x7k2p","namespace":"default"}}`
+)
+
+// Test if the flows denied by the network policy agent of AWS VPC CNI are parsed with the verdict as log prefix
+func TestParsingAWSPolicyAgentLog(t *testing.T) {
+	channel := make(chan PacketDrop, 1)
+	curTime := time.Now().UTC().Round(time.Millisecond)
+	logPrefixes := getTestLogPrefixes("DENY")
+	message := strings.Replace(testAWSFlowDeny, "2019-02-04T17:10:12.345Z", curTime.Format(time.RFC3339Nano), 1)
+	record := Record{Message: message, Host: testHostname, Source: "network-policy-agent.log"}
+	expected := PacketDrop{
+		LogTime:   curTime,
+		HostName:  testHostname,
+		LogPrefix: logPrefixes[0],
+		Family:    FamilyIPv4,
+		SrcIP:     net.ParseIP(testSrcIP),
+		SrcPort:   testSrcPort,
+		DstIP:     net.ParseIP(testDstIP),
+		DstPort:   testDstPort,
+		Proto:     ProtoTCP,
+		Source:    "network-policy-agent.log",
+	}
+	if err := parse(logPrefixes, testAWSPolicyAgentFormat, record, channel); err != nil {
+		t.Fatalf("Expected %+v, but got error %s", expected, err)
+	}
+	if result := <-channel; !reflect.DeepEqual(result, expected) {
+		t.Fatalf("Expected %+v, but got result %+v", expected, result)
+	}
+
+	// the flows accepted and the other logs of the agent are skipped
+	for _, message := range []string{testAWSFlowAccept, testAWSPolicyAgentLog} {
+		if err := parse(logPrefixes, testAWSPolicyAgentFormat, Record{Message: message}, channel); err != nil {
+			t.Fatalf("Expected error nil, but got error %s", err)
+		}
+		if len(channel) != 0 {
+			t.Fatalf("Expected no packet drop of log %s, but got result %+v", message, <-channel)
+		}
+	}
+}
+
+// Test if the flows of the network policy agent with timestamps in seconds since epoch and without ports are decoded
+func TestDecodeAWSPolicyAgentJSON(t *testing.T) {
+	log := `{"level":"info","ts":1549300212.345,"logger":"ebpf-client","msg":"Flow Info: ","Src IP":"fd00::1",` +
+		`"Src Port":0,"Dest IP":"fd00::2","Dest Port":0,"Proto":"ICMP","Verdict":"DENY"}`
+	result, prefix, err := decodeAWSPolicyAgentJSON(testAWSPolicyAgentFormat, Record{Message: log})
+	if err != nil {
+		t.Fatalf("Expected error nil, but got error %s", err)
+	}
+	if result.LogTime.UnixNano()/int64(time.Millisecond) != 1549300212345 {
+		t.Fatalf("Expected time 1549300212.345, but got result %v", result.LogTime)
+	}
+	if result.HostName == "" {
+		t.Fatal("Expected host name of this host, but got empty host name")
+	}
+	if result.Family != FamilyIPv6 || !result.SrcIP.Equal(net.ParseIP("fd00::1")) ||
+		!result.DstIP.Equal(net.ParseIP("fd00::2")) || result.Proto != ProtoICMP {
+		t.Fatalf("Expected ICMP packet from fd00::1 to fd00::2, but got result %+v", result)
+	}
+	if prefix != "DENY" {
+		t.Fatalf("Expected prefix DENY, but got result %s", prefix)
+	}
+}
+
+// Test if the invalid flows of the network policy agent return errors
+func TestDecodeInvalidAWSPolicyAgentJSON(t *testing.T) {
+	for _, message := range []string{
+		`2019-02-04T17:10:12.345Z info Flow Info: Src IP 11.111.11.111 Verdict: DENY`,
+		strings.Replace(testAWSFlowDeny, `"ts":"2019-02-04T17:10:12.345Z",`, "", 1),
+		strings.Replace(testAWSFlowDeny, `"2019-02-04T17:10:12.345Z"`, `"Feb  4 17:10:12"`, 1),
+		strings.Replace(testAWSFlowDeny, `"Src IP":"11.111.11.111"`, `"Src IP":""`, 1),
+		strings.Replace(testAWSFlowDeny, `"Dest IP":"22.222.22.222"`, `"Dest IP":"22.222.22"`, 1),
+		strings.Replace(testAWSFlowDeny, `"Proto":"TCP"`, `"Proto":"UNKNOWN"`, 1),
+	} {
+		if result, _, err := decodeAWSPolicyAgentJSON(testAWSPolicyAgentFormat, Record{Message: message}); err == nil {
+			t.Fatalf("Expected error of log %s, but got result %+v", message, result)
+		}
+	}
+}
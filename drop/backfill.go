@@ -0,0 +1,249 @@
+package drop
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// suffixes logrotate appends to the rotated files, e.g. ".1", ".2.gz" or "-20190204.zst" with dateext
+var rotatedFileSuffixRegexp = regexp.MustCompile(`^[.-]\d[\d_-]*(?:\.gz|\.zst)?$`)
+
+// command decompressing zstd files to its standard output, as there is no zstd package in the standard library
+var zstdCommand = []string{"zstd", "-dc", "--"}
+
+// backfill reads the logs of the files rotated from the watched file when the watcher starts, so the packet drops
+// logged while the service was down are not lost. Only the logs which haven't expired are sent.
+type backfill struct {
+	logFormat  *LogFormat
+	expiration time.Duration
+}
+
+// Enable reading the rotated files of the watched file before watching it: the logs of the rotated files are sent in time order,
+// starting after the checkpoint if it is of one of the files, and the logs which have expired are skipped
+func (watcher *Watcher) EnableBackfill(logFormat *LogFormat, expiration time.Duration) {
+	watcher.backfill = &backfill{logFormat: logFormat, expiration: expiration}
+}
+
+// Helper function to send the unexpired logs of the rotated files to given channel, return false if given stop
+// channel is closed meanwhile
+func (watcher *Watcher) runBackfill(stopCh <-chan struct{}, recordCh chan<- Record) bool {
+	files, err := getRotatedFiles(watcher.watchFileName)
+	if err != nil {
+		zap.L().Error("Cannot find rotated files",
+			zap.String("file", watcher.watchFileName),
+			zap.String("error", err.Error()),
+		)
+		return true
+	}
+	files, offset := watcher.getBackfillStart(files)
+
+	expiredTime := timeNow().Add(-watcher.backfill.expiration)
+	for _, file := range files {
+		// all the logs of the file were written before it was modified last time
+		if file.info.ModTime().Before(expiredTime) {
+			offset = 0
+			continue
+		}
+		zap.L().Info("Reading rotated file", zap.String("file", file.path), zap.Int64("offset", offset))
+		if !watcher.backfill.readFile(file.path, offset, expiredTime, watcher.maxLineLength, stopCh, recordCh) {
+			return false
+		}
+		offset = 0
+	}
+	return true
+}
+
+// Helper function to get the rotated files to read and the offset in the first one from the checkpoint. Nothing is
+// read if the checkpoint is of the watched file, and the files older than the file of the checkpoint are skipped.
+func (watcher *Watcher) getBackfillStart(files []rotatedFile) ([]rotatedFile, int64) {
+	checkpoint, ok := watcher.checkpoints.Get(watcher.watchFileName)
+	if !ok {
+		return files, 0
+	}
+	if info, err := os.Stat(watcher.watchFileName); err == nil && isCheckpointOf(checkpoint, info) {
+		return nil, 0
+	}
+	for i, file := range files {
+		if isCheckpointOf(checkpoint, file.info) && !isCompressedFile(file.path) {
+			return files[i:], checkpoint.Offset
+		}
+	}
+	return files, 0
+}
+
+// Helper function to check if given checkpoint is of given file
+func isCheckpointOf(checkpoint Checkpoint, info os.FileInfo) bool {
+	device, inode, ok := getFileID(info)
+	return ok && checkpoint.Device == device && checkpoint.Inode == inode
+}
+
+// Helper function to send the logs after given offset of given file which are logged after given expired time, return
+// false if given stop channel is closed meanwhile. The lines longer than given max length are skipped.
+func (backfill *backfill) readFile(path string, offset int64, expiredTime time.Time, maxLineLength int,
+	stopCh <-chan struct{}, recordCh chan<- Record) bool {
+	input, err := openRotatedFile(path)
+	if err != nil {
+		zap.L().Error("Cannot read rotated file", zap.String("file", path), zap.String("error", err.Error()))
+		return true
+	}
+	defer func() {
+		if err := input.Close(); err != nil {
+			zap.L().Error("Error while closing file", zap.String("file", path), zap.String("error", err.Error()))
+		}
+	}()
+	if offset > 0 {
+		if _, err := io.CopyN(ioutil.Discard, input, offset); err != nil {
+			zap.L().Error("Cannot read rotated file", zap.String("file", path), zap.String("error", err.Error()))
+			return true
+		}
+	}
+
+	reader := bufio.NewReader(input)
+	position := offset
+	for {
+		line, err := readLine(reader, maxLineLength)
+		if err != nil {
+			zap.L().Error("Cannot read rotated file", zap.String("file", path), zap.String("error", err.Error()))
+			return true
+		}
+		if line.size == 0 {
+			return true
+		}
+		position += line.size
+		if line.overlong {
+			zap.L().Warn("Skipping overlong line",
+				zap.String("file", path),
+				zap.Int64("offset", position-line.size),
+				zap.Int("max_length", maxLineLength),
+			)
+			countLine(path, LineOverlong)
+			continue
+		}
+		// skip the logs expired or without timestamp, which the parser would ignore
+		logTime, _, _, err := backfill.logFormat.Parse(line.text)
+		if err != nil || logTime.Before(expiredTime) {
+			continue
+		}
+		select {
+		case <-stopCh:
+			return false
+		case recordCh <- Record{Message: line.text, Source: path, Offset: position}:
+		}
+	}
+}
+
+// rotatedFile is a file rotated from the watched file
+type rotatedFile struct {
+	path string
+	info os.FileInfo
+}
+
+// Helper function to check if given rotated file is compressed
+func isCompressedFile(path string) bool {
+	for _, suffix := range []string{".gz", ".zst"} {
+		if strings.HasSuffix(path, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Helper function to find the files rotated from given file by the naming of logrotate, ordered from the oldest
+func getRotatedFiles(fileName string) ([]rotatedFile, error) {
+	dir, base := filepath.Split(fileName)
+	if dir == "" {
+		dir = "."
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []rotatedFile
+	for _, info := range infos {
+		if info.IsDir() || !strings.HasPrefix(info.Name(), base) ||
+			!rotatedFileSuffixRegexp.MatchString(strings.TrimPrefix(info.Name(), base)) {
+			continue
+		}
+		files = append(files, rotatedFile{path: filepath.Join(dir, info.Name()), info: info})
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].info.ModTime().Before(files[j].info.ModTime())
+	})
+	return files, nil
+}
+
+// Helper function to open given rotated file, which is decompressed if its name ends with ".gz" or ".zst"
+func openRotatedFile(path string) (io.ReadCloser, error) {
+	if strings.HasSuffix(path, ".zst") {
+		return openZstdFile(path)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasSuffix(path, ".gz") {
+		reader, err := gzip.NewReader(file)
+		if err != nil {
+			closeFile(file)
+			return nil, err
+		}
+		return &decompressedFile{Reader: reader, file: file}, nil
+	}
+	return file, nil
+}
+
+// Helper function to decompress given zstd file by zstd command
+func openZstdFile(path string) (io.ReadCloser, error) {
+	command := exec.Command(zstdCommand[0], append(zstdCommand[1:], path)...)
+	output, err := command.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := command.Start(); err != nil {
+		return nil, err
+	}
+	return &decompressedFile{Reader: output, command: command}, nil
+}
+
+// decompressedFile reads the decompressed content of a file, which is closed with the file or the command
+// decompressing it
+type decompressedFile struct {
+	io.Reader
+	file    *os.File
+	command *exec.Cmd
+	atEOF   bool
+}
+
+// Read the decompressed content
+func (file *decompressedFile) Read(p []byte) (int, error) {
+	n, err := file.Reader.Read(p)
+	if err == io.EOF {
+		file.atEOF = true
+	}
+	return n, err
+}
+
+// Close the file, or wait for the command decompressing it to exit
+func (file *decompressedFile) Close() error {
+	if file.command == nil {
+		return file.file.Close()
+	}
+	if !file.atEOF {
+		// the rest of the file isn't needed, and the command fails once it's killed
+		file.command.Process.Kill()
+		file.command.Wait()
+		return nil
+	}
+	return file.command.Wait()
+}
@@ -0,0 +1,184 @@
+package drop
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/box/kube-iptables-tailer/util"
+)
+
+// Helper function to get a log of given age
+func getBackfillLog(age time.Duration, message string) string {
+	return time.Now().Add(-age).Format(util.DefaultPacketDropLogTimeLayout) + " hostname " + message
+}
+
+// Helper function to write given logs to given rotated file modified at given age, compressing them by its suffix
+func writeRotatedFile(t *testing.T, path string, age time.Duration, logs ...string) {
+	content := []byte(strings.Join(logs, "\n") + "\n")
+	switch filepath.Ext(path) {
+	case ".gz":
+		file, err := os.Create(path)
+		if err != nil {
+			t.Fatalf("Cannot create the test file, err=%+v", err)
+		}
+		writer := gzip.NewWriter(file)
+		writer.Write(content)
+		writer.Close()
+		file.Close()
+	case ".zst":
+		command := exec.Command("zstd", "-q", "-o", path)
+		command.Stdin = strings.NewReader(string(content))
+		if err := command.Run(); err != nil {
+			t.Fatalf("Cannot create the test file, err=%+v", err)
+		}
+	default:
+		if err := ioutil.WriteFile(path, content, 0644); err != nil {
+			t.Fatalf("Cannot create the test file, err=%+v", err)
+		}
+	}
+	modTime := time.Now().Add(-age)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatalf("Cannot change the time of the test file, err=%+v", err)
+	}
+}
+
+// Helper function to run the backfill of given watcher and get the messages of records sent
+func runBackfillMessages(watcher *Watcher) []string {
+	channel := make(chan Record, 10)
+	watcher.runBackfill(make(chan struct{}), channel)
+	close(channel)
+	var messages []string
+	for record := range channel {
+		messages = append(messages, record.Message[strings.Index(record.Message, " hostname ")+10:])
+	}
+	return messages
+}
+
+// Test if the files rotated by logrotate are found from the oldest
+func TestGetRotatedFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backfill")
+	if err != nil {
+		t.Fatalf("Cannot create the test directory, err=%+v", err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "iptables.log")
+	writeRotatedFile(t, fileName, 0)
+	writeRotatedFile(t, fileName+".1", time.Minute)
+	writeRotatedFile(t, fileName+".2.gz", 2*time.Minute)
+	writeRotatedFile(t, fileName+"-20190204.zst", 3*time.Minute)
+	writeRotatedFile(t, fileName+"-20190203", 4*time.Minute)
+	writeRotatedFile(t, fileName+".bak", time.Minute)
+	writeRotatedFile(t, fileName+".1.gz.tmp", time.Minute)
+	writeRotatedFile(t, filepath.Join(dir, "iptables.logger.1"), time.Minute)
+
+	files, err := getRotatedFiles(fileName)
+	if err != nil {
+		t.Fatalf("Expected no error, but got error %+v", err)
+	}
+	var result []string
+	for _, file := range files {
+		result = append(result, filepath.Base(file.path))
+	}
+	expected := []string{"iptables.log-20190203", "iptables.log-20190204.zst", "iptables.log.2.gz", "iptables.log.1"}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("Expected %v, but got result %v", expected, result)
+	}
+}
+
+// Test if the unexpired logs of rotated files are read in time order, including compressed files
+func TestWatcherBackfill(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backfill")
+	if err != nil {
+		t.Fatalf("Cannot create the test directory, err=%+v", err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "iptables.log")
+	writeRotatedFile(t, fileName, 0, getBackfillLog(0, "live"))
+	writeRotatedFile(t, fileName+".1", time.Minute,
+		getBackfillLog(30*time.Minute, "expired"), "invalid", getBackfillLog(2*time.Minute, "1"))
+	writeRotatedFile(t, fileName+".2.gz", 3*time.Minute, getBackfillLog(4*time.Minute, "2"))
+	writeRotatedFile(t, fileName+".4.gz", time.Hour, getBackfillLog(0, "not read"))
+	expected := []string{"2", "1"}
+	if _, err := exec.LookPath("zstd"); err == nil {
+		writeRotatedFile(t, fileName+".3.zst", 5*time.Minute, getBackfillLog(6*time.Minute, "3"))
+		expected = []string{"3", "2", "1"}
+	}
+
+	logFormat, _ := InitLogFormat(LogFormatDefault, "", nil)
+	watcher := InitWatcher(fileName, time.Second, true, nil)
+	watcher.EnableBackfill(logFormat, 10*time.Minute)
+	if result := runBackfillMessages(watcher); !reflect.DeepEqual(result, expected) {
+		t.Fatalf("Expected %v, but got result %v", expected, result)
+	}
+}
+
+// Test if the backfill starts from the checkpoint of a rotated file, and reads nothing if the checkpoint is of the
+// watched file
+func TestWatcherBackfillFromCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backfill")
+	if err != nil {
+		t.Fatalf("Cannot create the test directory, err=%+v", err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "iptables.log")
+	firstLog := getBackfillLog(2*time.Minute, "first")
+	writeRotatedFile(t, fileName, 0, getBackfillLog(0, "live"))
+	writeRotatedFile(t, fileName+".1", time.Minute, firstLog, getBackfillLog(time.Minute, "second"))
+	writeRotatedFile(t, fileName+".2.gz", 3*time.Minute, getBackfillLog(4*time.Minute, "older"))
+	info, _ := os.Stat(fileName + ".1")
+	device, inode, ok := getFileID(info)
+	if !ok {
+		t.Skip("File identity is not supported")
+	}
+
+	logFormat, _ := InitLogFormat(LogFormatDefault, "", nil)
+	store := &CheckpointStore{checkpoints: make(map[string]Checkpoint)}
+	store.Set(Checkpoint{Source: fileName, Device: device, Inode: inode, Offset: int64(len(firstLog) + 1)})
+	watcher := InitWatcher(fileName, time.Second, true, store)
+	watcher.EnableBackfill(logFormat, 10*time.Minute)
+	if result := runBackfillMessages(watcher); !reflect.DeepEqual(result, []string{"second"}) {
+		t.Fatalf("Expected %v, but got result %v", []string{"second"}, result)
+	}
+
+	info, _ = os.Stat(fileName)
+	device, inode, _ = getFileID(info)
+	store.Set(Checkpoint{Source: fileName, Device: device, Inode: inode})
+	if result := runBackfillMessages(watcher); len(result) != 0 {
+		t.Fatalf("Expected no logs, but got result %v", result)
+	}
+}
+
+// Test if the overlong lines of rotated files are skipped and counted, and the lines after them are still read
+func TestWatcherBackfillOverlongLine(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backfill")
+	if err != nil {
+		t.Fatalf("Cannot create the test directory, err=%+v", err)
+	}
+	defer os.RemoveAll(dir)
+	var counted []string
+	defer SetLineCounter(countLine)
+	SetLineCounter(func(fileName, reason string) { counted = append(counted, reason) })
+	fileName := filepath.Join(dir, "iptables.log")
+	writeRotatedFile(t, fileName, 0, getBackfillLog(0, "live"))
+	writeRotatedFile(t, fileName+".1", time.Minute, getBackfillLog(3*time.Minute, "first"),
+		getBackfillLog(2*time.Minute, strings.Repeat("x", 128*1024)), getBackfillLog(time.Minute, "second"))
+
+	logFormat, _ := InitLogFormat(LogFormatDefault, "", nil)
+	watcher := InitWatcher(fileName, time.Second, true, nil)
+	watcher.SetLineLimits(64*1024, 0)
+	watcher.EnableBackfill(logFormat, 10*time.Minute)
+	expected := []string{"first", "second"}
+	if result := runBackfillMessages(watcher); !reflect.DeepEqual(result, expected) {
+		t.Fatalf("Expected %v, but got result %v", expected, result)
+	}
+	if !reflect.DeepEqual(counted, []string{LineOverlong}) {
+		t.Fatalf("Expected %v, but got result %v", []string{LineOverlong}, counted)
+	}
+}
@@ -0,0 +1,197 @@
+package drop
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Checkpoint is the position read so far from a source: the identity of the file and the offset read in it for files,
+// the cursor of the last entry read for the journal, or the boot ID and the sequence number (as offset) of the last
+// record read for the kernel ring buffer
+type Checkpoint struct {
+	Source string `json:"source"`
+	Device uint64 `json:"device,omitempty"`
+	Inode  uint64 `json:"inode,omitempty"`
+	Offset int64  `json:"offset,omitempty"`
+	Cursor string `json:"cursor,omitempty"`
+	Boot   string `json:"boot,omitempty"`
+}
+
+// CheckpointStore keeps the checkpoints of sources in memory and writes them to its file, so the sources resume from
+// where they were after restarts. Its methods are safe for concurrent use by multiple sources.
+type CheckpointStore struct {
+	path        string
+	lock        sync.Mutex
+	checkpoints map[string]Checkpoint // source as key
+	changed     bool
+}
+
+// Init a checkpoint store of given file, load the checkpoints in the file if it exists, and return its pointer
+func InitCheckpointStore(path string) (*CheckpointStore, error) {
+	store := &CheckpointStore{path: path, checkpoints: make(map[string]Checkpoint)}
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var checkpoints []Checkpoint
+	if err := json.Unmarshal(content, &checkpoints); err != nil {
+		return nil, fmt.Errorf("Invalid checkpoint file %s: %v", path, err)
+	}
+	for _, checkpoint := range checkpoints {
+		store.checkpoints[checkpoint.Source] = checkpoint
+	}
+	return store, nil
+}
+
+// Get the checkpoint of given source, return false if there is none
+func (store *CheckpointStore) Get(source string) (Checkpoint, bool) {
+	if store == nil {
+		return Checkpoint{}, false
+	}
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	checkpoint, ok := store.checkpoints[source]
+	return checkpoint, ok
+}
+
+// Set the checkpoint of its source, which is written to the file by the next Save()
+func (store *CheckpointStore) Set(checkpoint Checkpoint) {
+	if store == nil {
+		return
+	}
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	store.checkpoints[checkpoint.Source] = checkpoint
+	store.changed = true
+}
+
+// Write the checkpoints to the file if any of them changed. The file is replaced atomically, so it is never left
+// partially written.
+func (store *CheckpointStore) Save() error {
+	store.lock.Lock()
+	if !store.changed {
+		store.lock.Unlock()
+		return nil
+	}
+	var checkpoints []Checkpoint
+	for _, checkpoint := range store.checkpoints {
+		checkpoints = append(checkpoints, checkpoint)
+	}
+	store.changed = false
+	store.lock.Unlock()
+
+	sort.Slice(checkpoints, func(i, j int) bool { return checkpoints[i].Source < checkpoints[j].Source })
+	content, err := json.Marshal(checkpoints)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomically(store.path, content); err != nil {
+		// write the checkpoints again next time
+		store.lock.Lock()
+		store.changed = true
+		store.lock.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Run the store by saving the checkpoints at every given interval until given stop channel is closed, and save them
+// once more before returning
+func (store *CheckpointStore) Run(stopCh <-chan struct{}, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			store.save()
+			return
+		case <-ticker.C:
+			store.save()
+		}
+	}
+}
+
+// Helper function to save the checkpoints and report the error
+func (store *CheckpointStore) save() {
+	if err := store.Save(); err != nil {
+		zap.L().Error("Failed to save checkpoints", zap.String("file", store.path), zap.String("error", err.Error()))
+	}
+}
+
+// checkpointQueue keeps the checkpoints of the records of a source in the order they are read, and sets them to the
+// store once the records and all the records before them are handled, so a checkpoint never skips a packet drop which
+// is still waiting for the poster
+type checkpointQueue struct {
+	store   *CheckpointStore
+	lock    sync.Mutex
+	pending []*pendingCheckpoint
+}
+
+// pendingCheckpoint is the checkpoint right after a record, which is kept once the record is handled
+type pendingCheckpoint struct {
+	queue      *checkpointQueue
+	checkpoint Checkpoint
+	posted     bool // the packet drop of the record is inserted to the channel, and is marked done by the poster
+	done       bool
+}
+
+// Add given checkpoint of the next record to the queue, and return it to be marked done once the record is handled
+func (queue *checkpointQueue) add(checkpoint Checkpoint) *pendingCheckpoint {
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+	pending := &pendingCheckpoint{queue: queue, checkpoint: checkpoint}
+	queue.pending = append(queue.pending, pending)
+	return pending
+}
+
+// Mark the record of the checkpoint handled, and set the last checkpoint whose records are all handled to the store
+func (pending *pendingCheckpoint) markDone() {
+	queue := pending.queue
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+	pending.done = true
+	// the checkpoints of the records handled in a row are replaced by the last of them while waiting
+	if n := len(queue.pending); n > 1 && queue.pending[n-1] == pending && queue.pending[n-2].done {
+		queue.pending = append(queue.pending[:n-2], pending)
+	}
+	var last *pendingCheckpoint
+	for len(queue.pending) > 0 && queue.pending[0].done {
+		last, queue.pending = queue.pending[0], queue.pending[1:]
+	}
+	if last != nil {
+		queue.store.Set(last.checkpoint)
+	}
+}
+
+// Helper function to write given content to a temporary file in the directory of given file and rename it to the file
+func writeFileAtomically(path string, content []byte) error {
+	tempFile, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tempFile.Write(content)
+	if err == nil {
+		err = tempFile.Sync()
+	}
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tempFile.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tempFile.Name())
+	}
+	return err
+}
@@ -0,0 +1,139 @@
+package drop
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/box/kube-iptables-tailer/util"
+)
+
+// Helper function to create a temporary directory for checkpoint files
+func getCheckpointDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatalf("Cannot create the test directory, err=%+v", err)
+	}
+	return dir
+}
+
+// Test if checkpoints are saved and loaded again
+func TestCheckpointStoreSaveAndLoad(t *testing.T) {
+	dir := getCheckpointDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "checkpoints.json")
+
+	store, err := InitCheckpointStore(path)
+	if err != nil {
+		t.Fatalf("Expected no error, but got error %+v", err)
+	}
+	if _, ok := store.Get("journal"); ok {
+		t.Fatalf("Expected no checkpoint, but got one")
+	}
+	expected := []Checkpoint{
+		{Source: "/var/log/iptables.log", Device: 1, Inode: 2, Offset: 3},
+		{Source: "journal", Cursor: "s=abc;i=1"},
+	}
+	for _, checkpoint := range expected {
+		store.Set(checkpoint)
+	}
+	if err := store.Save(); err != nil {
+		t.Fatalf("Expected no error, but got error %+v", err)
+	}
+
+	loaded, err := InitCheckpointStore(path)
+	if err != nil {
+		t.Fatalf("Expected no error, but got error %+v", err)
+	}
+	for _, checkpoint := range expected {
+		if result, ok := loaded.Get(checkpoint.Source); !ok || !reflect.DeepEqual(result, checkpoint) {
+			t.Fatalf("Expected %v, but got result %v", checkpoint, result)
+		}
+	}
+
+	// nothing but the checkpoint file is left in the directory
+	files, err := ioutil.ReadDir(dir)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Expected only the checkpoint file, but got result %v", files)
+	}
+}
+
+// Test if the checkpoint file is written only when checkpoints changed
+func TestCheckpointStoreSaveUnchanged(t *testing.T) {
+	dir := getCheckpointDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "checkpoints.json")
+
+	store, _ := InitCheckpointStore(path)
+	if err := store.Save(); err != nil {
+		t.Fatalf("Expected no error, but got error %+v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("Expected no checkpoint file, but got error %+v", err)
+	}
+
+	store.Set(Checkpoint{Source: "journal", Cursor: "s=abc;i=1"})
+	if err := store.Save(); err != nil {
+		t.Fatalf("Expected no error, but got error %+v", err)
+	}
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("Cannot remove the checkpoint file, err=%+v", err)
+	}
+	if err := store.Save(); err != nil {
+		t.Fatalf("Expected no error, but got error %+v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("Expected no checkpoint file, but got error %+v", err)
+	}
+}
+
+// Test if invalid checkpoint file returns error
+func TestCheckpointStoreInvalidFile(t *testing.T) {
+	dir := getCheckpointDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "checkpoints.json")
+	if err := ioutil.WriteFile(path, []byte("{invalid"), 0644); err != nil {
+		t.Fatalf("Cannot create the test file, err=%+v", err)
+	}
+	if _, err := InitCheckpointStore(path); err == nil {
+		t.Fatalf("Expected error, but got nil")
+	}
+}
+
+// Test if nil checkpoint store keeps no checkpoint
+func TestCheckpointStoreNil(t *testing.T) {
+	var store *CheckpointStore
+	store.Set(Checkpoint{Source: "journal", Cursor: "s=abc;i=1"})
+	if _, ok := store.Get("journal"); ok {
+		t.Fatalf("Expected no checkpoint, but got one")
+	}
+}
+
+// Test if the checkpoints of the records are only kept once their packet drops and the records before them are handled
+func TestRunParsingCheckpoints(t *testing.T) {
+	logTime := time.Now().Format(util.DefaultPacketDropLogTimeLayout)
+	dropLog := fmt.Sprintf("%s %s %s SRC=%s SPT=%d DST=%s DPT=%d PROTO=%s IN=%s TTL=%d", logTime, testHostname,
+		testLogPrefix, testSrcIP, testSrcPort, testDstIP, testDstPort, testProto, testInterfaceReceived, testPacketTtl)
+	recordCh, packetDropCh := make(chan Record, 10), make(chan PacketDrop, 10)
+	for i, message := range []string{dropLog, "not a packet drop", dropLog, "not a packet drop"} {
+		recordCh <- Record{Message: message, Checkpoint: &Checkpoint{Source: "test", Offset: int64(i + 1)}}
+	}
+	close(recordCh)
+	store := &CheckpointStore{checkpoints: make(map[string]Checkpoint)}
+	RunParsing(testLogPrefixes, testLogFormat, recordCh, packetDropCh, store)
+	if checkpoint, ok := store.Get("test"); ok {
+		t.Fatalf("Expected no checkpoint, but got result %+v", checkpoint)
+	}
+
+	for _, offset := range []int64{2, 4} {
+		packetDrop := <-packetDropCh
+		packetDrop.Done()
+		if checkpoint, _ := store.Get("test"); checkpoint.Offset != offset {
+			t.Fatalf("Expected offset %d, but got result %+v", offset, checkpoint)
+		}
+	}
+}
@@ -0,0 +1,338 @@
+package drop
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// name of the cilium monitor as the source of records
+const ciliumMonitorSourceName = "cilium-monitor"
+
+// verdict of the flows of Hubble which are dropped
+const hubbleVerdictDropped = "DROPPED"
+
+// names of the fields decoded from Cilium drops, which can be used like the named parts of log prefixes
+const (
+	ciliumFieldDropReason  = "drop_reason"
+	ciliumFieldSrcIdentity = "src_identity"
+	ciliumFieldDstIdentity = "dst_identity"
+)
+
+// command following the drop notifications of Cilium, which are written one per line as JSON objects
+var ciliumMonitorCommand = []string{"cilium", "monitor", "--type", "drop", "-o", "json"}
+
+// ciliumDropNotify is the JSON object of a drop notification written by "cilium monitor -o json", e.g.
+// `{"type": "drop", "reason": "Policy denied", "source": 1234, "bytes": 74, "srcLabel": 5678, "dstLabel": 9012,
+// "summary": {"l3": {"src": "10.0.1.2", "dst": "10.0.2.3"}, "l4": {"src": "51234", "dst": "80"}, "tcp": "..."}}`.
+// The source and destination labels are the security identities of the endpoints.
+type ciliumDropNotify struct {
+	Type     string `json:"type"`
+	Reason   string `json:"reason"`
+	SrcLabel uint32 `json:"srcLabel"`
+	DstLabel uint32 `json:"dstLabel"`
+	Summary  *struct {
+		L3     *ciliumFlow `json:"l3"`
+		L4     *ciliumFlow `json:"l4"`
+		TCP    string      `json:"tcp"`
+		UDP    string      `json:"udp"`
+		ICMPv4 string      `json:"icmpv4"`
+		ICMPv6 string      `json:"icmpv6"`
+	} `json:"summary"`
+}
+
+// ciliumFlow is the source and destination of a layer in the summary of Cilium drop notifications
+type ciliumFlow struct {
+	Src string `json:"src"`
+	Dst string `json:"dst"`
+}
+
+// hubbleFlowResponse is the JSON object of a flow written by "hubble observe -o json" or the Hubble exporter, e.g.
+// `{"flow": {"time": "2019-02-04T17:10:12.345678Z", "verdict": "DROPPED", "drop_reason_desc": "POLICY_DENIED",
+// "IP": {"source": "10.0.1.2", "destination": "10.0.2.3"}, "l4": {"TCP": {"source_port": 51234,
+// "destination_port": 80}}, "source": {"identity": 5678}, "destination": {"identity": 9012}, "node_name": "node-1"}}`
+type hubbleFlowResponse struct {
+	Flow *struct {
+		Time           string `json:"time"`
+		Verdict        string `json:"verdict"`
+		DropReasonDesc string `json:"drop_reason_desc"`
+		Ethernet       *struct {
+			Source      string `json:"source"`
+			Destination string `json:"destination"`
+		} `json:"ethernet"`
+		IP *struct {
+			Source      string `json:"source"`
+			Destination string `json:"destination"`
+		} `json:"IP"`
+		L4 *struct {
+			TCP    *hubblePorts `json:"TCP"`
+			UDP    *hubblePorts `json:"UDP"`
+			SCTP   *hubblePorts `json:"SCTP"`
+			ICMPv4 *hubbleIcmp  `json:"ICMPv4"`
+			ICMPv6 *hubbleIcmp  `json:"ICMPv6"`
+		} `json:"l4"`
+		Source      *hubbleEndpoint `json:"source"`
+		Destination *hubbleEndpoint `json:"destination"`
+		NodeName    string          `json:"node_name"`
+	} `json:"flow"`
+}
+
+// hubblePorts is the layer 4 of the flows of Hubble with ports
+type hubblePorts struct {
+	SourcePort      uint16          `json:"source_port"`
+	DestinationPort uint16          `json:"destination_port"`
+	Flags           map[string]bool `json:"flags"`
+}
+
+// hubbleIcmp is the layer 4 of the flows of Hubble of ICMP and ICMPv6
+type hubbleIcmp struct {
+	Type uint8 `json:"type"`
+	Code uint8 `json:"code"`
+}
+
+// hubbleEndpoint is the source or destination endpoint of the flows of Hubble
+type hubbleEndpoint struct {
+	Identity uint32 `json:"identity"`
+}
+
+// Decode the JSON object of a Cilium drop notification or a Hubble flow in given record into PacketDrop, and return
+// it with the drop reason, which is matched by the log prefixes. The host name is the node name of Hubble flows, or
+// the one of the record or of this host for the drop notifications, which have no time either.
+func decodeCiliumJSON(format *LogFormat, record Record) (PacketDrop, string, error) {
+	message := []byte(record.Message)
+	var response hubbleFlowResponse
+	if err := json.Unmarshal(message, &response); err != nil {
+		return PacketDrop{}, "", fmt.Errorf("Invalid Cilium log: %v", err)
+	}
+	var pd PacketDrop
+	var err error
+	if response.Flow != nil {
+		pd, err = format.getHubblePacketDrop(response)
+	} else {
+		var notify ciliumDropNotify
+		if err := json.Unmarshal(message, &notify); err != nil {
+			return PacketDrop{}, "", fmt.Errorf("Invalid Cilium log: %v", err)
+		}
+		pd, err = getCiliumPacketDrop(notify)
+		pd.LogTime = record.Time
+		if !record.HasTime() {
+			pd.LogTime = timeNow()
+		}
+	}
+	if err != nil {
+		return PacketDrop{}, "", err
+	}
+
+	pd.Source = record.Source
+	if pd.HostName == "" {
+		pd.HostName = record.Host
+	}
+	if pd.HostName == "" {
+		pd.HostName = format.getHostName()
+	}
+	pd.Family = getFamily(pd.SrcIP)
+	pd.PrefixFields = map[string]string{
+		ciliumFieldDropReason:  pd.DropReason,
+		ciliumFieldSrcIdentity: strconv.FormatUint(uint64(pd.SrcIdentity), 10),
+		ciliumFieldDstIdentity: strconv.FormatUint(uint64(pd.DstIdentity), 10),
+	}
+	return pd, pd.DropReason, nil
+}
+
+// Helper function to get the PacketDrop of given Cilium drop notification
+func getCiliumPacketDrop(notify ciliumDropNotify) (PacketDrop, error) {
+	if notify.Type != "drop" {
+		return PacketDrop{}, errNotPacketDrop
+	}
+	if notify.Summary == nil || notify.Summary.L3 == nil {
+		return PacketDrop{}, &ParseError{Field: "summary.l3", Err: ErrMissingField}
+	}
+	pd := PacketDrop{DropReason: notify.Reason, SrcIdentity: notify.SrcLabel, DstIdentity: notify.DstLabel}
+	var err error
+	if pd.SrcIP, err = getCiliumIP(notify.Summary.L3.Src, "summary.l3.src"); err != nil {
+		return PacketDrop{}, err
+	}
+	if pd.DstIP, err = getCiliumIP(notify.Summary.L3.Dst, "summary.l3.dst"); err != nil {
+		return PacketDrop{}, err
+	}
+
+	switch {
+	case notify.Summary.TCP != "":
+		pd.Proto = ProtoTCP
+	case notify.Summary.UDP != "":
+		pd.Proto = ProtoUDP
+	case notify.Summary.ICMPv4 != "":
+		pd.Proto = ProtoICMP
+	case notify.Summary.ICMPv6 != "":
+		pd.Proto = ProtoICMPv6
+	}
+	// the summary of the protocols without ports, e.g. ICMP, has the layer 4 with empty ports
+	if l4 := notify.Summary.L4; l4 != nil && !(l4.Src == "" && l4.Dst == "" && !pd.Proto.HasPorts()) {
+		srcPort, err := strconv.ParseUint(notify.Summary.L4.Src, 10, 16)
+		if err != nil {
+			return PacketDrop{}, &ParseError{Field: "summary.l4.src", Value: notify.Summary.L4.Src, Err: err}
+		}
+		dstPort, err := strconv.ParseUint(notify.Summary.L4.Dst, 10, 16)
+		if err != nil {
+			return PacketDrop{}, &ParseError{Field: "summary.l4.dst", Value: notify.Summary.L4.Dst, Err: err}
+		}
+		pd.SrcPort, pd.DstPort = uint16(srcPort), uint16(dstPort)
+	}
+	return pd, nil
+}
+
+// Helper function to get the PacketDrop of given Hubble flow
+func (format *LogFormat) getHubblePacketDrop(response hubbleFlowResponse) (PacketDrop, error) {
+	flow := response.Flow
+	if flow.Verdict != hubbleVerdictDropped {
+		return PacketDrop{}, errNotPacketDrop
+	}
+	if flow.IP == nil {
+		return PacketDrop{}, &ParseError{Field: "flow.IP", Err: ErrMissingField}
+	}
+	pd := PacketDrop{HostName: flow.NodeName, DropReason: flow.DropReasonDesc}
+	var err error
+	if pd.LogTime, err = format.parseTime(flow.Time); err != nil {
+		return PacketDrop{}, err
+	}
+	if pd.SrcIP, err = getCiliumIP(flow.IP.Source, "flow.IP.source"); err != nil {
+		return PacketDrop{}, err
+	}
+	if pd.DstIP, err = getCiliumIP(flow.IP.Destination, "flow.IP.destination"); err != nil {
+		return PacketDrop{}, err
+	}
+	if flow.Ethernet != nil {
+		pd.MacAddress = flow.Ethernet.Destination + ":" + flow.Ethernet.Source
+	}
+	if flow.Source != nil {
+		pd.SrcIdentity = flow.Source.Identity
+	}
+	if flow.Destination != nil {
+		pd.DstIdentity = flow.Destination.Identity
+	}
+
+	if l4 := flow.L4; l4 != nil {
+		var ports *hubblePorts
+		var icmp *hubbleIcmp
+		switch {
+		case l4.TCP != nil:
+			pd.Proto, ports = ProtoTCP, l4.TCP
+		case l4.UDP != nil:
+			pd.Proto, ports = ProtoUDP, l4.UDP
+		case l4.SCTP != nil:
+			pd.Proto, ports = ProtoSCTP, l4.SCTP
+		case l4.ICMPv4 != nil:
+			pd.Proto, icmp = ProtoICMP, l4.ICMPv4
+		case l4.ICMPv6 != nil:
+			pd.Proto, icmp = ProtoICMPv6, l4.ICMPv6
+		}
+		if ports != nil {
+			pd.SrcPort, pd.DstPort = ports.SourcePort, ports.DestinationPort
+			for _, flagName := range tcpFlagNames {
+				if ports.Flags[flagName.name] {
+					pd.TCPFlags |= flagName.flag
+				}
+			}
+		}
+		if icmp != nil {
+			icmpType := icmp.Type
+			pd.IcmpType, pd.IcmpCode = &icmpType, icmp.Code
+		}
+	}
+	return pd, nil
+}
+
+// Helper function to parse the IP address of given Cilium field
+func getCiliumIP(value, field string) (net.IP, error) {
+	if value == "" {
+		return nil, &ParseError{Field: field, Err: ErrMissingField}
+	}
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil, &ParseError{Field: field, Value: value}
+	}
+	return ip, nil
+}
+
+// CiliumMonitor reads the drop notifications of Cilium by running "cilium monitor", whose JSON objects are sent as
+// records to be decoded by the log format "cilium-json". The notifications have no timestamp, so the records have the
+// time they are read.
+type CiliumMonitor struct {
+	hostName string
+}
+
+// Init a cilium monitor object and return its pointer
+func InitCiliumMonitor() *CiliumMonitor {
+	hostName, _ := os.Hostname()
+	return &CiliumMonitor{hostName: hostName}
+}
+
+// Run cilium monitor and insert the drop notifications as records into given channel until given stop channel is
+// closed
+func (monitor *CiliumMonitor) Run(stopCh <-chan struct{}, recordCh chan<- Record) error {
+	var stderr bytes.Buffer
+	command := exec.Command(ciliumMonitorCommand[0], ciliumMonitorCommand[1:]...)
+	command.Stderr = &stderr
+	output, err := command.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := command.Start(); err != nil {
+		return fmt.Errorf("Cannot run %s: %v", ciliumMonitorCommand[0], err)
+	}
+
+	// stop cilium monitor once the stop channel is closed
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-stopCh:
+			command.Process.Kill()
+		case <-done:
+		}
+	}()
+
+	err = monitor.readOutput(output, stopCh, recordCh)
+	if err != nil {
+		command.Process.Kill()
+	}
+	waitErr := command.Wait()
+	select {
+	case <-stopCh:
+		return nil
+	default:
+	}
+	if err != nil {
+		return err
+	}
+	if waitErr != nil {
+		return fmt.Errorf("%s exited: %v: %s", ciliumMonitorCommand[0], waitErr, strings.TrimSpace(stderr.String()))
+	}
+	return fmt.Errorf("%s exited", ciliumMonitorCommand[0])
+}
+
+// Read the output of cilium monitor and insert its JSON objects as records into given channel until given stop channel
+// is closed, skipping the other lines such as "Listening for events on 4 CPUs ..."
+func (monitor *CiliumMonitor) readOutput(output io.Reader, stopCh <-chan struct{}, recordCh chan<- Record) error {
+	scanner := bufio.NewScanner(output)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "{") {
+			continue
+		}
+		record := Record{Time: timeNow(), Host: monitor.hostName, Message: line, Source: ciliumMonitorSourceName}
+		select {
+		case recordCh <- record:
+		case <-stopCh:
+			return errStopped
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,213 @@
+package drop
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// log format of the JSON objects of Cilium drop notifications and Hubble flows
+var testCiliumFormat, _ = InitLogFormat(LogFormatCiliumJSON, "", time.UTC)
+
+// drop notification written by "cilium monitor --type drop -o json"
+const testCiliumDropNotify = `{"type":"drop","reason":"Policy denied","source":1234,"bytes":74,"srcLabel":5678,` +
+	`"dstLabel":9012,"summary":{"l2":{"src":"c6:ba:6e:31:56:c9","dst":"56:22:aa:30:c4:fe"},` +
+	`"l3":{"src":"11.111.11.111","dst":"22.222.22.222"},"l4":{"src":"56789","dst":"1234"},` +
+	`"tcp":"TCP 11.111.11.111:56789 -> 22.222.22.222:1234 syn"}}`
+
+// flow written by "hubble observe -o json"
+const testHubbleFlow = `{"flow":{"time":"2019-02-04T17:10:12.345678Z","verdict":"DROPPED",` +
+	`"drop_reason_desc":"POLICY_DENIED","ethernet":{"source":"c6:ba:6e:31:56:c9",` +
+	`"destination":"56:22:aa:30:c4:fe"},"IP":{"source":"fd00::1","destination":"fd00::2","ipVersion":"IPv6"},` +
+	`"l4":{"TCP":{"source_port":56789,"destination_port":1234,"flags":{"SYN":true}}},` +
+	`"source":{"identity":5678},"destination":{"identity":9012},"node_name":"node-1"}}`
+
+// Test if the drop notifications of cilium monitor are parsed with the drop reason matched by the log prefixes
+func TestParsingCiliumDropNotify(t *testing.T) {
+	channel := make(chan PacketDrop, 1)
+	curTime := time.Now()
+	logPrefixes := getTestLogPrefixes("Policy denied")
+	record := Record{Time: curTime, Host: testHostname, Message: testCiliumDropNotify, Source: "cilium-monitor"}
+	expected := PacketDrop{
+		LogTime:     curTime,
+		HostName:    testHostname,
+		LogPrefix:   logPrefixes[0],
+		Family:      FamilyIPv4,
+		SrcIP:       net.ParseIP(testSrcIP),
+		SrcPort:     testSrcPort,
+		DstIP:       net.ParseIP(testDstIP),
+		DstPort:     testDstPort,
+		Proto:       ProtoTCP,
+		DropReason:  "Policy denied",
+		SrcIdentity: 5678,
+		DstIdentity: 9012,
+		PrefixFields: map[string]string{
+			"drop_reason":  "Policy denied",
+			"src_identity": "5678",
+			"dst_identity": "9012",
+		},
+		Source: "cilium-monitor",
+	}
+	if err := parse(logPrefixes, testCiliumFormat, record, channel); err != nil {
+		t.Fatalf("Expected %+v, but got error %s", expected, err)
+	}
+	if result := <-channel; !reflect.DeepEqual(result, expected) {
+		t.Fatalf("Expected %+v, but got result %+v", expected, result)
+	}
+
+	// the drops of other reasons and the notifications other than drops are skipped
+	for _, message := range []string{
+		strings.Replace(testCiliumDropNotify, "Policy denied", "Stale or unroutable IP", 1),
+		strings.Replace(testCiliumDropNotify, `"type":"drop"`, `"type":"trace"`, 1),
+	} {
+		record := Record{Time: curTime, Message: message}
+		if err := parse(logPrefixes, testCiliumFormat, record, channel); err != nil {
+			t.Fatalf("Expected error nil, but got error %s", err)
+		}
+		if len(channel) != 0 {
+			t.Fatalf("Expected no packet drop of log %s, but got result %+v", message, <-channel)
+		}
+	}
+}
+
+// Test if the dropped flows of Hubble are decoded with their time and node name, and the other flows are skipped
+func TestDecodeHubbleFlow(t *testing.T) {
+	result, prefix, err := decodeCiliumJSON(testCiliumFormat, Record{Message: testHubbleFlow, Host: testHostname})
+	if err != nil {
+		t.Fatalf("Expected error nil, but got error %s", err)
+	}
+	expected := PacketDrop{
+		LogTime:     time.Date(2019, 2, 4, 17, 10, 12, 345678000, time.UTC),
+		HostName:    "node-1",
+		Family:      FamilyIPv6,
+		SrcIP:       net.ParseIP("fd00::1"),
+		SrcPort:     testSrcPort,
+		DstIP:       net.ParseIP("fd00::2"),
+		DstPort:     testDstPort,
+		Proto:       ProtoTCP,
+		TCPFlags:    TCPFlagSYN,
+		MacAddress:  "56:22:aa:30:c4:fe:c6:ba:6e:31:56:c9",
+		DropReason:  "POLICY_DENIED",
+		SrcIdentity: 5678,
+		DstIdentity: 9012,
+		PrefixFields: map[string]string{
+			"drop_reason":  "POLICY_DENIED",
+			"src_identity": "5678",
+			"dst_identity": "9012",
+		},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("Expected %+v, but got result %+v", expected, result)
+	}
+	if prefix != "POLICY_DENIED" {
+		t.Fatalf("Expected prefix POLICY_DENIED, but got result %s", prefix)
+	}
+
+	forwarded := strings.Replace(testHubbleFlow, `"verdict":"DROPPED"`, `"verdict":"FORWARDED"`, 1)
+	if _, _, err := decodeCiliumJSON(testCiliumFormat, Record{Message: forwarded}); err != errNotPacketDrop {
+		t.Fatalf("Expected error %v, but got error %v", errNotPacketDrop, err)
+	}
+}
+
+// Test if the drop notifications of ICMP are decoded without ports, although their summary has the layer 4 with empty
+// ports
+func TestDecodeCiliumDropNotifyIcmp(t *testing.T) {
+	message := `{"type":"drop","reason":"Policy denied","source":1234,"bytes":98,"srcLabel":5678,"dstLabel":9012,` +
+		`"summary":{"l2":{"src":"c6:ba:6e:31:56:c9","dst":"56:22:aa:30:c4:fe"},` +
+		`"l3":{"src":"11.111.11.111","dst":"22.222.22.222"},"l4":{"src":"","dst":""},` +
+		`"icmpv4":"ICMPv4 EchoRequest"}}`
+	result, _, err := decodeCiliumJSON(testCiliumFormat, Record{Message: message, Host: testHostname})
+	if err != nil {
+		t.Fatalf("Expected error nil, but got error %s", err)
+	}
+	if result.Proto != ProtoICMP || result.SrcPort != 0 || result.DstPort != 0 ||
+		!result.SrcIP.Equal(net.ParseIP(testSrcIP)) || !result.DstIP.Equal(net.ParseIP(testDstIP)) {
+		t.Fatalf("Expected ICMP packet drop from %v to %v, but got result %+v", testSrcIP, testDstIP, result)
+	}
+}
+
+// Test if the Cilium logs without IP addresses or of invalid JSON return errors
+func TestDecodeInvalidCiliumJSON(t *testing.T) {
+	for _, message := range []string{
+		`{"type":"drop","reason":"Policy denied"`,
+		`{"type":"drop","reason":"Policy denied","summary":{"l4":{"src":"56789","dst":"1234"}}}`,
+		strings.Replace(testCiliumDropNotify, `"src":"56789"`, `"src":"port"`, 1),
+		strings.Replace(testCiliumDropNotify, `"l4":{"src":"56789","dst":"1234"}`, `"l4":{"src":"","dst":""}`, 1),
+		`{"flow":{"time":"2019-02-04T17:10:12.345678Z","verdict":"DROPPED"}}`,
+		strings.Replace(testHubbleFlow, `"source":"fd00::1"`, `"source":"fd00::zz"`, 1),
+	} {
+		if result, _, err := decodeCiliumJSON(testCiliumFormat, Record{Message: message}); err == nil {
+			t.Fatalf("Expected error of log %s, but got result %+v", message, result)
+		}
+	}
+}
+
+// Test if reading the output of cilium monitor stops once the stop channel is closed, although nobody receives the
+// records
+func TestReadCiliumMonitorOutputStopped(t *testing.T) {
+	monitor := &CiliumMonitor{hostName: testHostname}
+	stopCh := make(chan struct{})
+	close(stopCh)
+	err := monitor.readOutput(strings.NewReader(testCiliumDropNotify+"\n"), stopCh, make(chan Record))
+	if err != errStopped {
+		t.Fatalf("Expected error %v, but got error %v", errStopped, err)
+	}
+}
+
+// Test if only the JSON objects written by cilium monitor are read as records
+func TestReadCiliumMonitorOutput(t *testing.T) {
+	defer func(now func() time.Time) { timeNow = now }(timeNow)
+	curTime := time.Now()
+	timeNow = func() time.Time { return curTime }
+
+	output := "Listening for events on 4 CPUs with 64x4096 of shared memory\n" +
+		"Press Ctrl-C to quit\n" + testCiliumDropNotify + "\n"
+	monitor := &CiliumMonitor{hostName: testHostname}
+	channel := make(chan Record, 10)
+	if err := monitor.readOutput(strings.NewReader(output), nil, channel); err != nil {
+		t.Fatalf("Expected no error, but got error %+v", err)
+	}
+	close(channel)
+	var records []Record
+	for record := range channel {
+		records = append(records, record)
+	}
+	expected := []Record{
+		{Time: curTime, Host: testHostname, Message: testCiliumDropNotify, Source: ciliumMonitorSourceName},
+	}
+	if !reflect.DeepEqual(records, expected) {
+		t.Fatalf("Expected %+v, but got result %+v", expected, records)
+	}
+}
+
+// Test if cilium monitor is stopped once the stop channel is closed, and its exit is returned as error
+func TestRunCiliumMonitor(t *testing.T) {
+	defer func(command []string) { ciliumMonitorCommand = command }(ciliumMonitorCommand)
+	ciliumMonitorCommand = []string{"sh", "-c", "echo '" + testCiliumDropNotify + "'; exec sleep 10"}
+
+	monitor := &CiliumMonitor{hostName: testHostname}
+	stopCh := make(chan struct{})
+	channel := make(chan Record, 10)
+	errCh := make(chan error, 1)
+	go func() { errCh <- monitor.Run(stopCh, channel) }()
+
+	select {
+	case record := <-channel:
+		if record.Message != testCiliumDropNotify {
+			t.Fatalf("Expected record of %s, but got result %+v", testCiliumDropNotify, record)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected a record, but got none")
+	}
+	close(stopCh)
+	if err := <-errCh; err != nil {
+		t.Fatalf("Expected no error, but got error %+v", err)
+	}
+
+	ciliumMonitorCommand = []string{"false"}
+	if err := monitor.Run(make(chan struct{}), channel); err == nil {
+		t.Fatal("Expected error of exited cilium monitor, but got nil")
+	}
+}
@@ -0,0 +1,146 @@
+package drop
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ethertypes of the link layer header logged by ebtables, arptables and nftables of bridge family
+const (
+	EtherTypeIPv4 uint16 = 0x0800
+	EtherTypeARP  uint16 = 0x0806
+	EtherTypeIPv6 uint16 = 0x86dd
+)
+
+// names of the ARP operations, which are logged by their numbers
+var arpOpcodeNames = map[uint16]string{
+	1: "request",
+	2: "reply",
+}
+
+// fields of ARP packets, named as nftables logs them
+const fieldArpSrcIP = "IPSRC"
+const fieldArpDstIP = "IPDST"
+const fieldArpOpcode = "OPCODE"
+
+// log of ebtables ("... MAC source = 00:11:22:33:44:55 ...") or of ARP packets ("... ARP HTYPE=1 ...")
+var bridgeLogRegexp = regexp.MustCompile(`(?:^|\s)(?:MAC source = |ARP HTYPE=)`)
+
+// ebtables logs the fields in its own layout, e.g. "MAC source = 00:11:22:33:44:55 ... proto = 0x0800 IP SRC=10.0.0.1
+// IP DST=10.0.0.2, IP tos=0x00, IP proto=6 SPT=51234 DPT=80", rewrite them into the fields of iptables LOG and nftables
+var ebtablesFieldReplacer = strings.NewReplacer(
+	"MAC source = ", fieldMacSrc+"=",
+	"MAC dest = ", fieldMacDst+"=",
+	"proto = 0x", fieldMacProto+"=",
+	// the sender and target of ARP packets, whose hardware addresses are not the ones of the link layer header
+	"ARP MAC SRC=", "ARP_SHA=",
+	"ARP IP SRC=", fieldArpSrcIP+"=",
+	"ARP MAC DST=", "ARP_THA=",
+	"ARP IP DST=", fieldArpDstIP+"=",
+	"IP SRC=", fieldSrcIP+"=",
+	"IP DST=", fieldDstIP+"=",
+	"IPv6 SRC=", fieldSrcIP+"=",
+	"IPv6 DST=", fieldDstIP+"=",
+	// only the separators ebtables writes after its fields are removed, the values of other fields are kept as logged
+	", IP tos=", " "+fieldTos+"=",
+	", IP proto=", " "+fieldProto+"=",
+	", IPv6 priority=", " PRIORITY=",
+	", Next Header=", " "+fieldProto+"=",
+	", PTYPE=", " PTYPE=",
+	", "+fieldArpOpcode+"=", " "+fieldArpOpcode+"=",
+)
+
+// Check if given log payload is written by ebtables, or is of an ARP packet logged by arptables or nftables
+func isBridgeLog(payload string) bool {
+	return bridgeLogRegexp.MatchString(payload)
+}
+
+// Return a PacketDrop object of given log payload written by ebtables or arptables, with the MAC addresses and the
+// ethertype of the link layer header. The IP addresses of ARP packets are the ones of the sender and the target, and
+// ARP packets have neither protocol nor ports. Return errNotPacketDrop if the log has no IP header nor ARP header.
+func getBridgePacketDrop(payload string) (PacketDrop, error) {
+	logFields, err := getPacketDropLogFields(ebtablesFieldReplacer.Replace(payload))
+	if err != nil {
+		return PacketDrop{}, err
+	}
+	logFieldMap := getFieldMap(logFields)
+	pd := PacketDrop{
+		InterfaceSent: logFieldMap[fieldInterfaceSent],
+		MacAddress:    getMacAddress(logFields),
+	}
+	interfaceReceived, ok := logFieldMap[fieldInterfaceReceived]
+	if !ok {
+		return PacketDrop{}, &ParseError{Field: fieldInterfaceReceived, Err: ErrMissingField}
+	}
+	pd.InterfaceReceived = interfaceReceived
+	if err := setLinkLayerFields(&pd, logFieldMap); err != nil {
+		return PacketDrop{}, err
+	}
+
+	if _, ok := logFieldMap[fieldArpOpcode]; ok {
+		// arptables and nftables of arp family don't log the link layer header
+		if pd.EtherType == 0 {
+			pd.EtherType = EtherTypeARP
+		}
+		opcode, err := getUintField(logFieldMap, fieldArpOpcode, 16)
+		if err != nil {
+			return PacketDrop{}, err
+		}
+		pd.ArpOpcode = uint16(opcode)
+		if pd.SrcIP, err = getIPField(logFieldMap, fieldArpSrcIP); err != nil {
+			return PacketDrop{}, err
+		}
+		if pd.DstIP, err = getIPField(logFieldMap, fieldArpDstIP); err != nil {
+			return PacketDrop{}, err
+		}
+		pd.Family = getFamily(pd.SrcIP)
+		return pd, nil
+	}
+
+	// without "--log-ip" or "--log-ip6" ebtables logs the link layer header only, which doesn't locate the packet
+	if _, ok := logFieldMap[fieldSrcIP]; !ok && pd.EtherType != EtherTypeARP {
+		return PacketDrop{}, errNotPacketDrop
+	}
+	if pd.SrcIP, err = getIPField(logFieldMap, fieldSrcIP); err != nil {
+		return PacketDrop{}, err
+	}
+	if pd.DstIP, err = getIPField(logFieldMap, fieldDstIP); err != nil {
+		return PacketDrop{}, err
+	}
+	if pd.Proto, err = getProtocolField(logFieldMap, fieldProto); err != nil {
+		return PacketDrop{}, err
+	}
+	if pd.SrcPort, pd.DstPort, err = getPortFields(logFieldMap, pd.Proto); err != nil {
+		return PacketDrop{}, err
+	}
+	tos, err := getUintField(logFieldMap, fieldTos, 8)
+	if err != nil {
+		return PacketDrop{}, err
+	}
+	pd.Tos = uint8(tos)
+	pd.Family = getFamily(pd.SrcIP)
+	return pd, nil
+}
+
+// Helper function to set the MAC addresses and the ethertype of given PacketDrop from the separate MAC fields logged by
+// ebtables and nftables of bridge and netdev families
+func setLinkLayerFields(pd *PacketDrop, logFieldMap map[string]string) error {
+	pd.SrcMacAddress, pd.DstMacAddress = logFieldMap[fieldMacSrc], logFieldMap[fieldMacDst]
+	if value, ok := logFieldMap[fieldMacProto]; ok {
+		etherType, err := strconv.ParseUint(value, 16, 16)
+		if err != nil {
+			return &ParseError{Field: fieldMacProto, Value: value, Err: err}
+		}
+		pd.EtherType = uint16(etherType)
+	}
+	return nil
+}
+
+// Return the name of the ARP operation of given PacketDrop, e.g. "request", or its number if it has no name
+func GetArpOpcodeName(pd PacketDrop) string {
+	if name, ok := arpOpcodeNames[pd.ArpOpcode]; ok {
+		return name
+	}
+	return strconv.Itoa(int(pd.ArpOpcode))
+}
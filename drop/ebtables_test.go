@@ -0,0 +1,129 @@
+package drop
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/box/kube-iptables-tailer/util"
+)
+
+// Test if packet parser works for the logs written by ebtables and arptables
+func TestParsingBridgeDropLog(t *testing.T) {
+	curTime := time.Now().Truncate(time.Second)
+	logTime := curTime.Format(util.DefaultPacketDropLogTimeLayout)
+	testCases := []struct {
+		log      string
+		expected PacketDrop
+	}{
+		{
+			// ebtables "--log-ip" of a TCP packet
+			log: "kernel: ebt-drop: IN=vethb1c2d3 OUT=veth4e5f6a MAC source = 0a:58:0a:f4:01:05 " +
+				"MAC dest = 0a:58:0a:f4:01:07 proto = 0x0800 IP SRC=10.244.1.5 IP DST=10.244.1.7, IP tos=0x10, " +
+				"IP proto=6 SPT=42312 DPT=8080",
+			expected: PacketDrop{Family: FamilyIPv4, SrcIP: net.ParseIP("10.244.1.5"), SrcPort: 42312,
+				DstIP: net.ParseIP("10.244.1.7"), DstPort: 8080, Proto: ProtoTCP, InterfaceReceived: "vethb1c2d3",
+				InterfaceSent: "veth4e5f6a", MacAddress: "0a:58:0a:f4:01:07:0a:58:0a:f4:01:05:08:00",
+				SrcMacAddress: "0a:58:0a:f4:01:05", DstMacAddress: "0a:58:0a:f4:01:07", EtherType: EtherTypeIPv4,
+				Tos: 0x10},
+		},
+		{
+			// ebtables "--log-ip6" of an ICMPv6 packet, which has no ports
+			log: "kernel: ebt-drop: IN=vethb1c2d3 OUT=veth4e5f6a MAC source = 0a:58:0a:f4:01:05 " +
+				"MAC dest = 0a:58:0a:f4:01:07 proto = 0x86dd IPv6 SRC=fd00:10:244:1::5 IPv6 DST=fd00:10:244:1::7, " +
+				"IPv6 priority=0x0, Next Header=58",
+			expected: PacketDrop{Family: FamilyIPv6, SrcIP: net.ParseIP("fd00:10:244:1::5"),
+				DstIP: net.ParseIP("fd00:10:244:1::7"), Proto: ProtoICMPv6, InterfaceReceived: "vethb1c2d3",
+				InterfaceSent: "veth4e5f6a", MacAddress: "0a:58:0a:f4:01:07:0a:58:0a:f4:01:05:86:dd",
+				SrcMacAddress: "0a:58:0a:f4:01:05", DstMacAddress: "0a:58:0a:f4:01:07", EtherType: EtherTypeIPv6},
+		},
+		{
+			// ebtables "--log-arp" of an ARP request
+			log: "kernel: ebt-drop: IN=vethb1c2d3 OUT= MAC source = 0a:58:0a:f4:01:05 MAC dest = ff:ff:ff:ff:ff:ff " +
+				"proto = 0x0806 ARP HTYPE=1, PTYPE=0x0800, OPCODE=1 ARP MAC SRC=0a:58:0a:f4:01:05 " +
+				"ARP IP SRC=10.244.1.5 ARP MAC DST=00:00:00:00:00:00 ARP IP DST=10.244.1.7",
+			expected: PacketDrop{Family: FamilyIPv4, SrcIP: net.ParseIP("10.244.1.5"),
+				DstIP: net.ParseIP("10.244.1.7"), InterfaceReceived: "vethb1c2d3",
+				MacAddress: "ff:ff:ff:ff:ff:ff:0a:58:0a:f4:01:05:08:06", SrcMacAddress: "0a:58:0a:f4:01:05",
+				DstMacAddress: "ff:ff:ff:ff:ff:ff", EtherType: EtherTypeARP, ArpOpcode: 1},
+		},
+		{
+			// arptables of nftables, which logs the ARP packet without link layer header
+			log: "kernel: ebt-drop: IN=eth0 OUT= ARP HTYPE=1 PTYPE=0x0800 OPCODE=2 MACSRC=52:54:00:65:43:21 " +
+				"IPSRC=192.168.10.4 MACDST=52:54:00:12:34:56 IPDST=192.168.10.1",
+			expected: PacketDrop{Family: FamilyIPv4, SrcIP: net.ParseIP("192.168.10.4"),
+				DstIP: net.ParseIP("192.168.10.1"), InterfaceReceived: "eth0",
+				MacAddress: "52:54:00:12:34:56:52:54:00:65:43:21", SrcMacAddress: "52:54:00:65:43:21",
+				DstMacAddress: "52:54:00:12:34:56", EtherType: EtherTypeARP, ArpOpcode: 2},
+		},
+	}
+
+	logPrefixes := getTestLogPrefixes("ebt-drop:")
+	for _, testCase := range testCases {
+		channel := make(chan PacketDrop, 1)
+		testLog := fmt.Sprintf("%s %s %s", logTime, testHostname, testCase.log)
+		err := parse(logPrefixes, testLogFormat, Record{Message: testLog}, channel)
+		if err != nil {
+			t.Fatalf("Expected %+v, but got error %s", testCase.expected, err)
+		}
+
+		expected := testCase.expected
+		expected.LogTime = curTime
+		expected.HostName = testHostname
+		expected.LogPrefix = logPrefixes[0]
+		result := <-channel
+		if !reflect.DeepEqual(result, expected) {
+			t.Fatalf("Expected %+v, but got result %+v", expected, result)
+		}
+	}
+}
+
+// Test if the logs of ebtables without IP header are not packet drops, and are skipped by the parser
+func TestParsingBridgeLogWithoutIP(t *testing.T) {
+	// ebtables without "--log-ip"
+	payload := "IN=vethb1c2d3 OUT=veth4e5f6a MAC source = 0a:58:0a:f4:01:05 MAC dest = 0a:58:0a:f4:01:07 proto = 0x0800"
+	if result, err := getBridgePacketDrop(payload); err != errNotPacketDrop {
+		t.Fatalf("Expected error %v, but got result %+v and error %v", errNotPacketDrop, result, err)
+	}
+
+	channel := make(chan PacketDrop, 1)
+	testLog := fmt.Sprintf("%s %s ebt-drop: %s", time.Now().Format(util.DefaultPacketDropLogTimeLayout), testHostname,
+		payload)
+	if err := parse(getTestLogPrefixes("ebt-drop:"), testLogFormat, Record{Message: testLog}, channel); err != nil {
+		t.Fatalf("Expected error nil, but got error %s", err)
+	}
+	if len(channel) != 0 {
+		t.Fatalf("Expected no packet drop, but got result %+v", <-channel)
+	}
+}
+
+// Test if the logs of ebtables and arptables with invalid or missing IP fields return errors
+func TestGetInvalidBridgePacketDrop(t *testing.T) {
+	for _, payload := range []string{
+		// ARP of other hardware types than Ethernet
+		"IN=vethb1c2d3 OUT= MAC source = 0a:58:0a:f4:01:05 MAC dest = ff:ff:ff:ff:ff:ff proto = 0x0806 " +
+			"ARP HTYPE=6, PTYPE=0x0800, OPCODE=1",
+		"OUT=veth4e5f6a MAC source = 0a:58:0a:f4:01:05 MAC dest = 0a:58:0a:f4:01:07 proto = 0x0800 " +
+			"IP SRC=10.244.1.5 IP DST=10.244.1.7, IP tos=0x00, IP proto=6 SPT=42312 DPT=8080",
+		"IN=vethb1c2d3 OUT=veth4e5f6a MAC source = 0a:58:0a:f4:01:05 MAC dest = 0a:58:0a:f4:01:07 proto = 0xzz " +
+			"IP SRC=10.244.1.5 IP DST=10.244.1.7, IP tos=0x00, IP proto=6 SPT=42312 DPT=8080",
+		// TCP packet without ports
+		"IN=vethb1c2d3 OUT=veth4e5f6a MAC source = 0a:58:0a:f4:01:05 MAC dest = 0a:58:0a:f4:01:07 proto = 0x0800 " +
+			"IP SRC=10.244.1.5 IP DST=10.244.1.7, IP tos=0x00, IP proto=6",
+	} {
+		if result, err := getBridgePacketDrop(payload); err == nil {
+			t.Fatalf("Expected error of log %s, but got result %+v", payload, result)
+		}
+	}
+}
+
+// Test if the names of ARP operations are returned
+func TestGetArpOpcodeName(t *testing.T) {
+	for opcode, expected := range map[uint16]string{1: "request", 2: "reply", 8: "8"} {
+		if result := GetArpOpcodeName(PacketDrop{ArpOpcode: opcode}); result != expected {
+			t.Fatalf("Expected %s, but got result %s", expected, result)
+		}
+	}
+}
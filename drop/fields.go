@@ -0,0 +1,193 @@
+package drop
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// ErrMissingField is the cause of ParseError when a required field is not logged
+var ErrMissingField = errors.New("missing field")
+
+// ParseError is returned when a field of packet drop log is missing or has an invalid value
+type ParseError struct {
+	Field string
+	Value string
+	Err   error
+}
+
+func (err *ParseError) Error() string {
+	if err.Err == ErrMissingField {
+		return fmt.Sprintf("Missing field=%v", err.Field)
+	}
+	return fmt.Sprintf("Invalid value: field=%v value=%v", err.Field, err.Value)
+}
+
+func (err *ParseError) Unwrap() error {
+	return err.Err
+}
+
+// TCPFlags is the set of flags of a TCP header, using the same bits as the header
+type TCPFlags uint8
+
+const (
+	TCPFlagFIN TCPFlags = 1 << iota
+	TCPFlagSYN
+	TCPFlagRST
+	TCPFlagPSH
+	TCPFlagACK
+	TCPFlagURG
+	TCPFlagECE
+	TCPFlagCWR
+)
+
+// names of TCP flags in the order iptables LOG prints them
+var tcpFlagNames = []struct {
+	flag TCPFlags
+	name string
+}{
+	{TCPFlagCWR, "CWR"},
+	{TCPFlagECE, "ECE"},
+	{TCPFlagURG, "URG"},
+	{TCPFlagACK, "ACK"},
+	{TCPFlagPSH, "PSH"},
+	{TCPFlagRST, "RST"},
+	{TCPFlagSYN, "SYN"},
+	{TCPFlagFIN, "FIN"},
+}
+
+// Return the flags separated by space as iptables LOG prints them, e.g. "ACK SYN"
+func (flags TCPFlags) String() string {
+	var names []string
+	for _, flagName := range tcpFlagNames {
+		if flags&flagName.flag != 0 {
+			names = append(names, flagName.name)
+		}
+	}
+	return strings.Join(names, " ")
+}
+
+// Check if the flags only have SYN set, which is the first packet of a new connection
+func (flags TCPFlags) IsNewConnection() bool {
+	return flags == TCPFlagSYN
+}
+
+// Helper function to get the TCP flags logged as separate words, e.g. "... RES=0x00 ACK SYN URGP=0"
+func getTCPFlags(fields []string) TCPFlags {
+	var flags TCPFlags
+	for _, flagName := range tcpFlagNames {
+		if hasFlag(fields, flagName.name) {
+			flags |= flagName.flag
+		}
+	}
+	return flags
+}
+
+// Helper function to map the given fields by their names: "KEY=VALUE" is mapped as KEY to VALUE, "FRAG:123" is
+// mapped as FRAG to 123, and "OPT (0204)" is mapped as OPT to 0204. Only the first occurrence of each name is kept.
+func getFieldMap(fields []string) map[string]string {
+	fieldMap := make(map[string]string)
+	for i := 0; i < len(fields); i++ {
+		var name, value string
+		if parts := strings.SplitN(fields[i], "=", 2); len(parts) == 2 {
+			name, value = parts[0], parts[1]
+		} else if parts := strings.SplitN(fields[i], ":", 2); len(parts) == 2 && parts[0] == fieldFragment {
+			name, value = parts[0], parts[1]
+		} else if fields[i] == fieldOptions && i+1 < len(fields) && strings.HasPrefix(fields[i+1], "(") {
+			name, value = fieldOptions, strings.Trim(fields[i+1], "()")
+			i++
+		} else {
+			continue
+		}
+		if _, ok := fieldMap[name]; !ok {
+			fieldMap[name] = value
+		}
+	}
+	return fieldMap
+}
+
+// Helper function to get the unsigned integer of given field name with given bit size, decimal and "0x" prefixed
+// hexadecimal values are both accepted. Return 0 if the field is not found.
+func getUintField(fieldMap map[string]string, fieldName string, bitSize int) (uint64, error) {
+	value, ok := fieldMap[fieldName]
+	if !ok {
+		return 0, nil
+	}
+	number, err := strconv.ParseUint(value, 0, bitSize)
+	if err != nil {
+		return 0, &ParseError{Field: fieldName, Value: value, Err: err}
+	}
+	return number, nil
+}
+
+// Helper function to get the unsigned 32-bit integer of given field name, return nil if the field is not logged
+func getOptionalUint32Field(fieldMap map[string]string, fieldName string) (*uint32, error) {
+	if _, ok := fieldMap[fieldName]; !ok {
+		return nil, nil
+	}
+	number, err := getUintField(fieldMap, fieldName, 32)
+	if err != nil {
+		return nil, err
+	}
+	value := uint32(number)
+	return &value, nil
+}
+
+// Helper function to get the ports of given protocol, which are required if the protocol has ports, unless the packet is
+// a fragment after the first one and has no transport header
+func getPortFields(fieldMap map[string]string, proto Protocol) (uint16, uint16, error) {
+	if fragment := fieldMap[fieldFragment]; proto.HasPorts() && (fragment == "" || fragment == "0") {
+		for _, fieldName := range []string{fieldSrcPort, fieldDstPort} {
+			if _, ok := fieldMap[fieldName]; !ok {
+				return 0, 0, &ParseError{Field: fieldName, Err: ErrMissingField}
+			}
+		}
+	}
+	srcPort, err := getUintField(fieldMap, fieldSrcPort, 16)
+	if err != nil {
+		return 0, 0, err
+	}
+	dstPort, err := getUintField(fieldMap, fieldDstPort, 16)
+	if err != nil {
+		return 0, 0, err
+	}
+	return uint16(srcPort), uint16(dstPort), nil
+}
+
+// Helper function to get the IP address of given field name in its 16-byte form, e.g. "SRC=10.0.0.1"
+func getIPField(fieldMap map[string]string, fieldName string) (net.IP, error) {
+	value, ok := fieldMap[fieldName]
+	if !ok {
+		return nil, &ParseError{Field: fieldName, Err: ErrMissingField}
+	}
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil, &ParseError{Field: fieldName, Value: value}
+	}
+	return ip, nil
+}
+
+// Helper function to get the protocol of given field name, e.g. "PROTO=TCP"
+func getProtocolField(fieldMap map[string]string, fieldName string) (Protocol, error) {
+	value, ok := fieldMap[fieldName]
+	if !ok {
+		return 0, &ParseError{Field: fieldName, Err: ErrMissingField}
+	}
+	proto, err := ParseProtocol(value)
+	if err != nil {
+		return 0, &ParseError{Field: fieldName, Value: value}
+	}
+	return proto, nil
+}
+
+// Helper function to check if the flag of given name is logged as a separate word, e.g. "DF" or "SYN"
+func hasFlag(fields []string, flagName string) bool {
+	for _, field := range fields {
+		if field == flagName {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,38 @@
+package drop
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Test if TCPFlags prints the flags in the same order as iptables LOG
+func TestTCPFlagsString(t *testing.T) {
+	flags := getTCPFlags([]string{"WINDOW=501", "RES=0x00", "ACK", "PSH", "FIN", "URGP=0"})
+	if flags != TCPFlagACK|TCPFlagPSH|TCPFlagFIN {
+		t.Fatalf("Expected flags %08b, but got flags %08b", TCPFlagACK|TCPFlagPSH|TCPFlagFIN, flags)
+	}
+	if flags.String() != "ACK PSH FIN" {
+		t.Fatalf("Expected %v, but got result %v", "ACK PSH FIN", flags.String())
+	}
+	if flags.IsNewConnection() || !TCPFlagSYN.IsNewConnection() {
+		t.Fatal("Expected only SYN to be a new connection")
+	}
+}
+
+// Test if getFieldMap() maps all the forms of fields logged by iptables LOG
+func TestGetFieldMap(t *testing.T) {
+	fields := []string{"LEN=60", "DF", "FRAG:185", "OPT", "(0204)", "ID=1", "ID=2", "INVALID=1"}
+	expected := map[string]string{"LEN": "60", "FRAG": "185", "OPT": "0204", "ID": "1", "INVALID": "1"}
+	result := getFieldMap(fields)
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("Expected %v, but got result %v", expected, result)
+	}
+
+	number, err := getUintField(result, "FRAG", 16)
+	if err != nil || number != 185 {
+		t.Fatalf("Expected 185, but got result %v and error %v", number, err)
+	}
+	if _, err := getUintField(map[string]string{"TOS": "0x100"}, "TOS", 8); err == nil {
+		t.Fatal("Expected error from value out of range, but got nil")
+	}
+}
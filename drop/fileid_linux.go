@@ -0,0 +1,17 @@
+// +build linux
+
+package drop
+
+import (
+	"os"
+	"syscall"
+)
+
+// Get the device and inode identifying given file, return false if they are not available
+func getFileID(info os.FileInfo) (uint64, uint64, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return uint64(stat.Dev), stat.Ino, true
+}
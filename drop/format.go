@@ -0,0 +1,261 @@
+package drop
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/box/kube-iptables-tailer/util"
+)
+
+// names of the parts of a log format
+const (
+	formatFieldTimestamp = "timestamp"
+	formatFieldHost      = "host"
+	formatFieldPayload   = "payload"
+)
+
+// names of the built-in log formats
+const (
+	LogFormatDefault  = "default"
+	LogFormatRsyslog  = "rsyslog"
+	LogFormatSyslogNg = "syslog-ng"
+	LogFormatRFC3164  = "rfc3164"
+	LogFormatRFC5424  = "rfc5424"
+	LogFormatDmesg    = "dmesg"
+
+	LogFormatUlogdJSON      = "ulogd2-json"
+	LogFormatCiliumJSON     = "cilium-json"
+	LogFormatAntrea         = "antrea"
+	LogFormatAWSPolicyAgent = "aws-network-policy-agent"
+)
+
+// time layout of the BSD syslog timestamp, which has neither year nor time zone
+const rfc3164TimeLayout = "Jan _2 15:04:05"
+
+// syslog severities which rsyslog templates may write in front of the host name, e.g. "kern.warning"
+const syslogSeverityPattern = `(?:[a-z]+[0-9]?\.(?:emerg|alert|crit|err|error|warn|warning|notice|info|debug)|` +
+	`<\d{1,3}>)`
+
+// built-in log formats with the time layouts of their timestamps
+var logFormatPresets = map[string]struct {
+	pattern    string
+	timeLayout string
+}{
+	// "2019-02-04T10:10:12.345678-07:00 hostname ..." of RSYSLOG_FileFormat and the time layout set by user
+	LogFormatDefault: {
+		pattern:    `^\s*(?P<timestamp>\S+)\s+(?P<host>\S+)\s+(?P<payload>.*)$`,
+		timeLayout: util.DefaultPacketDropLogTimeLayout,
+	},
+	// "2019-02-04T10:10:12.345678-07:00 kern.warning hostname ..." of RSYSLOG_FileFormat and templates adding the
+	// facility and severity, or the priority, before the host name
+	LogFormatRsyslog: {
+		pattern: `^\s*(?P<timestamp>\d{4}-\d{2}-\d{2}T\S+)\s+(?:` + syslogSeverityPattern + `\s+)?` +
+			`(?P<host>\S+)\s+(?P<payload>.*)$`,
+		timeLayout: time.RFC3339Nano,
+	},
+	// "Feb  4 10:10:12 hostname ..." of the default file template of syslog-ng
+	LogFormatSyslogNg: {
+		pattern:    `^\s*(?P<timestamp>[A-Z][a-z]{2}\s+\d{1,2}\s+\d{2}:\d{2}:\d{2})\s+(?P<host>\S+)\s+(?P<payload>.*)$`,
+		timeLayout: rfc3164TimeLayout,
+	},
+	// "<4>Feb  4 10:10:12 hostname kernel: ..." of BSD syslog, where the priority is optional
+	LogFormatRFC3164: {
+		pattern: `^\s*(?:<\d{1,3}>)?(?P<timestamp>[A-Z][a-z]{2}\s+\d{1,2}\s+\d{2}:\d{2}:\d{2})\s+` +
+			`(?P<host>\S+)\s+(?P<payload>.*)$`,
+		timeLayout: rfc3164TimeLayout,
+	},
+	// "<4>1 2019-02-04T10:10:12.345678-07:00 hostname kernel - - - ...", where the host name may be nil ("-")
+	LogFormatRFC5424: {
+		pattern: `^\s*(?:<\d{1,3}>)?\d{1,2}\s+(?P<timestamp>\S+)\s+(?P<host>\S+)\s+\S+\s+\S+\s+\S+\s+` +
+			`(?:-|(?:\[(?:[^\]\\]|\\.)*\])+)\s*(?P<payload>.*)$`,
+		timeLayout: time.RFC3339Nano,
+	},
+	// "[12345.678901] ..." of dmesg and kernel logs without syslog header, timestamped by the seconds since boot
+	LogFormatDmesg: {
+		pattern:    `^\s*(?P<timestamp>\[\s*\d+\.\d+\])\s*(?P<payload>.*)$`,
+		timeLayout: TimeLayoutUptime,
+	},
+}
+
+// packetDecoder decodes a structured log (e.g. a JSON object) into PacketDrop directly, and returns the log prefix of
+// the packet to match separately from the fields. It returns errNotPacketDrop if the log is not of a dropped packet.
+type packetDecoder func(format *LogFormat, record Record) (PacketDrop, string, error)
+
+// errNotPacketDrop is returned by packetDecoder for the structured logs which are not of dropped packets, and for the
+// logs of ebtables without the IP header
+var errNotPacketDrop = errors.New("not a packet drop")
+
+// built-in log formats of structured logs with the time layouts of their timestamps
+var structuredLogFormats = map[string]struct {
+	decode     packetDecoder
+	timeLayout string
+}{
+	// JSON objects of the JSON output plugin of ulogd2, one per line
+	LogFormatUlogdJSON: {
+		decode:     decodeUlogdJSON,
+		timeLayout: ulogdTimeLayout,
+	},
+	// JSON objects of the drop notifications of "cilium monitor", or of the flows of Hubble, one per line
+	LogFormatCiliumJSON: {
+		decode:     decodeCiliumJSON,
+		timeLayout: time.RFC3339Nano,
+	},
+	// NetworkPolicy audit logs of Antrea, e.g. /var/log/antrea/networkpolicy/np.log
+	LogFormatAntrea: {
+		decode:     decodeAntreaLog,
+		timeLayout: antreaTimeLayout,
+	},
+	// JSON objects written by the network policy agent of AWS VPC CNI, e.g.
+	// /var/log/aws-routed-eni/network-policy-agent.log
+	LogFormatAWSPolicyAgent: {
+		decode:     decodeAWSPolicyAgentJSON,
+		timeLayout: time.RFC3339Nano,
+	},
+}
+
+// patterns of grok-like log formats, e.g. "%{TIMESTAMP_ISO8601:timestamp} %{HOSTNAME:host} %{GREEDYDATA:payload}"
+var grokPatterns = map[string]string{
+	"TIMESTAMP_ISO8601": `\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}(?::\d{2}(?:\.\d+)?)?(?:Z|[+-]\d{2}:?\d{2})?`,
+	"SYSLOGTIMESTAMP":   `[A-Z][a-z]{2}\s+\d{1,2}\s+\d{2}:\d{2}:\d{2}`,
+	"SYSLOGPRI":         `<\d{1,3}>`,
+	"SYSLOGSEVERITY":    syslogSeverityPattern,
+	"HOSTNAME":          `[0-9A-Za-z][0-9A-Za-z.\-_]*`,
+	"IPORHOST":          `[0-9A-Za-z:.\-_\[\]]+`,
+	"WORD":              `\w+`,
+	"NOTSPACE":          `\S+`,
+	"SPACE":             `\s*`,
+	"INT":               `[+-]?\d+`,
+	"NUMBER":            `[+-]?\d+(?:\.\d+)?`,
+	"DATA":              `.*?`,
+	"GREEDYDATA":        `.*`,
+}
+
+// placeholder of grok-like log formats, e.g. "%{HOSTNAME:host}" or "%{SPACE}"
+var grokRegexp = regexp.MustCompile(`%\{(\w+)(?::(\w+))?\}`)
+
+// LogFormat tells where the timestamp, host name and payload (the prefix and KEY=VALUE fields) sit in a packet drop
+// log. It is either one of the built-in formats, a grok-like pattern or a regular expression with the named groups
+// "timestamp", "payload" and optionally "host". The timestamp is parsed by the first matching one of TimeLayouts,
+// and is in Location if it has no time zone. The built-in formats of structured logs (e.g. "ulogd2-json") decode the
+// whole log into PacketDrop instead.
+type LogFormat struct {
+	Format      string
+	TimeLayouts []string
+	Location    *time.Location
+
+	regexp         *regexp.Regexp
+	decode         packetDecoder // nil unless the log format is of structured logs
+	lock           sync.Mutex
+	lastTimeLayout int       // index of the time layout which parsed the last timestamp
+	bootTime       time.Time // used by TimeLayoutUptime, read on its first use
+	hostName       string    // used by structured logs without host name, read on its first use
+}
+
+// Init a log format of given built-in name, grok-like pattern or regular expression and return its pointer. The time
+// layouts are separated by "|" and may include "auto" and "uptime". The time layout of the built-in format, or "auto"
+// for others, is used if given time layout is empty. Local time is used if given location is nil.
+func InitLogFormat(format, timeLayout string, location *time.Location) (*LogFormat, error) {
+	if format == "" {
+		format = LogFormatDefault
+	}
+	if location == nil {
+		location = time.Local
+	}
+	if structured, ok := structuredLogFormats[strings.ToLower(format)]; ok {
+		if timeLayout == "" {
+			timeLayout = structured.timeLayout
+		}
+		return &LogFormat{Format: format, TimeLayouts: getTimeLayouts(timeLayout), Location: location,
+			decode: structured.decode}, nil
+	}
+	pattern := format
+	if preset, ok := logFormatPresets[strings.ToLower(format)]; ok {
+		pattern = preset.pattern
+		if timeLayout == "" {
+			timeLayout = preset.timeLayout
+		}
+	} else if grokRegexp.MatchString(format) {
+		var err error
+		if pattern, err = getGrokPattern(format); err != nil {
+			return nil, err
+		}
+	}
+	timeLayouts := getTimeLayouts(timeLayout)
+
+	compiled, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid log format %s: %v", format, err)
+	}
+	names := make(map[string]bool)
+	for _, name := range compiled.SubexpNames() {
+		names[name] = true
+	}
+	for _, name := range []string{formatFieldTimestamp, formatFieldPayload} {
+		if !names[name] {
+			return nil, fmt.Errorf("Invalid log format %s: missing group %s", format, name)
+		}
+	}
+	return &LogFormat{Format: format, TimeLayouts: timeLayouts, Location: location, regexp: compiled}, nil
+}
+
+// Helper function to get the regular expression of given grok-like pattern, where "%{NAME:group}" becomes a group
+// matching the pattern NAME and "%{NAME}" matches it without a group
+func getGrokPattern(format string) (string, error) {
+	var err error
+	pattern := grokRegexp.ReplaceAllStringFunc(format, func(placeholder string) string {
+		match := grokRegexp.FindStringSubmatch(placeholder)
+		grokPattern, ok := grokPatterns[match[1]]
+		if !ok {
+			err = fmt.Errorf("Invalid log format %s: unknown pattern %s", format, match[1])
+			return placeholder
+		}
+		if match[2] == "" {
+			return fmt.Sprintf("(?:%s)", grokPattern)
+		}
+		return fmt.Sprintf("(?P<%s>%s)", match[2], grokPattern)
+	})
+	if err != nil {
+		return "", err
+	}
+	return "^" + pattern + "$", nil
+}
+
+// Return the log time, host name and payload of given log, or error if the log does not match the log format. The
+// payload of structured logs is the whole log.
+func (format *LogFormat) Parse(log string) (time.Time, string, string, error) {
+	if format.decode != nil {
+		pd, _, err := format.decode(format, Record{Message: log})
+		if err != nil {
+			return time.Time{}, "", "", err
+		}
+		return pd.LogTime, pd.HostName, log, nil
+	}
+	match := format.regexp.FindStringSubmatch(strings.TrimRight(log, "\r\n"))
+	if match == nil {
+		return time.Time{}, "", "", errors.New(fmt.Sprintf("Invalid packet drop: log=%+v", log))
+	}
+	var timestamp, host, payload string
+	for i, name := range format.regexp.SubexpNames() {
+		switch name {
+		case formatFieldTimestamp:
+			timestamp = match[i]
+		case formatFieldHost:
+			host = match[i]
+		case formatFieldPayload:
+			payload = match[i]
+		}
+	}
+	// RFC5424 writes "-" for unknown host names
+	if host == "-" {
+		host = ""
+	}
+	logTime, err := format.parseTime(timestamp)
+	if err != nil {
+		return time.Time{}, "", "", err
+	}
+	return logTime, host, payload, nil
+}
@@ -0,0 +1,131 @@
+package drop
+
+import (
+	"testing"
+	"time"
+)
+
+// Test if the built-in log formats get the log time, host name and payload of logs
+func TestLogFormatPresets(t *testing.T) {
+	curYear := time.Now().Year()
+	testCases := []struct {
+		format   string
+		log      string
+		logTime  time.Time
+		hostName string
+		payload  string
+	}{
+		{
+			format:   LogFormatDefault,
+			log:      "2019-02-04T10:10:12.345678-07:00 hostname calico-drop: SRC=1.1.1.1",
+			logTime:  time.Date(2019, 2, 4, 17, 10, 12, 345678000, time.UTC),
+			hostName: "hostname",
+			payload:  "calico-drop: SRC=1.1.1.1",
+		},
+		{
+			format:   LogFormatRsyslog,
+			log:      "2019-02-04T10:10:12.345678-07:00 kern.warning hostname kernel: calico-drop: SRC=1.1.1.1",
+			logTime:  time.Date(2019, 2, 4, 17, 10, 12, 345678000, time.UTC),
+			hostName: "hostname",
+			payload:  "kernel: calico-drop: SRC=1.1.1.1",
+		},
+		{
+			format:   LogFormatRsyslog,
+			log:      "2019-02-04T10:10:12Z hostname kernel: calico-drop: SRC=1.1.1.1",
+			logTime:  time.Date(2019, 2, 4, 10, 10, 12, 0, time.UTC),
+			hostName: "hostname",
+			payload:  "kernel: calico-drop: SRC=1.1.1.1",
+		},
+		{
+			format:   LogFormatSyslogNg,
+			log:      "Feb  4 10:10:12 hostname kernel: [12345.678] calico-drop: SRC=1.1.1.1",
+			logTime:  time.Date(curYear, 2, 4, 10, 10, 12, 0, time.Local),
+			hostName: "hostname",
+			payload:  "kernel: [12345.678] calico-drop: SRC=1.1.1.1",
+		},
+		{
+			format:   LogFormatRFC3164,
+			log:      "<4>Feb 14 10:10:12 hostname kernel: calico-drop: SRC=1.1.1.1",
+			logTime:  time.Date(curYear, 2, 14, 10, 10, 12, 0, time.Local),
+			hostName: "hostname",
+			payload:  "kernel: calico-drop: SRC=1.1.1.1",
+		},
+		{
+			format:   LogFormatRFC5424,
+			log:      `<4>1 2019-02-04T10:10:12.345678-07:00 hostname kernel - - [meta x="a\]b"] calico-drop: SRC=1.1.1.1`,
+			logTime:  time.Date(2019, 2, 4, 17, 10, 12, 345678000, time.UTC),
+			hostName: "hostname",
+			payload:  "calico-drop: SRC=1.1.1.1",
+		},
+		{
+			format:  LogFormatRFC5424,
+			log:     "<4>1 2019-02-04T10:10:12Z - kernel - - - calico-drop: SRC=1.1.1.1",
+			logTime: time.Date(2019, 2, 4, 10, 10, 12, 0, time.UTC),
+			payload: "calico-drop: SRC=1.1.1.1",
+		},
+	}
+	for _, testCase := range testCases {
+		logFormat, err := InitLogFormat(testCase.format, "", nil)
+		if err != nil {
+			t.Fatalf("Expected error nil, but got error %s", err)
+		}
+		logTime, hostName, payload, err := logFormat.Parse(testCase.log)
+		if err != nil {
+			t.Fatalf("Expected error nil from log %s, but got error %s", testCase.log, err)
+		}
+		if !logTime.Equal(testCase.logTime) || hostName != testCase.hostName || payload != testCase.payload {
+			t.Fatalf("Expected %v %v %v, but got result %v %v %v", testCase.logTime, testCase.hostName,
+				testCase.payload, logTime, hostName, payload)
+		}
+	}
+}
+
+// Test if the grok-like and regular expression log formats get the log time, host name and payload of logs
+func TestCustomLogFormats(t *testing.T) {
+	testCases := []struct {
+		format     string
+		timeLayout string
+	}{
+		{
+			format:     `%{SYSLOGPRI}?%{TIMESTAMP_ISO8601:timestamp} %{WORD} %{HOSTNAME:host} %{GREEDYDATA:payload}`,
+			timeLayout: "2006-01-02 15:04:05",
+		},
+		{format: `^<\d+>(?P<timestamp>\S+ \S+) \w+ (?P<host>\S+) (?P<payload>.*)$`, timeLayout: "2006-01-02 15:04:05"},
+	}
+	for _, testCase := range testCases {
+		logFormat, err := InitLogFormat(testCase.format, testCase.timeLayout, nil)
+		if err != nil {
+			t.Fatalf("Expected error nil, but got error %s", err)
+		}
+		log := "<4>2019-02-04 10:10:12 warning node-1.example calico-drop: SRC=1.1.1.1"
+		logTime, hostName, payload, err := logFormat.Parse(log)
+		if err != nil {
+			t.Fatalf("Expected error nil, but got error %s", err)
+		}
+		expectedTime := time.Date(2019, 2, 4, 10, 10, 12, 0, time.UTC)
+		if !logTime.Equal(expectedTime) || hostName != "node-1.example" || payload != "calico-drop: SRC=1.1.1.1" {
+			t.Fatalf("Expected %v %v %v, but got result %v %v %v", expectedTime, "node-1.example",
+				"calico-drop: SRC=1.1.1.1", logTime, hostName, payload)
+		}
+	}
+}
+
+// Test if InitLogFormat() returns error for invalid log formats and Parse() for logs not matching the format
+func TestBadLogFormats(t *testing.T) {
+	for _, format := range []string{
+		`%{UNKNOWN:timestamp} %{GREEDYDATA:payload}`,
+		`(?P<timestamp>\S+) (?P<host>\S+)`,
+		`(?P<timestamp>\S+ (?P<payload>.*)`,
+	} {
+		if _, err := InitLogFormat(format, "", nil); err == nil {
+			t.Fatalf("Expected error from log format %s, but got nil", format)
+		}
+	}
+
+	logFormat, _ := InitLogFormat(LogFormatRFC3164, "", nil)
+	for _, log := range []string{"2019-02-04T10:10:12Z hostname calico-drop:", "Foo  4 10:10:12 hostname calico-drop:"} {
+		if _, _, _, err := logFormat.Parse(log); err == nil {
+			t.Fatalf("Expected error from log %s, but got nil", log)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package drop
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// LogPath is a log file or a glob pattern of log files to watch, with the log prefixes and log format of its logs.
+// Empty Prefixes, Format, TimeLayout and TimeZone use the ones set for all the logs.
+type LogPath struct {
+	Path       string
+	Prefixes   LogPrefixes
+	Format     string
+	TimeLayout string
+	TimeZone   string
+}
+
+// Parse the log paths of given JSON list, where each item is either a string of path or an object of LogPath whose
+// prefixes are set like IPTABLES_LOG_PREFIXES, e.g.
+// `["/var/log/kern.log", {"path": "/var/log/firewall/*.log", "prefixes": ["fw-drop:"], "format": "rfc3164"}]`
+func ParseLogPaths(config string) ([]LogPath, error) {
+	var items []json.RawMessage
+	if err := json.Unmarshal([]byte(config), &items); err != nil {
+		return nil, fmt.Errorf("Invalid log paths: %v", err)
+	}
+	var paths []LogPath
+	for _, item := range items {
+		var path struct {
+			Path       string          `json:"path"`
+			Prefixes   json.RawMessage `json:"prefixes,omitempty"`
+			Format     string          `json:"format,omitempty"`
+			TimeLayout string          `json:"timeLayout,omitempty"`
+			TimeZone   string          `json:"timeZone,omitempty"`
+		}
+		if err := json.Unmarshal(item, &path.Path); err != nil {
+			if err := json.Unmarshal(item, &path); err != nil {
+				return nil, fmt.Errorf("Invalid log path %s: %v", item, err)
+			}
+		}
+		if path.Path == "" {
+			return nil, fmt.Errorf("Invalid log path %s: missing path", item)
+		}
+		if _, err := filepath.Match(path.Path, ""); err != nil {
+			return nil, fmt.Errorf("Invalid log path %s: %v", path.Path, err)
+		}
+		logPath := LogPath{Path: path.Path, Format: path.Format, TimeLayout: path.TimeLayout, TimeZone: path.TimeZone}
+		if len(path.Prefixes) > 0 {
+			prefixes, err := ParseLogPrefixes(string(path.Prefixes))
+			if err != nil {
+				return nil, fmt.Errorf("Invalid log path %s: %v", path.Path, err)
+			}
+			logPath.Prefixes = prefixes
+		}
+		paths = append(paths, logPath)
+	}
+	if len(paths) == 0 {
+		return nil, errors.New("Invalid log paths: empty list")
+	}
+	return paths, nil
+}
+
+// number of checks in a row missing a watched file before its watcher is stopped
+const goneFileChecks = 2
+
+// GlobWatcher watches all the files matching a glob pattern, each by a Watcher. The pattern is checked again at every
+// watch interval, so the files created later are watched as well, and the watchers of the files which no longer exist
+// (e.g. the logs of deleted pods) are stopped. The files rotated from another matching file (e.g. "iptables.log.1" of
+// "iptables.log") and the compressed files are not watched, as their logs have been read from the file they are
+// rotated from.
+type GlobWatcher struct {
+	pattern       string
+	watchInterval time.Duration
+	usePolling    bool
+	checkpoints   *CheckpointStore // nil if checkpoints are not kept
+	backfill      *backfill        // nil if the rotated files are not read on start
+
+	maxLineLength      int
+	partialLineTimeout time.Duration
+
+	watchedFiles map[string]int // file name as key, number of checks in a row not finding the file as value
+}
+
+// Init a glob watcher object of given glob pattern, whose watchers keep their checkpoints in given checkpoint store
+// (which may be nil), and return its pointer
+func InitGlobWatcher(pattern string, watchInterval time.Duration, usePolling bool,
+	checkpoints *CheckpointStore) *GlobWatcher {
+	watcher := GlobWatcher{
+		pattern:       pattern,
+		watchInterval: watchInterval,
+		usePolling:    usePolling,
+		checkpoints:   checkpoints,
+		maxLineLength: DefaultMaxLineLength,
+		watchedFiles:  make(map[string]int),
+	}
+	return &watcher
+}
+
+// Enable reading the rotated files of the files matching the pattern when the watcher starts, see
+// Watcher.EnableBackfill()
+func (watcher *GlobWatcher) EnableBackfill(logFormat *LogFormat, expiration time.Duration) {
+	watcher.backfill = &backfill{logFormat: logFormat, expiration: expiration}
+}
+
+// Set the line limits of the watchers of the files matching the pattern, see Watcher.SetLineLimits()
+func (watcher *GlobWatcher) SetLineLimits(maxLineLength int, partialLineTimeout time.Duration) {
+	watcher.maxLineLength, watcher.partialLineTimeout = maxLineLength, partialLineTimeout
+}
+
+// Run the watchers of the files matching the pattern and insert the logs of all of them as records into given channel
+// until given stop channel is closed
+func (watcher *GlobWatcher) Run(stopCh <-chan struct{}, recordCh chan<- Record) error {
+	if _, err := filepath.Match(watcher.pattern, ""); err != nil {
+		return fmt.Errorf("Invalid log path %s: %v", watcher.pattern, err)
+	}
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	// each file is watched until given stop channel is closed or the file is gone
+	fileStopChs := make(map[string]chan struct{})
+	defer func() {
+		for _, fileStopCh := range fileStopChs {
+			close(fileStopCh)
+		}
+	}()
+
+	ticker := time.NewTicker(watcher.watchInterval)
+	defer ticker.Stop()
+	for {
+		newFiles, goneFiles := watcher.checkFiles()
+		for _, fileName := range goneFiles {
+			zap.L().Info("Stop watching file", zap.String("file", fileName), zap.String("pattern", watcher.pattern))
+			close(fileStopChs[fileName])
+			delete(fileStopChs, fileName)
+		}
+		for _, fileName := range newFiles {
+			zap.L().Info("Watching file", zap.String("file", fileName), zap.String("pattern", watcher.pattern))
+			fileWatcher := InitWatcher(fileName, watcher.watchInterval, watcher.usePolling, watcher.checkpoints)
+			fileWatcher.backfill = watcher.backfill
+			fileWatcher.SetLineLimits(watcher.maxLineLength, watcher.partialLineTimeout)
+			fileStopCh := make(chan struct{})
+			fileStopChs[fileName] = fileStopCh
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := fileWatcher.Run(fileStopCh, recordCh); err != nil {
+					zap.L().Error("Failed to read logs", zap.String("error", err.Error()))
+				}
+			}()
+		}
+		// only the files found on start may have rotated files with logs written while the service was down
+		watcher.backfill = nil
+
+		select {
+		case <-stopCh:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Helper function to get the files matching the pattern which are not watched yet, and the watched files which are
+// gone. A file is only gone once it is missing at goneFileChecks checks in a row, so its watcher has read the rest of the file if
+// it has been rotated. A path without glob characters is watched even if it doesn't exist, like the single log file.
+func (watcher *GlobWatcher) checkFiles() (newFiles, goneFiles []string) {
+	matches := []string{watcher.pattern}
+	if hasGlobMeta(watcher.pattern) {
+		var err error
+		// the pattern has been checked by Run(), so there is no error of bad pattern
+		if matches, err = filepath.Glob(watcher.pattern); err != nil {
+			return nil, nil
+		}
+	}
+	sort.Strings(matches)
+
+	found := make(map[string]bool)
+	for _, match := range matches {
+		if isCompressedFile(match) || isRotatedFileOf(match, matches) {
+			continue
+		}
+		found[match] = true
+		if _, ok := watcher.watchedFiles[match]; !ok {
+			newFiles = append(newFiles, match)
+		}
+		watcher.watchedFiles[match] = 0
+	}
+	for fileName := range watcher.watchedFiles {
+		if found[fileName] {
+			continue
+		}
+		watcher.watchedFiles[fileName]++
+		if watcher.watchedFiles[fileName] >= goneFileChecks {
+			delete(watcher.watchedFiles, fileName)
+			goneFiles = append(goneFiles, fileName)
+		}
+	}
+	sort.Strings(goneFiles)
+	return newFiles, goneFiles
+}
+
+// Helper function to check if given path has any glob characters
+func hasGlobMeta(path string) bool {
+	return strings.ContainsAny(path, `*?[\`)
+}
+
+// Helper function to check if given file is rotated from any of given files by the naming of logrotate
+func isRotatedFileOf(fileName string, fileNames []string) bool {
+	for _, other := range fileNames {
+		if other != fileName && strings.HasPrefix(fileName, other) &&
+			rotatedFileSuffixRegexp.MatchString(strings.TrimPrefix(fileName, other)) {
+			return true
+		}
+	}
+	return false
+}
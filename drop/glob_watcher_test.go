@@ -0,0 +1,160 @@
+package drop
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Test if parsing log paths works, with the log prefixes and log formats of the paths
+func TestParseLogPaths(t *testing.T) {
+	config := `["/var/log/kern.log", {"path": "/var/log/firewall/*.log", "prefixes": ["fw-drop:"], ` +
+		`"format": "rfc3164", "timeZone": "UTC"}]`
+	paths, err := ParseLogPaths(config)
+	if err != nil {
+		t.Fatalf("Expected no error, but got error %+v", err)
+	}
+	if len(paths) != 2 {
+		t.Fatalf("Expected 2 log paths, but got result %v", paths)
+	}
+	if paths[0].Path != "/var/log/kern.log" || paths[0].Prefixes != nil || paths[0].Format != "" {
+		t.Fatalf("Expected log path /var/log/kern.log, but got result %+v", paths[0])
+	}
+	if paths[1].Path != "/var/log/firewall/*.log" || paths[1].Format != LogFormatRFC3164 ||
+		paths[1].TimeZone != "UTC" || len(paths[1].Prefixes) != 1 || paths[1].Prefixes[0].Prefix != "fw-drop:" {
+		t.Fatalf("Expected log path /var/log/firewall/*.log, but got result %+v", paths[1])
+	}
+}
+
+// Test if parsing invalid log paths returns error
+func TestParseLogPathsInvalid(t *testing.T) {
+	for _, config := range []string{
+		`/var/log/kern.log`,
+		`[]`,
+		`[{"format": "rfc3164"}]`,
+		`["/var/log/[.log"]`,
+		`[{"path": "/var/log/kern.log", "prefixes": []}]`,
+		`[{"path": "/var/log/kern.log", "prefixes": [1]}]`,
+	} {
+		if _, err := ParseLogPaths(config); err == nil {
+			t.Fatalf("Expected error of log paths %s, but got nil", config)
+		}
+	}
+}
+
+// Test if the glob watcher finds the new matching files, but not the rotated and compressed ones
+func TestGlobWatcherCheckFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "glob")
+	if err != nil {
+		t.Fatalf("Cannot create the test directory, err=%+v", err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"a.log", "b.log", "a.log.1", "a.log.2.gz", "b.log-20190204", "c.txt"} {
+		appendLog(t, filepath.Join(dir, name), TestLog1)
+	}
+
+	watcher := InitGlobWatcher(filepath.Join(dir, "*.log*"), time.Second, true, nil)
+	expected := []string{filepath.Join(dir, "a.log"), filepath.Join(dir, "b.log")}
+	if result, _ := watcher.checkFiles(); !reflect.DeepEqual(result, expected) {
+		t.Fatalf("Expected %v, but got result %v", expected, result)
+	}
+	appendLog(t, filepath.Join(dir, "c.log"), TestLog1)
+	expected = []string{filepath.Join(dir, "c.log")}
+	if result, _ := watcher.checkFiles(); !reflect.DeepEqual(result, expected) {
+		t.Fatalf("Expected %v, but got result %v", expected, result)
+	}
+
+	// a path without glob characters is watched before it exists
+	fileName := filepath.Join(dir, "d.log")
+	watcher = InitGlobWatcher(fileName, time.Second, true, nil)
+	if result, _ := watcher.checkFiles(); !reflect.DeepEqual(result, []string{fileName}) {
+		t.Fatalf("Expected %v, but got result %v", []string{fileName}, result)
+	}
+	if _, result := watcher.checkFiles(); len(result) != 0 {
+		t.Fatalf("Expected no file gone, but got result %v", result)
+	}
+}
+
+// Test if the watched files missing at goneFileChecks checks in a row are gone, and are watched again if they come
+// back
+func TestGlobWatcherCheckGoneFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "glob")
+	if err != nil {
+		t.Fatalf("Cannot create the test directory, err=%+v", err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "a.log")
+	appendLog(t, fileName, TestLog1)
+
+	watcher := InitGlobWatcher(filepath.Join(dir, "*.log"), time.Second, true, nil)
+	watcher.checkFiles()
+	// the file rotated away is only gone if no new file is created in the meantime
+	if err := os.Rename(fileName, fileName+".1"); err != nil {
+		t.Fatalf("Cannot rotate the test file, err=%+v", err)
+	}
+	for i := 1; i < goneFileChecks; i++ {
+		if _, result := watcher.checkFiles(); len(result) != 0 {
+			t.Fatalf("Expected no file gone, but got result %v", result)
+		}
+	}
+	if _, result := watcher.checkFiles(); !reflect.DeepEqual(result, []string{fileName}) {
+		t.Fatalf("Expected %v, but got result %v", []string{fileName}, result)
+	}
+
+	appendLog(t, fileName, TestLog2)
+	if result, _ := watcher.checkFiles(); !reflect.DeepEqual(result, []string{fileName}) {
+		t.Fatalf("Expected %v, but got result %v", []string{fileName}, result)
+	}
+}
+
+// Test if the glob watcher sends the logs of all the matching files tagged with their files, including the files
+// created after it starts
+func TestGlobWatcherRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "glob")
+	if err != nil {
+		t.Fatalf("Cannot create the test directory, err=%+v", err)
+	}
+	defer os.RemoveAll(dir)
+	firstFile, secondFile := filepath.Join(dir, "a.log"), filepath.Join(dir, "b.log")
+	appendLog(t, firstFile, TestLog1)
+
+	watcher := InitGlobWatcher(filepath.Join(dir, "*.log"), 10*time.Millisecond, true, nil)
+	stopCh := make(chan struct{})
+	channel := make(chan Record)
+	errCh := make(chan error)
+	go func() {
+		errCh <- watcher.Run(stopCh, channel)
+	}()
+
+	receive := func() Record {
+		select {
+		case record := <-channel:
+			return record
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected a record, but got nothing")
+			return Record{}
+		}
+	}
+	if result := receive(); result.Message != TestLog1 || result.Source != firstFile {
+		t.Fatalf("Expected %v of %v, but got result %+v", TestLog1, firstFile, result)
+	}
+	appendLog(t, secondFile, TestLog2)
+	if result := receive(); result.Message != TestLog2 || result.Source != secondFile {
+		t.Fatalf("Expected %v of %v, but got result %+v", TestLog2, secondFile, result)
+	}
+	close(stopCh)
+	if err := <-errCh; err != nil {
+		t.Fatalf("Expected error nil, but got error %s", err)
+	}
+}
+
+// Test if the glob watcher returns error of bad pattern
+func TestGlobWatcherRunInvalidPattern(t *testing.T) {
+	watcher := InitGlobWatcher("[.log", time.Second, true, nil)
+	if err := watcher.Run(make(chan struct{}), make(chan Record)); err == nil {
+		t.Fatalf("Expected error, but got nil")
+	}
+}
@@ -0,0 +1,79 @@
+package drop
+
+import "fmt"
+
+// names of ICMP types, using the same names as nftables
+var icmpTypeNames = map[uint8]string{
+	0:  "echo-reply",
+	3:  "destination-unreachable",
+	4:  "source-quench",
+	5:  "redirect",
+	8:  "echo-request",
+	9:  "router-advertisement",
+	10: "router-solicitation",
+	11: "time-exceeded",
+	12: "parameter-problem",
+	13: "timestamp-request",
+	14: "timestamp-reply",
+}
+
+// names of ICMP destination-unreachable codes
+var icmpUnreachableCodeNames = map[uint8]string{
+	0:  "net-unreachable",
+	1:  "host-unreachable",
+	2:  "prot-unreachable",
+	3:  "port-unreachable",
+	4:  "frag-needed",
+	9:  "net-prohibited",
+	10: "host-prohibited",
+	13: "admin-prohibited",
+}
+
+// names of ICMPv6 types
+var icmpv6TypeNames = map[uint8]string{
+	1:   "destination-unreachable",
+	2:   "packet-too-big",
+	3:   "time-exceeded",
+	4:   "parameter-problem",
+	128: "echo-request",
+	129: "echo-reply",
+	133: "nd-router-solicit",
+	134: "nd-router-advert",
+	135: "nd-neighbor-solicit",
+	136: "nd-neighbor-advert",
+	137: "nd-redirect",
+}
+
+// names of ICMPv6 destination-unreachable codes
+var icmpv6UnreachableCodeNames = map[uint8]string{
+	0: "no-route",
+	1: "admin-prohibited",
+	3: "addr-unreachable",
+	4: "port-unreachable",
+	5: "policy-fail",
+	6: "reject-route",
+}
+
+// Return the readable name of the ICMP type and code of given PacketDrop, e.g. "echo-request" or
+// "destination-unreachable (port-unreachable)", return empty string if it's not an ICMP packet
+func GetIcmpTypeName(pd PacketDrop) string {
+	if !pd.IsIcmp() || pd.IcmpType == nil {
+		return ""
+	}
+	typeNames, codeNames, unreachable := icmpTypeNames, icmpUnreachableCodeNames, uint8(3)
+	if pd.Proto == ProtoICMPv6 {
+		typeNames, codeNames, unreachable = icmpv6TypeNames, icmpv6UnreachableCodeNames, uint8(1)
+	}
+
+	name, ok := typeNames[*pd.IcmpType]
+	if !ok {
+		return fmt.Sprintf("type %d code %d", *pd.IcmpType, pd.IcmpCode)
+	}
+	if *pd.IcmpType == unreachable {
+		if codeName, ok := codeNames[pd.IcmpCode]; ok {
+			return fmt.Sprintf("%s (%s)", name, codeName)
+		}
+		return fmt.Sprintf("%s (code %d)", name, pd.IcmpCode)
+	}
+	return name
+}
@@ -0,0 +1,153 @@
+// +build linux
+
+package drop
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+
+	"go.uber.org/zap"
+)
+
+// events of the directory of the watched file: changes of the file, files taking or leaving its name, and the
+// directory itself being moved or deleted
+const inotifyDirMask = syscall.IN_MODIFY | syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MOVED_FROM |
+	syscall.IN_MOVED_TO | syscall.IN_MOVE_SELF | syscall.IN_DELETE_SELF
+
+// events of the file being read, which are sent even after the file is renamed
+const inotifyFileMask = syscall.IN_MODIFY | syscall.IN_MOVE_SELF
+
+// inotifyNotifier is the fileNotifier watching the directory of the file and the file being read through inotify
+type inotifyNotifier struct {
+	fd       int
+	file     *os.File
+	fileName string // base name of the watched file
+	dirWd    int32
+	fileWd   int32 // watch descriptor of the file being read, accessed atomically
+	events   chan fileEvent
+	errors   chan error
+	done     chan struct{}
+}
+
+// Watch the directory of given file through inotify
+func newFileNotifier(fileName string) (fileNotifier, error) {
+	// the non-blocking descriptor lets Close() interrupt the pending read
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	dirWd, err := syscall.InotifyAddWatch(fd, filepath.Dir(fileName), inotifyDirMask)
+	if err != nil {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("inotify_add_watch", err)
+	}
+	notifier := &inotifyNotifier{
+		fd:       fd,
+		file:     os.NewFile(uintptr(fd), "inotify"),
+		fileName: filepath.Base(fileName),
+		dirWd:    int32(dirWd),
+		fileWd:   -1,
+		events:   make(chan fileEvent, 1),
+		errors:   make(chan error, 1),
+		done:     make(chan struct{}),
+	}
+	go notifier.run()
+	return notifier, nil
+}
+
+func (n *inotifyNotifier) Events() <-chan fileEvent {
+	return n.events
+}
+
+func (n *inotifyNotifier) Errors() <-chan error {
+	return n.errors
+}
+
+func (n *inotifyNotifier) Follow(fileName string) error {
+	wd, err := syscall.InotifyAddWatch(n.fd, fileName, inotifyFileMask)
+	if err != nil {
+		return os.NewSyscallError("inotify_add_watch", err)
+	}
+	// the watch of the file read before is removed by kernel once the file is deleted
+	if oldWd := atomic.SwapInt32(&n.fileWd, int32(wd)); oldWd >= 0 && oldWd != int32(wd) {
+		syscall.InotifyRmWatch(n.fd, uint32(oldWd))
+	}
+	return nil
+}
+
+func (n *inotifyNotifier) Close() error {
+	close(n.done)
+	return n.file.Close()
+}
+
+// Read the inotify events until the notifier is closed or fails, and send the ones of the watched file
+func (n *inotifyNotifier) run() {
+	buf := make([]byte, (syscall.SizeofInotifyEvent+syscall.NAME_MAX+1)*64)
+	for {
+		count, err := n.file.Read(buf)
+		if err != nil {
+			n.sendError(err)
+			return
+		}
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= count; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + syscall.SizeofInotifyEvent
+			name := strings.TrimRight(string(buf[nameStart:nameStart+int(event.Len)]), "\x00")
+			offset = nameStart + int(event.Len)
+			if err := n.handleEvent(event.Wd, event.Mask, name); err != nil {
+				n.sendError(err)
+				return
+			}
+		}
+	}
+}
+
+// Helper function to send the file event of given inotify event, return error if the file cannot be watched anymore
+func (n *inotifyNotifier) handleEvent(wd int32, mask uint32, name string) error {
+	switch {
+	case mask&syscall.IN_Q_OVERFLOW != 0:
+		// the events lost may be of any change, and the watches are kept, so the file is checked again
+		zap.L().Warn("Inotify event queue overflowed, checking the file again", zap.String("file", n.fileName))
+		n.sendEvent(fileModified)
+	case wd == n.dirWd && mask&(syscall.IN_MOVE_SELF|syscall.IN_DELETE_SELF|syscall.IN_IGNORED) != 0:
+		return errors.New("directory of the watched file was moved or deleted")
+	case wd == atomic.LoadInt32(&n.fileWd):
+		if mask&syscall.IN_MODIFY != 0 {
+			n.sendEvent(fileModified)
+		} else if mask&syscall.IN_MOVE_SELF != 0 {
+			n.sendEvent(fileRemoved)
+		}
+	case wd != n.dirWd || name != n.fileName:
+		// the events of other files in the directory, or of the files read before
+	case mask&syscall.IN_MODIFY != 0:
+		n.sendEvent(fileModified)
+	case mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0:
+		n.sendEvent(fileCreated)
+	case mask&(syscall.IN_DELETE|syscall.IN_MOVED_FROM) != 0:
+		n.sendEvent(fileRemoved)
+	}
+	return nil
+}
+
+// Helper function to send given event without blocking: the watcher checks the file on any event, so the event is
+// dropped if another one is pending already
+func (n *inotifyNotifier) sendEvent(event fileEvent) {
+	select {
+	case n.events <- event:
+	default:
+	}
+}
+
+// Helper function to send given error unless the notifier is closed
+func (n *inotifyNotifier) sendError(err error) {
+	select {
+	case <-n.done:
+	default:
+		n.errors <- err
+	}
+}
@@ -0,0 +1,179 @@
+// +build linux
+
+package drop
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Helper function to receive a record from given channel, or fail the test after a while
+func receiveRecord(t *testing.T, channel <-chan Record) Record {
+	select {
+	case record := <-channel:
+		return record
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected a record, but got nothing")
+		return Record{}
+	}
+}
+
+// Test if the watcher reads the file on its events, and reads the rotated file to its end before the new file
+func TestWatcherRunNotified(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watcher")
+	if err != nil {
+		t.Fatalf("Cannot create the test directory, err=%+v", err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "iptables.log")
+	appendLog(t, fileName, TestLog1)
+
+	// the watch interval is long enough to make sure the logs are not found by polling
+	watcher := InitWatcher(fileName, time.Hour, false, nil)
+	stopCh := make(chan struct{})
+	channel := make(chan Record)
+	errCh := make(chan error)
+	go func() {
+		errCh <- watcher.Run(stopCh, channel)
+	}()
+	if result := receiveRecord(t, channel); result.Message != TestLog1 {
+		t.Fatalf("Expected %s, but got result %s", TestLog1, result.Message)
+	}
+
+	appendLog(t, fileName, TestLog2)
+	if result := receiveRecord(t, channel); result.Message != TestLog2 {
+		t.Fatalf("Expected %s, but got result %s", TestLog2, result.Message)
+	}
+
+	// the logs written to the rotated file are read before the new file
+	appendLog(t, fileName, "before rotation")
+	if err := os.Rename(fileName, fileName+".1"); err != nil {
+		t.Fatalf("Cannot rotate the test file, err=%+v", err)
+	}
+	appendLog(t, fileName+".1", "after rename")
+	appendLog(t, fileName, "after rotation")
+	for _, expected := range []string{"before rotation", "after rename", "after rotation"} {
+		if result := receiveRecord(t, channel); result.Message != expected {
+			t.Fatalf("Expected %s, but got result %s", expected, result.Message)
+		}
+	}
+
+	close(stopCh)
+	if err := <-errCh; err != nil {
+		t.Fatalf("Expected error nil, but got error %s", err)
+	}
+	if watcher.curFile != nil {
+		t.Fatalf("Expected current file closed, but got %v", watcher.curFile.Name())
+	}
+}
+
+// Test if the watcher falls back to polling when the directory of the file cannot be watched
+func TestWatcherRunNotifiedFallback(t *testing.T) {
+	fileName := filepath.Join("not-existing-directory", "iptables.log")
+	watcher := InitWatcher(fileName, 10*time.Millisecond, false, nil)
+	stopCh := make(chan struct{})
+	errCh := make(chan error)
+	go func() {
+		errCh <- watcher.Run(stopCh, make(chan Record))
+	}()
+	// polling keeps running although the file cannot be opened
+	time.Sleep(50 * time.Millisecond)
+	close(stopCh)
+	if err := <-errCh; err != nil {
+		t.Fatalf("Expected error nil, but got error %s", err)
+	}
+}
+
+// Test if the watcher reads the incomplete last line after the partial line timeout without any other event
+func TestWatcherRunNotifiedPartialLine(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watcher")
+	if err != nil {
+		t.Fatalf("Cannot create the test directory, err=%+v", err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "iptables.log")
+	appendContent(t, fileName, "incomplete")
+
+	watcher := InitWatcher(fileName, time.Hour, false, nil)
+	watcher.SetLineLimits(DefaultMaxLineLength, 50*time.Millisecond)
+	stopCh := make(chan struct{})
+	channel := make(chan Record)
+	errCh := make(chan error)
+	go func() {
+		errCh <- watcher.Run(stopCh, channel)
+	}()
+	if result := receiveRecord(t, channel); result.Message != "incomplete" {
+		t.Fatalf("Expected %s, but got result %s", "incomplete", result.Message)
+	}
+	close(stopCh)
+	if err := <-errCh; err != nil {
+		t.Fatalf("Expected error nil, but got error %s", err)
+	}
+}
+
+// Test if the notifier keeps at most one pending event without blocking on the events nobody receives
+func TestInotifyNotifierPendingEvent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watcher")
+	if err != nil {
+		t.Fatalf("Cannot create the test directory, err=%+v", err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "iptables.log")
+	notifier, err := newFileNotifier(fileName)
+	if err != nil {
+		t.Fatalf("Cannot watch the test file, err=%+v", err)
+	}
+	defer notifier.Close()
+
+	for i := 0; i < 100; i++ {
+		appendLog(t, fileName, TestLog1)
+	}
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case <-notifier.Events():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected a file event, but got nothing")
+	}
+	select {
+	case event := <-notifier.Events():
+		t.Fatalf("Expected no more file event, but got result %v", event)
+	case err := <-notifier.Errors():
+		t.Fatalf("Expected error nil, but got error %s", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// Test if the notifier keeps watching the file after the event queue overflows, and tells the file may have changed
+func TestInotifyNotifierQueueOverflow(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watcher")
+	if err != nil {
+		t.Fatalf("Cannot create the test directory, err=%+v", err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "iptables.log")
+	notifier, err := newFileNotifier(fileName)
+	if err != nil {
+		t.Fatalf("Cannot watch the test file, err=%+v", err)
+	}
+	defer notifier.Close()
+
+	inotify := notifier.(*inotifyNotifier)
+	if err := inotify.handleEvent(-1, syscall.IN_Q_OVERFLOW, ""); err != nil {
+		t.Fatalf("Expected error nil, but got error %s", err)
+	}
+	if event := <-notifier.Events(); event != fileModified {
+		t.Fatalf("Expected %v, but got result %v", fileModified, event)
+	}
+	appendLog(t, fileName, TestLog1)
+	select {
+	case <-notifier.Events():
+	case err := <-notifier.Errors():
+		t.Fatalf("Expected a file event, but got error %s", err)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected a file event, but got nothing")
+	}
+}
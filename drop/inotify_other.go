@@ -0,0 +1,9 @@
+// +build !linux
+
+package drop
+
+import "errors"
+
+func newFileNotifier(_ string) (fileNotifier, error) {
+	return nil, errors.New("file events are only supported on linux, polling the file instead")
+}
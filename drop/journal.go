@@ -0,0 +1,72 @@
+package drop
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// name of the journal watcher as the source of records
+const journalSourceName = "journal"
+
+// separator of the groups of journal matches, which is OR-ed like "+" of journalctl
+const journalDisjunction = "+"
+
+// a journal match, e.g. "_TRANSPORT=kernel"
+var journalMatchRegexp = regexp.MustCompile(`^[A-Z0-9_]+=.*$`)
+
+// JournalWatcher handles detecting any changes on the given journal and passing those changes through Go Channel to be
+// parsed. Only the entries of its journal matches are read, which work as the matches of journalctl: matches of the
+// same field are OR-ed, matches of different fields are AND-ed, and "+" ORs the groups of matches before and after
+// it. The cursor of the last entry read is kept as checkpoint, and the journal is read after it when the watcher
+// starts, or from the lookback before the current time if there is no checkpoint yet.
+type JournalWatcher struct {
+	journalDir  string
+	matches     []string
+	lookback    time.Duration    // the last entry is read first if it's 0
+	checkpoints *CheckpointStore // nil if checkpoints are not kept
+	logPrefixes LogPrefixes      // nil if the messages are not filtered
+}
+
+// Init a journal watcher object with given journal matches and lookback, keeping its checkpoint in given checkpoint
+// store (which may be nil), and return its pointer
+func InitJournalWatcher(journalDir string, matches []string, lookback time.Duration,
+	checkpoints *CheckpointStore) *JournalWatcher {
+	watcher := JournalWatcher{journalDir: journalDir, matches: matches, lookback: lookback, checkpoints: checkpoints}
+	return &watcher
+}
+
+// Only read the journal entries whose messages match any of given log prefixes, skipping the rest before they are
+// turned into records
+func (watcher *JournalWatcher) FilterMessages(logPrefixes LogPrefixes) {
+	watcher.logPrefixes = logPrefixes
+}
+
+// Helper function to check if the message of an entry should be read
+func (watcher *JournalWatcher) isMessageWanted(message string) bool {
+	if watcher.logPrefixes == nil {
+		return true
+	}
+	logPrefix, _ := watcher.logPrefixes.Match(message)
+	return logPrefix != nil
+}
+
+// Parse given journal matches separated by spaces like the arguments of journalctl, e.g.
+// "_TRANSPORT=kernel + SYSLOG_IDENTIFIER=kernel SYSLOG_IDENTIFIER=iptables"
+func ParseJournalMatches(config string) ([]string, error) {
+	matches := strings.Fields(config)
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("Invalid journal matches %s: empty list", config)
+	}
+	for i, match := range matches {
+		if match == journalDisjunction {
+			if i == 0 || i == len(matches)-1 || matches[i-1] == journalDisjunction {
+				return nil, fmt.Errorf("Invalid journal matches %s: %s must be between matches", config, match)
+			}
+		} else if !journalMatchRegexp.MatchString(match) {
+			return nil, fmt.Errorf("Invalid journal matches %s: invalid match %s", config, match)
+		}
+	}
+	return matches, nil
+}
@@ -0,0 +1,47 @@
+package drop
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Test if parsing journal matches works
+func TestParseJournalMatches(t *testing.T) {
+	expected := []string{"_TRANSPORT=kernel", "+", "SYSLOG_IDENTIFIER=kernel", "SYSLOG_IDENTIFIER=iptables"}
+	matches, err := ParseJournalMatches(" _TRANSPORT=kernel + SYSLOG_IDENTIFIER=kernel  SYSLOG_IDENTIFIER=iptables ")
+	if err != nil {
+		t.Fatalf("Expected no error, but got error %+v", err)
+	}
+	if !reflect.DeepEqual(matches, expected) {
+		t.Fatalf("Expected %v, but got result %v", expected, matches)
+	}
+}
+
+// Test if parsing invalid journal matches returns error
+func TestParseJournalMatchesInvalid(t *testing.T) {
+	for _, config := range []string{"", "kernel", "transport=kernel", "+ _TRANSPORT=kernel",
+		"_TRANSPORT=kernel +", "_TRANSPORT=kernel + + SYSLOG_IDENTIFIER=kernel"} {
+		if _, err := ParseJournalMatches(config); err == nil {
+			t.Fatalf("Expected error of journal matches %s, but got nil", config)
+		}
+	}
+}
+
+// Test if the journal watcher only wants the messages matching its log prefixes
+func TestJournalWatcherIsMessageWanted(t *testing.T) {
+	watcher := InitJournalWatcher("", []string{"SYSLOG_IDENTIFIER=kernel"}, 0, nil)
+	if !watcher.isMessageWanted("any message") {
+		t.Fatalf("Expected all messages wanted without log prefixes, but got false")
+	}
+	watcher.FilterMessages(getTestLogPrefixes("calico-drop:", "fw reject: "))
+	for message, expected := range map[string]bool{
+		"calico-drop: IN=eth0":   true,
+		"fw reject: IN=eth0":     true,
+		"usb 1-1: new device":    false,
+		"xcalico-drop: IN=eth0 ": false,
+	} {
+		if result := watcher.isMessageWanted(message); result != expected {
+			t.Fatalf("Expected %v of message %s, but got result %v", expected, message, result)
+		}
+	}
+}
@@ -2,17 +2,9 @@
 
 package drop
 
-import "go.uber.org/zap"
-
-type JournalWatcher struct {
-}
-
-func InitJournalWatcher(_ string) *JournalWatcher {
-	zap.L().Fatal("you need to build with cgo for journal watching support")
-	return nil
-}
-
-// Run the watcher and insert newly found logs into given channel
-func (watcher *JournalWatcher) Run(_ chan<- string) {
-	zap.L().Fatal("you need to build with cgo for journal watching support")
+// Run the watcher and insert newly found journal entries as records into given channel until given stop channel is
+// closed. Without cgo, the journal is read by journalctl, which must be installed and able to read the journal of the
+// host.
+func (watcher *JournalWatcher) Run(stopCh <-chan struct{}, recordCh chan<- Record) error {
+	return watcher.runJournalctl(stopCh, recordCh)
 }
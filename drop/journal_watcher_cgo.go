@@ -4,67 +4,153 @@ package drop
 
 import (
 	"fmt"
-	"strings"
 	"time"
 
-	"github.com/box/kube-iptables-tailer/util"
 	"github.com/coreos/go-systemd/sdjournal"
 	"go.uber.org/zap"
 )
 
-// Watcher handles detecting any changes on the given journal and passing those changes through Go Channel to be parsed.
-type JournalWatcher struct {
-	journalDir string
+// maximum time to wait for new journal entries before checking the stop channel again
+const journalWaitTimeout = time.Second
+
+// Run the watcher and insert newly found journal entries as records into given channel until given stop channel is
+// closed
+func (watcher *JournalWatcher) Run(stopCh <-chan struct{}, recordCh chan<- Record) error {
+	journal, err := sdjournal.NewJournalFromDir(watcher.journalDir)
+	if err != nil {
+		return err
+	}
+	defer journal.Close()
+
+	if err := watcher.addMatches(journal); err != nil {
+		return err
+	}
+	if err := watcher.seek(journal); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-stopCh:
+			return nil
+		default:
+		}
+
+		count, err := journal.Next()
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			journal.Wait(journalWaitTimeout)
+			continue
+		}
+		// only the message is read from the journal before the entry is known to be wanted
+		if watcher.logPrefixes != nil {
+			msg, err := journal.GetDataValue(sdjournal.SD_JOURNAL_FIELD_MESSAGE)
+			if err != nil {
+				logJournalEntryError(journal, err)
+				continue
+			}
+			if !watcher.isMessageWanted(msg) {
+				continue
+			}
+		}
+		entry, err := journal.GetEntry()
+		if err != nil {
+			logJournalEntryError(journal, err)
+			continue
+		}
+		record, err := getJournalRecord(entry)
+		if err != nil {
+			logJournalEntryError(journal, err)
+			continue
+		}
+		if watcher.checkpoints != nil {
+			record.Checkpoint = &Checkpoint{Source: journalSourceName, Cursor: record.Cursor}
+		}
+		select {
+		case recordCh <- record:
+		case <-stopCh:
+			return nil
+		}
+	}
 }
 
-// Init a journal watcher object and return its pointer
-func InitJournalWatcher(journalDir string) *JournalWatcher {
-	watcher := JournalWatcher{journalDir: journalDir}
-	return &watcher
+// Helper function to log the error of the current entry of given journal, which is skipped so a single bad entry
+// doesn't stop reading the entries after it
+func logJournalEntryError(journal *sdjournal.Journal, err error) {
+	cursor, _ := journal.GetCursor()
+	zap.L().Error("Cannot read journal entry", zap.String("cursor", cursor), zap.String("error", err.Error()))
 }
 
-// Run the watcher and insert newly found logs into given channel
-func (watcher *JournalWatcher) Run(logChangeCh chan<- string) {
-	reader, err := sdjournal.NewJournalReader(sdjournal.JournalReaderConfig{
-		Path:        watcher.journalDir,
-		NumFromTail: 1,
-		Matches: []sdjournal.Match{{
-			Field: "SYSLOG_IDENTIFIER",
-			Value: "kernel",
-		}},
-		Formatter: func(entry *sdjournal.JournalEntry) (s string, e error) {
-			msg, ok := entry.Fields["MESSAGE"]
-			if !ok {
-				return "", fmt.Errorf("no MESSAGE field present in journal entry")
-			}
+// Helper function to add the journal matches to given journal, where "+" adds a disjunction
+func (watcher *JournalWatcher) addMatches(journal *sdjournal.Journal) error {
+	for _, match := range watcher.matches {
+		var err error
+		if match == journalDisjunction {
+			err = journal.AddDisjunction()
+		} else {
+			err = journal.AddMatch(match)
+		}
+		if err != nil {
+			return fmt.Errorf("Invalid journal match %s: %v", match, err)
+		}
+	}
+	return nil
+}
 
-			hostname, ok := entry.Fields["_HOSTNAME"]
-			if !ok {
-				return "", fmt.Errorf("no _HOSTNAME field present in journal entry")
-			}
+// Helper function to move the read pointer of given journal after the entry of the checkpoint, or to the first entry
+// within the lookback or the last entry of the journal if there is no checkpoint
+func (watcher *JournalWatcher) seek(journal *sdjournal.Journal) error {
+	if checkpoint, ok := watcher.checkpoints.Get(journalSourceName); ok && checkpoint.Cursor != "" {
+		// skip the entry of the cursor which has been read
+		err := journal.SeekCursor(checkpoint.Cursor)
+		if err == nil {
+			_, err = journal.Next()
+		}
+		if err == nil {
+			zap.L().Info("Resuming journal from checkpoint", zap.String("cursor", checkpoint.Cursor))
+			return nil
+		}
+		zap.L().Warn("Cannot resume journal from checkpoint", zap.String("error", err.Error()))
+	}
 
-			return strings.Join([]string{
-				time.Unix(0, int64(entry.RealtimeTimestamp)*int64(time.Microsecond)).
-					Format(util.DefaultPacketDropLogTimeLayout),
-				hostname,
-				msg,
-			}, " ") + "\n", nil
-		},
-	})
-	if err != nil {
-		zap.L().Fatal(err.Error())
+	if watcher.lookback > 0 {
+		return journal.SeekRealtimeUsec(uint64(timeNow().Add(-watcher.lookback).UnixNano() / int64(time.Microsecond)))
 	}
 
-	if err := reader.Follow(nil, &lineWriter{logChangeCh}); err != nil {
-		zap.L().Fatal(err.Error())
+	// start from the last entry of the journal, go one further than it because Next() is called before reading
+	if err := journal.SeekTail(); err != nil {
+		return err
 	}
+	if skip, err := journal.PreviousSkip(2); err != nil {
+		return err
+	} else if skip != 2 {
+		if err := journal.SeekHead(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-type lineWriter struct {
-	logChangeCh chan<- string
-}
+// Helper function to get the record of given journal entry, keeping the precision of its realtime timestamp
+func getJournalRecord(entry *sdjournal.JournalEntry) (Record, error) {
+	msg, ok := entry.Fields[sdjournal.SD_JOURNAL_FIELD_MESSAGE]
+	if !ok {
+		return Record{}, fmt.Errorf("no MESSAGE field present in journal entry")
+	}
+
+	hostname, ok := entry.Fields[sdjournal.SD_JOURNAL_FIELD_HOSTNAME]
+	if !ok {
+		return Record{}, fmt.Errorf("no _HOSTNAME field present in journal entry")
+	}
 
-func (lw *lineWriter) Write(buf []byte) (n int, err error) {
-	lw.logChangeCh <- string(buf)
-	return len(buf), nil
+	return Record{
+		Time:     time.Unix(0, int64(entry.RealtimeTimestamp)*int64(time.Microsecond)),
+		Host:     hostname,
+		Message:  msg,
+		Source:   journalSourceName,
+		Metadata: entry.Fields,
+		Cursor:   entry.Cursor,
+	}, nil
 }
@@ -0,0 +1,182 @@
+package drop
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// fields of the journal entries written by "journalctl -o json"
+const (
+	journalFieldCursor            = "__CURSOR"
+	journalFieldRealtimeTimestamp = "__REALTIME_TIMESTAMP"
+	journalFieldHostname          = "_HOSTNAME"
+	journalFieldMessage           = "MESSAGE"
+)
+
+// command reading the journal without cgo, whose entries are written one per line as JSON objects
+var journalctlCommand = "journalctl"
+
+// maximum size of a journal entry written by journalctl
+const maxJournalEntrySize = 1024 * 1024
+
+// Run journalctl to follow the entries of the journal matches, and insert them as records into given channel until
+// given stop channel is closed
+func (watcher *JournalWatcher) runJournalctl(stopCh <-chan struct{}, recordCh chan<- Record) error {
+	var stderr bytes.Buffer
+	command := exec.Command(journalctlCommand, watcher.getJournalctlArgs()...)
+	command.Stderr = &stderr
+	output, err := command.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := command.Start(); err != nil {
+		return fmt.Errorf("Cannot run %s: %v", journalctlCommand, err)
+	}
+
+	// stop journalctl once the stop channel is closed
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-stopCh:
+			command.Process.Kill()
+		case <-done:
+		}
+	}()
+
+	err = watcher.readJournalctlOutput(output, stopCh, recordCh)
+	if err != nil {
+		command.Process.Kill()
+	}
+	waitErr := command.Wait()
+	select {
+	case <-stopCh:
+		return nil
+	default:
+	}
+	if err != nil {
+		return err
+	}
+	if waitErr != nil {
+		return fmt.Errorf("%s exited: %v: %s", journalctlCommand, waitErr, strings.TrimSpace(stderr.String()))
+	}
+	return fmt.Errorf("%s exited", journalctlCommand)
+}
+
+// Helper function to get the arguments of journalctl following the entries of the journal matches after the
+// checkpoint, or from the lookback or the last entry if there is no checkpoint
+func (watcher *JournalWatcher) getJournalctlArgs() []string {
+	args := []string{"--directory=" + watcher.journalDir, "--output=json", "--all", "--follow", "--no-pager"}
+	if checkpoint, ok := watcher.checkpoints.Get(journalSourceName); ok && checkpoint.Cursor != "" {
+		args = append(args, "--after-cursor="+checkpoint.Cursor)
+	} else if watcher.lookback > 0 {
+		args = append(args, fmt.Sprintf("--since=@%d", timeNow().Add(-watcher.lookback).Unix()), "--lines=all")
+	} else {
+		args = append(args, "--lines=1")
+	}
+	return append(args, watcher.matches...)
+}
+
+// Read the journal entries of journalctl from given output and insert the wanted ones as records into given channel
+// until given stop channel is closed, keeping the cursor of the last record as checkpoint. Invalid entries are logged
+// and skipped.
+func (watcher *JournalWatcher) readJournalctlOutput(output io.Reader, stopCh <-chan struct{},
+	recordCh chan<- Record) error {
+	scanner := bufio.NewScanner(output)
+	scanner.Buffer(nil, maxJournalEntrySize)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		record, err := getJournalJSONRecord(scanner.Bytes())
+		if err != nil {
+			// a single bad entry doesn't stop reading the entries after it
+			zap.L().Error("Cannot read journal entry", zap.ByteString("entry", scanner.Bytes()),
+				zap.String("error", err.Error()))
+			continue
+		}
+		if !watcher.isMessageWanted(record.Message) {
+			continue
+		}
+		if watcher.checkpoints != nil {
+			record.Checkpoint = &Checkpoint{Source: journalSourceName, Cursor: record.Cursor}
+		}
+		select {
+		case recordCh <- record:
+		case <-stopCh:
+			return errStopped
+		}
+	}
+	return scanner.Err()
+}
+
+// Helper function to get the record of given journal entry written by "journalctl -o json"
+func getJournalJSONRecord(entry []byte) (Record, error) {
+	var rawFields map[string]json.RawMessage
+	if err := json.Unmarshal(entry, &rawFields); err != nil {
+		return Record{}, fmt.Errorf("Invalid journal entry %s: %v", entry, err)
+	}
+	fields := make(map[string]string, len(rawFields))
+	for name, rawValue := range rawFields {
+		value, err := getJournalFieldValue(rawValue)
+		if err != nil {
+			return Record{}, fmt.Errorf("Invalid journal field %s: %v", name, err)
+		}
+		fields[name] = value
+	}
+
+	msg, ok := fields[journalFieldMessage]
+	if !ok {
+		return Record{}, fmt.Errorf("no MESSAGE field present in journal entry")
+	}
+	hostname, ok := fields[journalFieldHostname]
+	if !ok {
+		return Record{}, fmt.Errorf("no _HOSTNAME field present in journal entry")
+	}
+	usec, err := strconv.ParseInt(fields[journalFieldRealtimeTimestamp], 10, 64)
+	if err != nil {
+		return Record{}, fmt.Errorf("Invalid journal field %s: %v", journalFieldRealtimeTimestamp, err)
+	}
+
+	return Record{
+		Time:     time.Unix(0, usec*int64(time.Microsecond)),
+		Host:     hostname,
+		Message:  msg,
+		Source:   journalSourceName,
+		Metadata: fields,
+		Cursor:   fields[journalFieldCursor],
+	}, nil
+}
+
+// Helper function to get the value of a journal field written by journalctl: a string, an array of bytes if the value
+// is not printable, or an array of values if the field is set more than once (the first one is used)
+func getJournalFieldValue(rawValue json.RawMessage) (string, error) {
+	var value string
+	if err := json.Unmarshal(rawValue, &value); err == nil {
+		return value, nil
+	}
+	// []byte would be decoded from base64 strings, so the bytes are decoded as integers
+	var numbers []int
+	if err := json.Unmarshal(rawValue, &numbers); err == nil {
+		data := make([]byte, len(numbers))
+		for i, number := range numbers {
+			data[i] = byte(number)
+		}
+		return string(data), nil
+	}
+	var values []json.RawMessage
+	if err := json.Unmarshal(rawValue, &values); err == nil && len(values) > 0 {
+		return getJournalFieldValue(values[0])
+	}
+	return "", errors.New("unknown value " + string(rawValue))
+}
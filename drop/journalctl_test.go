@@ -0,0 +1,301 @@
+package drop
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// journal entries recorded from "journalctl -o json --all SYSLOG_IDENTIFIER=kernel", the second one has a message
+// which is not valid UTF-8 and is written as an array of bytes
+const testJournalctlOutput = `{"__CURSOR":"s=6b1e3c0e5b2d4f0a9c8e7d6f5a4b3c2d;i=1f2a;b=0c9d8e7f6a5b4c3d2e1f0a9b8c7d6e5f;m=2b5e1d6f;t=581148c4e1a4e;x=9a8b7c6d5e4f3a2b","__REALTIME_TIMESTAMP":"1549300212345678","__MONOTONIC_TIMESTAMP":"727589231","_BOOT_ID":"0c9d8e7f6a5b4c3d2e1f0a9b8c7d6e5f","_TRANSPORT":"kernel","PRIORITY":"4","SYSLOG_FACILITY":"0","SYSLOG_IDENTIFIER":"kernel","_MACHINE_ID":"3f2e1d0c9b8a7f6e5d4c3b2a1f0e9d8c","_HOSTNAME":"node-1","MESSAGE":"calico-drop: IN=cali30b7e32ad9c OUT=eth0 MAC=ee:ee:ee:ee:ee:ee:12:34:56:78:9a:bc:08:00 SRC=10.0.1.2 DST=10.0.2.3 LEN=60 TOS=0x00 PREC=0x00 TTL=63 ID=12345 DF PROTO=TCP SPT=38192 DPT=8080 WINDOW=29200 RES=0x00 SYN URGP=0 "}
+
+{"__CURSOR":"s=6b1e3c0e5b2d4f0a9c8e7d6f5a4b3c2d;i=1f2b;b=0c9d8e7f6a5b4c3d2e1f0a9b8c7d6e5f;m=2b5e1d70;t=581148c4e1a4f;x=8a7b6c5d4e3f2a1b","__REALTIME_TIMESTAMP":"1549300212345679","__MONOTONIC_TIMESTAMP":"727589232","_TRANSPORT":"kernel","PRIORITY":"4","SYSLOG_IDENTIFIER":["kernel","kernel"],"_HOSTNAME":"node-1","MESSAGE":[102,119,45,100,114,111,112,58,32,83,82,67,61,49,48,46,48,46,49,46,50,32,68,83,84,61,49,48,46,48,46,50,46,51,32,255]}
+`
+
+// journal matches of the kernel logs
+var testJournalMatches = []string{"SYSLOG_IDENTIFIER=kernel"}
+
+// Test if the entries written by journalctl are read as records, and the cursor of the last one is kept as checkpoint
+func TestReadJournalctlOutput(t *testing.T) {
+	store := &CheckpointStore{checkpoints: make(map[string]Checkpoint)}
+	watcher := InitJournalWatcher("", testJournalMatches, 0, store)
+	channel := make(chan Record, 10)
+	if err := watcher.readJournalctlOutput(strings.NewReader(testJournalctlOutput), nil, channel); err != nil {
+		t.Fatalf("Expected no error, but got error %+v", err)
+	}
+	close(channel)
+	var records []Record
+	for record := range channel {
+		records = append(records, record)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, but got result %v", records)
+	}
+
+	record := records[0]
+	expectedTime := time.Unix(1549300212, 345678000)
+	if !record.Time.Equal(expectedTime) || record.Host != "node-1" || record.Source != journalSourceName ||
+		!strings.HasPrefix(record.Message, "calico-drop: ") || record.Metadata["_TRANSPORT"] != "kernel" ||
+		!strings.Contains(record.Cursor, ";i=1f2a;") {
+		t.Fatalf("Expected record of the first journal entry, but got result %+v", record)
+	}
+	record = records[1]
+	if record.Message != "fw-drop: SRC=10.0.1.2 DST=10.0.2.3 \xff" || record.Metadata["SYSLOG_IDENTIFIER"] != "kernel" {
+		t.Fatalf("Expected record of the second journal entry, but got result %+v", record)
+	}
+	if checkpoint := record.Checkpoint; checkpoint == nil || checkpoint.Cursor != record.Cursor {
+		t.Fatalf("Expected checkpoint of cursor %v, but got result %+v", record.Cursor, checkpoint)
+	}
+}
+
+// Test if the records of journalctl can be parsed into packet drops
+func TestParsingJournalctlRecord(t *testing.T) {
+	channel := make(chan Record, 10)
+	watcher := InitJournalWatcher("", testJournalMatches, 0, nil)
+	watcher.readJournalctlOutput(strings.NewReader(testJournalctlOutput), nil, channel)
+	packetDropCh := make(chan PacketDrop, 1)
+	if err := parse(getTestLogPrefixes("calico-drop:"), testLogFormat, <-channel, packetDropCh); err != nil {
+		t.Fatalf("Expected no error, but got error %+v", err)
+	}
+	// the packet drop of 2019 has expired
+	select {
+	case packetDrop := <-packetDropCh:
+		t.Fatalf("Expected no packet drop, but got result %+v", packetDrop)
+	default:
+	}
+
+	record := <-channel
+	record.Time = time.Now()
+	record.Message = "calico-drop: IN=eth0 OUT= SRC=10.0.1.2 DST=10.0.2.3 LEN=60 TTL=63 PROTO=TCP SPT=38192 DPT=8080"
+	if err := parse(getTestLogPrefixes("calico-drop:"), testLogFormat, record, packetDropCh); err != nil {
+		t.Fatalf("Expected no error, but got error %+v", err)
+	}
+	packetDrop := <-packetDropCh
+	if packetDrop.HostName != "node-1" || packetDrop.Source != journalSourceName ||
+		packetDrop.SrcIP.String() != "10.0.1.2" || packetDrop.DstIP.String() != "10.0.2.3" {
+		t.Fatalf("Expected packet drop of the journal entry, but got result %+v", packetDrop)
+	}
+}
+
+// Test if invalid journal entries are skipped without stopping reading the entries after them
+func TestReadJournalctlOutputInvalid(t *testing.T) {
+	for _, output := range []string{
+		`{"MESSAGE": "no host name", "__REALTIME_TIMESTAMP": "1549300212345678"}`,
+		`{"_HOSTNAME": "node-1", "__REALTIME_TIMESTAMP": "1549300212345678"}`,
+		`{"_HOSTNAME": "node-1", "MESSAGE": "no timestamp"}`,
+		`{"_HOSTNAME": "node-1", "MESSAGE": {"invalid": "value"}, "__REALTIME_TIMESTAMP": "1549300212345678"}`,
+		`not json`,
+	} {
+		watcher := InitJournalWatcher("", testJournalMatches, 0, nil)
+		channel := make(chan Record, 10)
+		err := watcher.readJournalctlOutput(strings.NewReader(output+"\n"+testJournalctlOutput), nil, channel)
+		if err != nil {
+			t.Fatalf("Expected no error of journal entry %s, but got error %+v", output, err)
+		}
+		close(channel)
+		var records []Record
+		for record := range channel {
+			records = append(records, record)
+		}
+		if len(records) != 2 || records[0].Host != "node-1" || !strings.HasPrefix(records[0].Message, "calico-drop: ") {
+			t.Fatalf("Expected the records after journal entry %s, but got result %+v", output, records)
+		}
+	}
+}
+
+// Test if the arguments of journalctl have the journal matches, and resume the journal from the checkpoint or the
+// lookback
+func TestGetJournalctlArgs(t *testing.T) {
+	matches := []string{"_TRANSPORT=kernel", "+", "SYSLOG_IDENTIFIER=iptables"}
+	watcher := InitJournalWatcher("/var/log/journal", matches, 0, nil)
+	expected := []string{"--directory=/var/log/journal", "--output=json", "--all", "--follow", "--no-pager",
+		"--lines=1", "_TRANSPORT=kernel", "+", "SYSLOG_IDENTIFIER=iptables"}
+	if result := watcher.getJournalctlArgs(); !reflect.DeepEqual(result, expected) {
+		t.Fatalf("Expected %v, but got result %v", expected, result)
+	}
+
+	defer func(now func() time.Time) { timeNow = now }(timeNow)
+	timeNow = func() time.Time { return time.Unix(1549300212, 0) }
+	watcher = InitJournalWatcher("/var/log/journal", testJournalMatches, 10*time.Minute, nil)
+	expected = []string{"--directory=/var/log/journal", "--output=json", "--all", "--follow", "--no-pager",
+		"--since=@1549299612", "--lines=all", "SYSLOG_IDENTIFIER=kernel"}
+	if result := watcher.getJournalctlArgs(); !reflect.DeepEqual(result, expected) {
+		t.Fatalf("Expected %v, but got result %v", expected, result)
+	}
+
+	store := &CheckpointStore{checkpoints: make(map[string]Checkpoint)}
+	store.Set(Checkpoint{Source: journalSourceName, Cursor: "s=abc;i=1"})
+	watcher = InitJournalWatcher("/var/log/journal", testJournalMatches, 10*time.Minute, store)
+	expected = []string{"--directory=/var/log/journal", "--output=json", "--all", "--follow", "--no-pager",
+		"--after-cursor=s=abc;i=1", "SYSLOG_IDENTIFIER=kernel"}
+	if result := watcher.getJournalctlArgs(); !reflect.DeepEqual(result, expected) {
+		t.Fatalf("Expected %v, but got result %v", expected, result)
+	}
+}
+
+// Test if only the journal entries matching the log prefixes are read when the messages are filtered
+func TestReadJournalctlOutputFiltered(t *testing.T) {
+	store := &CheckpointStore{checkpoints: make(map[string]Checkpoint)}
+	watcher := InitJournalWatcher("", testJournalMatches, 0, store)
+	watcher.FilterMessages(getTestLogPrefixes("fw-drop:"))
+	channel := make(chan Record, 10)
+	if err := watcher.readJournalctlOutput(strings.NewReader(testJournalctlOutput), nil, channel); err != nil {
+		t.Fatalf("Expected no error, but got error %+v", err)
+	}
+	close(channel)
+	var messages []string
+	for record := range channel {
+		messages = append(messages, record.Message)
+	}
+	if len(messages) != 1 || !strings.HasPrefix(messages[0], "fw-drop:") {
+		t.Fatalf("Expected the message of fw-drop:, but got result %v", messages)
+	}
+}
+
+// Test if running journalctl sends its entries until the stop channel is closed, by a fake journalctl printing the
+// recorded entries
+func TestRunJournalctl(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journalctl")
+	if err != nil {
+		t.Fatalf("Cannot create the test directory, err=%+v", err)
+	}
+	defer os.RemoveAll(dir)
+	outputFile, command := filepath.Join(dir, "output.json"), filepath.Join(dir, "journalctl")
+	if err := ioutil.WriteFile(outputFile, []byte(testJournalctlOutput), 0644); err != nil {
+		t.Fatalf("Cannot create the test file, err=%+v", err)
+	}
+	script := "#!/bin/sh\ncat " + outputFile + "\nexec sleep 60\n"
+	if err := ioutil.WriteFile(command, []byte(script), 0755); err != nil {
+		t.Fatalf("Cannot create the test file, err=%+v", err)
+	}
+	defer func(command string) { journalctlCommand = command }(journalctlCommand)
+	journalctlCommand = command
+
+	stopCh := make(chan struct{})
+	channel := make(chan Record)
+	errCh := make(chan error)
+	go func() {
+		errCh <- InitJournalWatcher(dir, testJournalMatches, 0, nil).runJournalctl(stopCh, channel)
+	}()
+	for i := 0; i < 2; i++ {
+		select {
+		case record := <-channel:
+			if record.Host != "node-1" {
+				t.Fatalf("Expected record of node-1, but got result %+v", record)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected a record, but got nothing")
+		}
+	}
+	close(stopCh)
+	if err := <-errCh; err != nil {
+		t.Fatalf("Expected error nil, but got error %s", err)
+	}
+}
+
+// journal file recorded by systemd-journald 252, with the kernel logs of the nftables rules logging with the prefixes
+// "kube-proxy-drop:", "nft-drop:" and "nft-netdev:", and the entries of systemd-journald itself
+const testJournalFile = "testdata/journal/system.journal.gz"
+
+// Helper function to decompress the recorded journal file into given directory
+func writeTestJournal(t *testing.T, dir string) string {
+	input, err := os.Open(testJournalFile)
+	if err != nil {
+		t.Fatalf("Cannot open the test file, err=%+v", err)
+	}
+	defer input.Close()
+	reader, err := gzip.NewReader(input)
+	if err != nil {
+		t.Fatalf("Cannot read the test file, err=%+v", err)
+	}
+	path := filepath.Join(dir, "system.journal")
+	output, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Cannot create the test file, err=%+v", err)
+	}
+	defer output.Close()
+	if _, err := io.Copy(output, reader); err != nil {
+		t.Fatalf("Cannot write the test file, err=%+v", err)
+	}
+	return path
+}
+
+// Test if running journalctl reads the kernel logs of a recorded journal file as records, skipping the entries of the
+// other log prefixes and other identifiers
+func TestRunJournalctlRecordedJournal(t *testing.T) {
+	if _, err := exec.LookPath(journalctlCommand); err != nil {
+		t.Skip("journalctl is not installed")
+	}
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatalf("Cannot create the test directory, err=%+v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := writeTestJournal(t, dir)
+	// journalctl of systemd older than 252 cannot read the compact journal files
+	if err := exec.Command(journalctlCommand, "--file="+path, "--lines=0").Run(); err != nil {
+		t.Skipf("journalctl cannot read the recorded journal file, err=%+v", err)
+	}
+
+	defer func(now func() time.Time) { timeNow = now }(timeNow)
+	timeNow = func() time.Time { return time.Unix(1792199960, 0) }
+	watcher := InitJournalWatcher(dir, testJournalMatches, 10*time.Minute, nil)
+	watcher.FilterMessages(getTestLogPrefixes("kube-proxy-drop:"))
+	stopCh := make(chan struct{})
+	channel := make(chan Record)
+	errCh := make(chan error)
+	go func() {
+		errCh <- watcher.runJournalctl(stopCh, channel)
+	}()
+	var records []Record
+	for len(records) < 10 {
+		select {
+		case record := <-channel:
+			records = append(records, record)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected 10 records, but got result %+v", records)
+		}
+	}
+	select {
+	case record := <-channel:
+		t.Fatalf("Expected no more record, but got result %+v", record)
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(stopCh)
+	if err := <-errCh; err != nil {
+		t.Fatalf("Expected error nil, but got error %s", err)
+	}
+
+	record := records[0]
+	expectedMessage := "kube-proxy-drop: IN= OUT=lo SRC=127.0.0.1 DST=127.0.0.1 LEN=60 TOS=0x00 PREC=0x00 TTL=64 " +
+		"ID=20806 DF PROTO=TCP SPT=54598 DPT=9 SEQ=3337178905 ACK=0 WINDOW=65495 RES=0x00 SYN URGP=0 " +
+		"OPT (0204FFD70402080A32ED5D31000000000103030A) UID=0 GID=0 "
+	if !record.Time.Equal(time.Unix(1792199901, 363311000)) || record.Host != "vm" || record.Message != expectedMessage ||
+		record.Source != journalSourceName || record.Metadata["_TRANSPORT"] != "kernel" ||
+		!strings.Contains(record.Cursor, ";i=4;") {
+		t.Fatalf("Expected record of the first kernel log, but got result %+v", record)
+	}
+	for _, record := range records {
+		if !strings.HasPrefix(record.Message, "kube-proxy-drop: ") {
+			t.Fatalf("Expected record of kube-proxy-drop:, but got result %+v", record)
+		}
+	}
+}
+
+// Test if running journalctl returns error once journalctl exits
+func TestRunJournalctlExited(t *testing.T) {
+	defer func(command string) { journalctlCommand = command }(journalctlCommand)
+	journalctlCommand = "false"
+	watcher := InitJournalWatcher("", testJournalMatches, 0, nil)
+	if err := watcher.runJournalctl(make(chan struct{}), make(chan Record)); err == nil {
+		t.Fatalf("Expected error, but got nil")
+	}
+}
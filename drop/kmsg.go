@@ -0,0 +1,262 @@
+package drop
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// name of the kmsg watcher as the source of records
+const kmsgSourceName = "kmsg"
+
+// file containing the ID of the current boot, which tells if the sequence numbers of kmsg restarted
+const bootIDFile = "/proc/sys/kernel/random/boot_id"
+
+// maximum size of a kmsg record including its dictionary, the read fails with EINVAL if the buffer is smaller
+const maxKmsgRecordSize = 8192
+
+// names of the record metadata from the kmsg header
+const (
+	kmsgMetadataPriority = "PRIORITY"
+	kmsgMetadataFacility = "SYSLOG_FACILITY"
+	kmsgMetadataSequence = "SEQNUM"
+)
+
+// function to read the boot ID, replaced by tests
+var readBootID = readProcBootID
+
+// KmsgWatcher reads the records of the kernel ring buffer from /dev/kmsg, e.g. on the nodes without log files nor
+// journal. The timestamps of the records, in microseconds since boot, are turned into the wall clock time by the boot
+// time. The sequence numbers of the records tell the records lost by overwriting the ring buffer before they were
+// read, and the sequence number of the last record read is kept as checkpoint with the boot ID, so the records read
+// before a restart are skipped.
+type KmsgWatcher struct {
+	kmsgPath    string
+	checkpoints *CheckpointStore // nil if checkpoints are not kept
+	hostName    string
+	bootID      string
+	bootTime    time.Time
+	lastSeq     int64 // -1 if no record has been read
+}
+
+// kmsgRecord is a record of /dev/kmsg: its header "priority,sequence,timestamp,flags;message" and the "KEY=value"
+// lines of its dictionary
+type kmsgRecord struct {
+	priority int
+	seq      int64
+	usec     int64
+	message  string
+	dict     map[string]string
+}
+
+// Init a kmsg watcher object of given kmsg device, keeping its checkpoint in given checkpoint store (which may be nil),
+// and return its pointer
+func InitKmsgWatcher(kmsgPath string, checkpoints *CheckpointStore) *KmsgWatcher {
+	watcher := KmsgWatcher{kmsgPath: kmsgPath, checkpoints: checkpoints, lastSeq: -1}
+	return &watcher
+}
+
+// Run the watcher and insert the records of the kernel ring buffer into given channel until given stop channel is
+// closed. The whole ring buffer is read on start, except the records before the checkpoint of the same boot.
+func (watcher *KmsgWatcher) Run(stopCh <-chan struct{}, recordCh chan<- Record) error {
+	var err error
+	if watcher.bootTime, err = readBootTime(); err != nil {
+		return err
+	}
+	if watcher.bootID, err = readBootID(); err != nil {
+		return err
+	}
+	if watcher.hostName, err = os.Hostname(); err != nil {
+		return err
+	}
+	if checkpoint, ok := watcher.checkpoints.Get(kmsgSourceName); ok && checkpoint.Boot == watcher.bootID {
+		zap.L().Info("Resuming kmsg from checkpoint", zap.Int64("seq", checkpoint.Offset))
+		watcher.lastSeq = checkpoint.Offset
+	}
+
+	file, err := os.Open(watcher.kmsgPath)
+	if err != nil {
+		return err
+	}
+	// the blocking read returns once the file is closed
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-stopCh:
+		case <-done:
+		}
+		closeFile(file)
+	}()
+
+	err = watcher.readRecords(file, stopCh, recordCh)
+	select {
+	case <-stopCh:
+		return nil
+	default:
+	}
+	if err == nil {
+		return errors.New("Unexpected end of " + watcher.kmsgPath)
+	}
+	return err
+}
+
+// Read the kmsg records from given input and insert them as records into given channel, until the input ends or given
+// stop channel is closed
+func (watcher *KmsgWatcher) readRecords(input io.Reader, stopCh <-chan struct{}, recordCh chan<- Record) error {
+	reader := bufio.NewReaderSize(input, maxKmsgRecordSize)
+	var record *kmsgRecord
+	for {
+		line, err := reader.ReadString('\n')
+		if errors.Is(err, syscall.EPIPE) {
+			// the records were overwritten before they were read, the next read gets the oldest record left
+			zap.L().Warn("Records of kmsg were overwritten before being read", zap.String("file", watcher.kmsgPath))
+			continue
+		}
+		if err != nil && line == "" {
+			if record != nil {
+				if err := watcher.sendRecord(record, stopCh, recordCh); err != nil {
+					return err
+				}
+			}
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		line = strings.TrimSuffix(line, "\n")
+		if strings.HasPrefix(line, " ") {
+			// dictionary of the record, e.g. " SUBSYSTEM=net"
+			if i := strings.Index(line, "="); record != nil && i > 0 {
+				record.dict[line[1:i]] = line[i+1:]
+			}
+		} else {
+			if record != nil {
+				if err := watcher.sendRecord(record, stopCh, recordCh); err != nil {
+					return err
+				}
+			}
+			if record, err = parseKmsgRecord(line); err != nil {
+				zap.L().Error("Cannot parse kmsg record", zap.String("record", line),
+					zap.String("error", err.Error()))
+			}
+		}
+		// each read of /dev/kmsg returns a whole record, so the record is complete once nothing is left to read
+		if record != nil && reader.Buffered() == 0 {
+			if err := watcher.sendRecord(record, stopCh, recordCh); err != nil {
+				return err
+			}
+			record = nil
+		}
+	}
+}
+
+// Helper function to send given kmsg record unless it has been read, and report the records lost before it. Return
+// errStopped if given stop channel is closed before the record is sent.
+func (watcher *KmsgWatcher) sendRecord(record *kmsgRecord, stopCh <-chan struct{}, recordCh chan<- Record) error {
+	if record.seq <= watcher.lastSeq {
+		return nil
+	}
+	if watcher.lastSeq >= 0 && record.seq > watcher.lastSeq+1 {
+		zap.L().Warn("Records of kmsg were lost",
+			zap.Int64("from_seq", watcher.lastSeq+1),
+			zap.Int64("to_seq", record.seq-1),
+		)
+	}
+	watcher.lastSeq = record.seq
+
+	metadata := map[string]string{
+		kmsgMetadataPriority: strconv.Itoa(record.priority & 7),
+		kmsgMetadataFacility: strconv.Itoa(record.priority >> 3),
+		kmsgMetadataSequence: strconv.FormatInt(record.seq, 10),
+	}
+	for key, value := range record.dict {
+		metadata[key] = value
+	}
+	kmsgRecord := Record{
+		Time:     watcher.bootTime.Add(time.Duration(record.usec) * time.Microsecond),
+		Host:     watcher.hostName,
+		Message:  record.message,
+		Source:   kmsgSourceName,
+		Metadata: metadata,
+		Offset:   record.seq,
+	}
+	if watcher.checkpoints != nil {
+		kmsgRecord.Checkpoint = &Checkpoint{Source: kmsgSourceName, Boot: watcher.bootID, Offset: record.seq}
+	}
+	select {
+	case recordCh <- kmsgRecord:
+		return nil
+	case <-stopCh:
+		return errStopped
+	}
+}
+
+// Helper function to parse the header line of a kmsg record, e.g. "4,1234,5678901234,-;calico-drop: IN=eth0 ..."
+func parseKmsgRecord(line string) (*kmsgRecord, error) {
+	i := strings.Index(line, ";")
+	if i < 0 {
+		return nil, errors.New("missing message")
+	}
+	fields := strings.Split(line[:i], ",")
+	if len(fields) < 3 {
+		return nil, errors.New("missing header fields")
+	}
+	priority, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid priority: %v", err)
+	}
+	seq, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sequence number: %v", err)
+	}
+	usec, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timestamp: %v", err)
+	}
+	return &kmsgRecord{
+		priority: priority,
+		seq:      seq,
+		usec:     usec,
+		message:  unescapeKmsg(line[i+1:]),
+		dict:     make(map[string]string),
+	}, nil
+}
+
+// Helper function to turn the "\xNN" escapes of the non-printable characters of kmsg back into the characters
+func unescapeKmsg(message string) string {
+	if !strings.Contains(message, `\x`) {
+		return message
+	}
+	var buffer strings.Builder
+	for i := 0; i < len(message); i++ {
+		if message[i] == '\\' && i+3 < len(message) && message[i+1] == 'x' {
+			if c, err := strconv.ParseUint(message[i+2:i+4], 16, 8); err == nil {
+				buffer.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		buffer.WriteByte(message[i])
+	}
+	return buffer.String()
+}
+
+// Helper function to read the ID of the current boot
+func readProcBootID() (string, error) {
+	content, err := ioutil.ReadFile(bootIDFile)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(content)), nil
+}
@@ -0,0 +1,168 @@
+package drop
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fake stream of /dev/kmsg, where the records of sequence numbers 12 and 13 were lost
+const testKmsgStream = "6,10,1000000,-;usb 1-1: new high-speed USB device number 2\n" +
+	"4,11,2500000,-;calico-drop: IN=cali30b7e32ad9c OUT=eth0 SRC=10.0.1.2 DST=10.0.2.3 LEN=60 TTL=63 PROTO=TCP\n" +
+	" SUBSYSTEM=net\n" +
+	" DEVICE=n2\n" +
+	"invalid record\n" +
+	"4,14,3000000,c;fw-drop: IN=eth0 OUT= SRC=10.0.1.3 DST=10.0.2.4 \\x7f LEN=60 TTL=63 PROTO=UDP\n"
+
+// Helper function to replace the boot time and boot ID read by the kmsg watcher
+func setTestBoot(bootTime time.Time, bootID string) func() {
+	oldReadBootTime, oldReadBootID := readBootTime, readBootID
+	readBootTime = func() (time.Time, error) { return bootTime, nil }
+	readBootID = func() (string, error) { return bootID, nil }
+	return func() {
+		readBootTime, readBootID = oldReadBootTime, oldReadBootID
+	}
+}
+
+// Helper function to get the records of given kmsg stream read by given watcher
+func readKmsgRecords(t *testing.T, watcher *KmsgWatcher, input io.Reader) []Record {
+	channel := make(chan Record, 10)
+	if err := watcher.readRecords(input, nil, channel); err != nil {
+		t.Fatalf("Expected no error, but got error %+v", err)
+	}
+	close(channel)
+	var records []Record
+	for record := range channel {
+		records = append(records, record)
+	}
+	return records
+}
+
+// Test if parsing the header of kmsg records works
+func TestParseKmsgRecord(t *testing.T) {
+	record, err := parseKmsgRecord(`12,345,6789,-,caller=T1;message with \x5c and \x1b[0m`)
+	if err != nil {
+		t.Fatalf("Expected no error, but got error %+v", err)
+	}
+	expected := &kmsgRecord{priority: 12, seq: 345, usec: 6789, message: "message with \\ and \x1b[0m",
+		dict: map[string]string{}}
+	if !reflect.DeepEqual(record, expected) {
+		t.Fatalf("Expected %+v, but got result %+v", expected, record)
+	}
+
+	for _, line := range []string{"no message", "4,1;no timestamp", "a,1,2,-;message", "4,b,2,-;message",
+		"4,1,c,-;message"} {
+		if _, err := parseKmsgRecord(line); err == nil {
+			t.Fatalf("Expected error of record %s, but got nil", line)
+		}
+	}
+}
+
+// Test if the kmsg records are read with their wall clock time, priority and dictionary, and the records of the
+// checkpoint are skipped
+func TestKmsgWatcherReadRecords(t *testing.T) {
+	bootTime := time.Date(2019, 2, 4, 10, 0, 0, 0, time.UTC)
+	store := &CheckpointStore{checkpoints: make(map[string]Checkpoint)}
+	watcher := InitKmsgWatcher("/dev/kmsg", store)
+	watcher.bootTime, watcher.bootID, watcher.hostName, watcher.lastSeq = bootTime, "boot-1", "node-1", 10
+
+	records := readKmsgRecords(t, watcher, strings.NewReader(testKmsgStream))
+	expected := []Record{
+		{
+			Time:    bootTime.Add(2500 * time.Millisecond),
+			Host:    "node-1",
+			Message: "calico-drop: IN=cali30b7e32ad9c OUT=eth0 SRC=10.0.1.2 DST=10.0.2.3 LEN=60 TTL=63 PROTO=TCP",
+			Source:  kmsgSourceName,
+			Metadata: map[string]string{"PRIORITY": "4", "SYSLOG_FACILITY": "0", "SEQNUM": "11",
+				"SUBSYSTEM": "net", "DEVICE": "n2"},
+			Offset:     11,
+			Checkpoint: &Checkpoint{Source: kmsgSourceName, Boot: "boot-1", Offset: 11},
+		},
+		{
+			Time:       bootTime.Add(3 * time.Second),
+			Host:       "node-1",
+			Message:    "fw-drop: IN=eth0 OUT= SRC=10.0.1.3 DST=10.0.2.4 \x7f LEN=60 TTL=63 PROTO=UDP",
+			Source:     kmsgSourceName,
+			Metadata:   map[string]string{"PRIORITY": "4", "SYSLOG_FACILITY": "0", "SEQNUM": "14"},
+			Offset:     14,
+			Checkpoint: &Checkpoint{Source: kmsgSourceName, Boot: "boot-1", Offset: 14},
+		},
+	}
+	if !reflect.DeepEqual(records, expected) {
+		t.Fatalf("Expected %+v, but got result %+v", expected, records)
+	}
+}
+
+// kmsgReader returns the records of a fake kmsg one per read like /dev/kmsg, and EPIPE once before the last record
+type kmsgReader struct {
+	records []string
+	failed  bool
+}
+
+// Read the next record
+func (reader *kmsgReader) Read(p []byte) (int, error) {
+	if len(reader.records) == 0 {
+		return 0, io.EOF
+	}
+	if len(reader.records) == 1 && !reader.failed {
+		reader.failed = true
+		return 0, &os.PathError{Op: "read", Path: "/dev/kmsg", Err: syscall.EPIPE}
+	}
+	n := copy(p, reader.records[0])
+	reader.records = reader.records[1:]
+	return n, nil
+}
+
+// Test if the kmsg records are read one by one, and the reading continues after the records were overwritten
+func TestKmsgWatcherReadRecordsOverwritten(t *testing.T) {
+	watcher := InitKmsgWatcher("/dev/kmsg", nil)
+	reader := &kmsgReader{records: []string{
+		"4,1,1000000,-;first\n SUBSYSTEM=net\n",
+		"4,2,2000000,-;second\n",
+		"4,9,9000000,-;after overwritten\n",
+	}}
+	var messages []string
+	for _, record := range readKmsgRecords(t, watcher, reader) {
+		messages = append(messages, record.Message)
+	}
+	expected := []string{"first", "second", "after overwritten"}
+	if !reflect.DeepEqual(messages, expected) {
+		t.Fatalf("Expected %v, but got result %v", expected, messages)
+	}
+}
+
+// Test if running the kmsg watcher resumes from the checkpoint of the same boot only
+func TestKmsgWatcherRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kmsg")
+	if err != nil {
+		t.Fatalf("Cannot create the test directory, err=%+v", err)
+	}
+	defer os.RemoveAll(dir)
+	kmsgPath := filepath.Join(dir, "kmsg")
+	if err := ioutil.WriteFile(kmsgPath, []byte(testKmsgStream), 0644); err != nil {
+		t.Fatalf("Cannot create the test file, err=%+v", err)
+	}
+	defer setTestBoot(time.Date(2019, 2, 4, 10, 0, 0, 0, time.UTC), "boot-2")()
+
+	for checkpoint, expected := range map[Checkpoint]int{
+		{Source: kmsgSourceName, Boot: "boot-2", Offset: 11}: 1,
+		{Source: kmsgSourceName, Boot: "boot-1", Offset: 11}: 3,
+	} {
+		store := &CheckpointStore{checkpoints: make(map[string]Checkpoint)}
+		store.Set(checkpoint)
+		channel := make(chan Record, 10)
+		// the fake kmsg ends unlike /dev/kmsg
+		if err := InitKmsgWatcher(kmsgPath, store).Run(make(chan struct{}), channel); err == nil {
+			t.Fatalf("Expected error of unexpected end, but got nil")
+		}
+		if len(channel) != expected {
+			t.Fatalf("Expected %v records after checkpoint %+v, but got %v", expected, checkpoint, len(channel))
+		}
+	}
+}
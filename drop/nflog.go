@@ -0,0 +1,511 @@
+package drop
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unsafe"
+
+	"go.uber.org/zap"
+)
+
+// netlink and nfnetlink_log constants, see linux/netlink.h and linux/netfilter/nfnetlink_log.h
+const (
+	nlmsgHdrLen  = 16
+	nfgenmsgLen  = 4
+	nlattrHdrLen = 4
+
+	nlmsgError = 0x2
+	nlmsgDone  = 0x3
+
+	nlmFRequest = 0x1
+	nlmFAck     = 0x4
+
+	nfnlSubsysUlog   = 4
+	nfulnlMsgPacket  = nfnlSubsysUlog<<8 | 0
+	nfulnlMsgConfig  = nfnlSubsysUlog<<8 | 1
+	nfulnlCopyPacket = 0x2
+
+	nfulaCfgCmd  = 1
+	nfulaCfgMode = 2
+
+	nfulnlCfgCmdBind     = 1
+	nfulnlCfgCmdPfBind   = 3
+	nfulnlCfgCmdPfUnbind = 4
+
+	nfulaPacketHdr      = 1
+	nfulaMark           = 2
+	nfulaTimestamp      = 3
+	nfulaIfindexIndev   = 4
+	nfulaIfindexOutdev  = 5
+	nfulaIfindexPhysIn  = 6
+	nfulaIfindexPhysOut = 7
+	nfulaPayload        = 9
+	nfulaPrefix         = 10
+	nfulaUid            = 11
+	nfulaGid            = 14
+	nfulaHwHeader       = 16
+
+	nlaTypeMask = 0x3fff
+
+	afUnspec = 0
+	afInet   = 2
+	afInet6  = 10
+)
+
+// only the network and transport headers are needed to build a PacketDrop
+const nflogCopyRange = 256
+
+// NetlinkConn is the subset of a netlink socket used by NflogWatcher, so that tests can replay recorded messages.
+type NetlinkConn interface {
+	Send(msg []byte) error
+	Receive() ([]byte, error)
+	Close() error
+}
+
+// NflogWatcher subscribes to an NFLOG group over netlink and builds PacketDrop straight from the logged packets.
+type NflogWatcher struct {
+	group         uint16
+	logPrefixes   LogPrefixes
+	hostName      string
+	conn          NetlinkConn
+	interfaceName func(index int) string
+}
+
+// Init a NFLOG watcher object and return its pointer
+func InitNflogWatcher(group int, logPrefixes LogPrefixes) *NflogWatcher {
+	hostName, err := os.Hostname()
+	if err != nil {
+		zap.L().Warn("Cannot get host name for NFLOG packets", zap.String("error", err.Error()))
+	}
+	return &NflogWatcher{
+		group:         uint16(group),
+		logPrefixes:   logPrefixes,
+		hostName:      hostName,
+		interfaceName: getInterfaceName,
+	}
+}
+
+// Run the watcher and insert PacketDrop built from the NFLOG group into given channel until given stop channel is
+// closed, return error if the netlink socket fails
+func (watcher *NflogWatcher) Run(stopCh <-chan struct{}, packetDropCh chan<- PacketDrop) error {
+	if watcher.conn == nil {
+		conn, err := dialNetlink()
+		if err != nil {
+			return fmt.Errorf("Cannot open netlink socket: %v", err)
+		}
+		watcher.conn = conn
+	}
+
+	// closing the netlink socket stops receiving the messages
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+	go func() {
+		select {
+		case <-stopCh:
+		case <-doneCh:
+		}
+		if err := watcher.conn.Close(); err != nil {
+			zap.L().Error("Error while closing netlink socket", zap.String("error", err.Error()))
+		}
+	}()
+
+	if err := watcher.run(stopCh, packetDropCh); err != nil {
+		select {
+		case <-stopCh:
+			return nil
+		default:
+		}
+		return fmt.Errorf("Failed to receive NFLOG messages: %v", err)
+	}
+	return nil
+}
+
+// Subscribe to the NFLOG group and handle received messages until the connection fails or given stop channel is closed
+func (watcher *NflogWatcher) run(stopCh <-chan struct{}, packetDropCh chan<- PacketDrop) error {
+	if err := watcher.subscribe(); err != nil {
+		return err
+	}
+	for {
+		buf, err := watcher.conn.Receive()
+		if err != nil {
+			return err
+		}
+		if err := watcher.handle(buf, stopCh, packetDropCh); err != nil {
+			return err
+		}
+	}
+}
+
+// Bind the netlink socket to the NFLOG group and ask the kernel to copy packet headers
+func (watcher *NflogWatcher) subscribe() error {
+	msgs := [][]byte{
+		buildNflogConfig(afInet, 0, nfulaCfgCmd, []byte{nfulnlCfgCmdPfUnbind}),
+		buildNflogConfig(afInet, 0, nfulaCfgCmd, []byte{nfulnlCfgCmdPfBind}),
+		buildNflogConfig(afInet6, 0, nfulaCfgCmd, []byte{nfulnlCfgCmdPfUnbind}),
+		buildNflogConfig(afInet6, 0, nfulaCfgCmd, []byte{nfulnlCfgCmdPfBind}),
+		buildNflogConfig(afUnspec, watcher.group, nfulaCfgCmd, []byte{nfulnlCfgCmdBind}),
+		buildNflogConfig(afUnspec, watcher.group, nfulaCfgMode, buildNflogCopyMode(nflogCopyRange)),
+	}
+	for _, msg := range msgs {
+		if err := watcher.conn.Send(msg); err != nil {
+			return fmt.Errorf("error subscribing to NFLOG group %d: %v", watcher.group, err)
+		}
+	}
+	return nil
+}
+
+// Handle all the netlink messages from given buffer and insert the required ones into channel, return errStopped if
+// given stop channel is closed before they are inserted
+func (watcher *NflogWatcher) handle(buf []byte, stopCh <-chan struct{}, packetDropCh chan<- PacketDrop) error {
+	for len(buf) >= nlmsgHdrLen {
+		msgLen := int(nativeEndian.Uint32(buf[0:4]))
+		if msgLen < nlmsgHdrLen || msgLen > len(buf) {
+			zap.L().Error("Invalid netlink message", zap.Int("length", msgLen))
+			return nil
+		}
+		msgType := nativeEndian.Uint16(buf[4:6])
+		body := buf[nlmsgHdrLen:msgLen]
+		buf = buf[nlmsgAlign(msgLen):]
+
+		switch msgType {
+		case nlmsgError:
+			// an error message with code 0 acknowledges a config message
+			if len(body) >= 4 {
+				if code := int32(nativeEndian.Uint32(body[0:4])); code != 0 {
+					zap.L().Error("Netlink error received", zap.Int32("code", code))
+				}
+			}
+		case nlmsgDone:
+			return nil
+		case nfulnlMsgPacket:
+			packetDrop, prefix, err := watcher.getPacketDrop(body)
+			if err != nil {
+				zap.L().Error("Cannot parse the NFLOG message", zap.String("error", err.Error()))
+				continue
+			}
+			logPrefix, prefixFields := watcher.logPrefixes.Match(prefix)
+			if logPrefix == nil {
+				continue
+			}
+			packetDrop.LogPrefix, packetDrop.PrefixFields = logPrefix, prefixFields
+			zap.L().Info("Parsed new packet", zap.String("prefix", prefix), zap.Object("packet_drop", &packetDrop))
+			if packetDrop.IsExpired() {
+				continue
+			}
+			select {
+			case packetDropCh <- packetDrop:
+			case <-stopCh:
+				return errStopped
+			}
+		}
+	}
+	return nil
+}
+
+// Return a PacketDrop object and the NFLOG prefix constructed from given NFLOG packet message
+func (watcher *NflogWatcher) getPacketDrop(body []byte) (PacketDrop, string, error) {
+	if len(body) < nfgenmsgLen {
+		return PacketDrop{}, "", errors.New("NFLOG message too short")
+	}
+	attrs, err := parseNetlinkAttributes(body[nfgenmsgLen:])
+	if err != nil {
+		return PacketDrop{}, "", err
+	}
+
+	payload, ok := attrs[nfulaPayload]
+	if !ok {
+		return PacketDrop{}, "", errors.New("missing NFLOG payload")
+	}
+	pd, err := getPacketDropFromPayload(payload)
+	if err != nil {
+		return PacketDrop{}, "", err
+	}
+
+	pd.HostName = watcher.hostName
+	pd.LogTime = time.Now()
+	if ts, ok := attrs[nfulaTimestamp]; ok && len(ts) >= 16 {
+		sec := binary.BigEndian.Uint64(ts[0:8])
+		usec := binary.BigEndian.Uint64(ts[8:16])
+		pd.LogTime = time.Unix(int64(sec), int64(usec)*int64(time.Microsecond))
+	}
+	if index, ok := attrs[nfulaIfindexIndev]; ok && len(index) >= 4 {
+		pd.InterfaceReceived = watcher.interfaceName(int(binary.BigEndian.Uint32(index)))
+	}
+	if index, ok := attrs[nfulaIfindexOutdev]; ok && len(index) >= 4 {
+		pd.InterfaceSent = watcher.interfaceName(int(binary.BigEndian.Uint32(index)))
+	}
+	if index, ok := attrs[nfulaIfindexPhysIn]; ok && len(index) >= 4 {
+		pd.PhysInterfaceReceived = watcher.interfaceName(int(binary.BigEndian.Uint32(index)))
+	}
+	if index, ok := attrs[nfulaIfindexPhysOut]; ok && len(index) >= 4 {
+		pd.PhysInterfaceSent = watcher.interfaceName(int(binary.BigEndian.Uint32(index)))
+	}
+	if header, ok := attrs[nfulaHwHeader]; ok {
+		pd.MacAddress = formatMacHeader(header)
+	}
+	if mark, ok := attrs[nfulaMark]; ok && len(mark) >= 4 {
+		pd.Mark = binary.BigEndian.Uint32(mark)
+	}
+	if uid, ok := attrs[nfulaUid]; ok && len(uid) >= 4 {
+		value := binary.BigEndian.Uint32(uid)
+		pd.Uid = &value
+	}
+	if gid, ok := attrs[nfulaGid]; ok && len(gid) >= 4 {
+		value := binary.BigEndian.Uint32(gid)
+		pd.Gid = &value
+	}
+
+	prefix := strings.TrimRight(string(attrs[nfulaPrefix]), "\x00")
+	return pd, prefix, nil
+}
+
+// Return a PacketDrop object with the fields found in the network and transport headers of given payload
+func getPacketDropFromPayload(payload []byte) (PacketDrop, error) {
+	if len(payload) == 0 {
+		return PacketDrop{}, errors.New("empty NFLOG payload")
+	}
+	switch payload[0] >> 4 {
+	case 4:
+		return getPacketDropFromIPv4(payload)
+	case 6:
+		return getPacketDropFromIPv6(payload)
+	default:
+		return PacketDrop{}, errors.New("NFLOG payload is not an IP packet")
+	}
+}
+
+// Return a PacketDrop object with the fields found in given IPv4 packet
+func getPacketDropFromIPv4(payload []byte) (PacketDrop, error) {
+	if len(payload) < 20 {
+		return PacketDrop{}, errors.New("NFLOG payload too short for IPv4 header")
+	}
+	headerLen := int(payload[0]&0x0f) * 4
+	if headerLen < 20 || len(payload) < headerLen {
+		return PacketDrop{}, fmt.Errorf("invalid IPv4 header length %d", headerLen)
+	}
+	proto := Protocol(payload[9])
+	fragment := binary.BigEndian.Uint16(payload[6:8])
+	pd := PacketDrop{
+		Family: FamilyIPv4,
+		// To16 copies the address out of the receive buffer
+		SrcIP: net.IP(payload[12:16]).To16(),
+		DstIP: net.IP(payload[16:20]).To16(),
+		Proto: proto,
+		Ttl:   payload[8],
+		// same bits of TOS byte as iptables LOG prints for TOS and PREC
+		Length:         binary.BigEndian.Uint16(payload[2:4]),
+		Tos:            payload[1] & 0x1e,
+		Precedence:     payload[1] & 0xe0,
+		IPID:           uint32(binary.BigEndian.Uint16(payload[4:6])),
+		Congestion:     fragment&0x8000 != 0,
+		DontFragment:   fragment&0x4000 != 0,
+		MoreFragments:  fragment&0x2000 != 0,
+		FragmentOffset: fragment & 0x1fff,
+		IPOptions:      strings.ToUpper(hex.EncodeToString(payload[20:headerLen])),
+	}
+
+	// only the first fragment carries the transport header
+	transport := payload[headerLen:]
+	if pd.FragmentOffset == 0 {
+		setTransportFields(&pd, proto, transport)
+	}
+	return pd, nil
+}
+
+// Return a PacketDrop object with the fields found in given IPv6 packet, skipping its extension headers
+func getPacketDropFromIPv6(payload []byte) (PacketDrop, error) {
+	if len(payload) < 40 {
+		return PacketDrop{}, errors.New("NFLOG payload too short for IPv6 header")
+	}
+	versionClassLabel := binary.BigEndian.Uint32(payload[0:4])
+	pd := PacketDrop{
+		Family:       FamilyIPv6,
+		SrcIP:        append(net.IP{}, payload[8:24]...),
+		DstIP:        append(net.IP{}, payload[24:40]...),
+		Ttl:          payload[7],
+		TrafficClass: uint8(versionClassLabel >> 20),
+		FlowLabel:    versionClassLabel & 0xfffff,
+		// ip6tables LOG prints the length including the fixed header
+		Length: binary.BigEndian.Uint16(payload[4:6]) + 40,
+	}
+
+	nextHeader := payload[6]
+	transport := payload[40:]
+	firstFragment := true
+	for isIPv6ExtensionHeader(nextHeader) && len(transport) >= 8 {
+		headerLen := (int(transport[1]) + 1) * 8
+		switch nextHeader {
+		case 44: // fragment header has fixed length
+			headerLen = 8
+			fragment := binary.BigEndian.Uint16(transport[2:4])
+			pd.FragmentOffset = fragment >> 3
+			pd.MoreFragments = fragment&0x1 != 0
+			pd.IPID = binary.BigEndian.Uint32(transport[4:8])
+			firstFragment = pd.FragmentOffset == 0
+		case 51: // authentication header length is in 4-octet units
+			headerLen = (int(transport[1]) + 2) * 4
+		}
+		if headerLen > len(transport) {
+			break
+		}
+		nextHeader = transport[0]
+		transport = transport[headerLen:]
+	}
+
+	pd.Proto = Protocol(nextHeader)
+	if firstFragment {
+		setTransportFields(&pd, pd.Proto, transport)
+	}
+	return pd, nil
+}
+
+// Set the ports, TCP or ICMP fields of given PacketDrop from its transport header
+func setTransportFields(pd *PacketDrop, proto Protocol, transport []byte) {
+	if proto.HasPorts() && len(transport) >= 4 {
+		pd.SrcPort = binary.BigEndian.Uint16(transport[0:2])
+		pd.DstPort = binary.BigEndian.Uint16(transport[2:4])
+		if proto == ProtoTCP && len(transport) >= 20 {
+			pd.TCPSeq = binary.BigEndian.Uint32(transport[4:8])
+			pd.TCPAck = binary.BigEndian.Uint32(transport[8:12])
+			pd.Reserved = (transport[12] & 0x0f) << 2
+			pd.TCPFlags = TCPFlags(transport[13])
+			pd.Window = binary.BigEndian.Uint16(transport[14:16])
+			pd.UrgentPointer = binary.BigEndian.Uint16(transport[18:20])
+			if dataOffset := int(transport[12]>>4) * 4; dataOffset > 20 && dataOffset <= len(transport) {
+				pd.TCPOptions = strings.ToUpper(hex.EncodeToString(transport[20:dataOffset]))
+			}
+		} else if (proto == ProtoUDP || proto == ProtoUDPLite) && len(transport) >= 6 {
+			pd.TransportLength = binary.BigEndian.Uint16(transport[4:6])
+		}
+		return
+	}
+	if !proto.IsIcmp() || len(transport) < 8 {
+		return
+	}
+
+	icmpType, icmpCode := transport[0], transport[1]
+	pd.IcmpType, pd.IcmpCode = &icmpType, icmpCode
+	var isEcho, isError bool
+	if proto == ProtoICMP {
+		isEcho = icmpType == 0 || icmpType == 8
+		isError = icmpType == 3 || icmpType == 4 || icmpType == 11 || icmpType == 12
+		// fragmentation needed
+		if icmpType == 3 && icmpCode == 4 {
+			pd.Mtu = uint32(binary.BigEndian.Uint16(transport[6:8]))
+		}
+	} else {
+		isEcho = icmpType == 128 || icmpType == 129
+		isError = icmpType >= 1 && icmpType <= 4
+		// packet too big
+		if icmpType == 2 {
+			pd.Mtu = binary.BigEndian.Uint32(transport[4:8])
+		}
+	}
+	if isEcho {
+		pd.IcmpID = binary.BigEndian.Uint16(transport[4:6])
+		pd.IcmpSeq = binary.BigEndian.Uint16(transport[6:8])
+	}
+	// ICMP errors carry the header of the original packet
+	if isError {
+		if original, err := getPacketDropFromPayload(transport[8:]); err == nil {
+			pd.Embedded = EmbeddedPacket{
+				SrcIP:   original.SrcIP,
+				SrcPort: original.SrcPort,
+				DstIP:   original.DstIP,
+				DstPort: original.DstPort,
+				Proto:   original.Proto,
+			}
+		}
+	}
+}
+
+// Check if given IPv6 next header value is an extension header rather than upper layer protocol
+func isIPv6ExtensionHeader(nextHeader uint8) bool {
+	switch nextHeader {
+	case 0, 43, 44, 51, 60:
+		return true
+	default:
+		return false
+	}
+}
+
+// Format the link layer header in the same way as the MAC field of iptables LOG target
+func formatMacHeader(header []byte) string {
+	parts := make([]string, len(header))
+	for i, b := range header {
+		parts[i] = fmt.Sprintf("%02x", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+// Helper function to get the interface name of given index, return the index itself if it cannot be found
+func getInterfaceName(index int) string {
+	iface, err := net.InterfaceByIndex(index)
+	if err != nil {
+		return strconv.Itoa(index)
+	}
+	return iface.Name
+}
+
+// Parse the netlink attributes from given buffer into a map keyed by attribute type
+func parseNetlinkAttributes(buf []byte) (map[uint16][]byte, error) {
+	attrs := make(map[uint16][]byte)
+	for len(buf) >= nlattrHdrLen {
+		attrLen := int(nativeEndian.Uint16(buf[0:2]))
+		if attrLen < nlattrHdrLen || attrLen > len(buf) {
+			return nil, fmt.Errorf("invalid netlink attribute length %d", attrLen)
+		}
+		attrType := nativeEndian.Uint16(buf[2:4]) & nlaTypeMask
+		attrs[attrType] = buf[nlattrHdrLen:attrLen]
+		if nlmsgAlign(attrLen) >= len(buf) {
+			break
+		}
+		buf = buf[nlmsgAlign(attrLen):]
+	}
+	return attrs, nil
+}
+
+// Build a NFLOG config message with a single attribute
+func buildNflogConfig(family uint8, group uint16, attrType uint16, attrValue []byte) []byte {
+	attrLen := nlattrHdrLen + len(attrValue)
+	msgLen := nlmsgHdrLen + nfgenmsgLen + nlmsgAlign(attrLen)
+	msg := make([]byte, msgLen)
+	nativeEndian.PutUint32(msg[0:4], uint32(msgLen))
+	nativeEndian.PutUint16(msg[4:6], nfulnlMsgConfig)
+	nativeEndian.PutUint16(msg[6:8], nlmFRequest|nlmFAck)
+	msg[16] = family
+	binary.BigEndian.PutUint16(msg[18:20], group)
+	nativeEndian.PutUint16(msg[20:22], uint16(attrLen))
+	nativeEndian.PutUint16(msg[22:24], attrType)
+	copy(msg[24:], attrValue)
+	return msg
+}
+
+// Build the value of NFULA_CFG_MODE attribute asking for a copy of the packet
+func buildNflogCopyMode(copyRange uint32) []byte {
+	mode := make([]byte, 6)
+	binary.BigEndian.PutUint32(mode[0:4], copyRange)
+	mode[4] = nfulnlCopyPacket
+	return mode
+}
+
+// Round the given length up to the 4 bytes alignment used by netlink
+func nlmsgAlign(length int) int {
+	return (length + 3) &^ 3
+}
+
+// netlink headers are in host byte order
+var nativeEndian = func() binary.ByteOrder {
+	var i uint16 = 1
+	if *(*byte)(unsafe.Pointer(&i)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}()
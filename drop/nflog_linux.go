@@ -0,0 +1,82 @@
+// +build linux
+
+package drop
+
+import (
+	"os"
+	"syscall"
+
+	"go.uber.org/zap"
+)
+
+const netlinkNetfilter = 12
+
+// netlinkSocket is the NetlinkConn talking to the kernel through a NETLINK_NETFILTER socket. The socket is non-blocking
+// and waited on by the runtime poller, so that closing it stops a pending Receive.
+type netlinkSocket struct {
+	file *os.File
+	conn syscall.RawConn
+	buf  []byte
+}
+
+// Open a netlink socket bound to the netfilter subsystem
+func dialNetlink() (NetlinkConn, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC|syscall.SOCK_NONBLOCK,
+		netlinkNetfilter)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+	file := os.NewFile(uintptr(fd), "netlink")
+	conn, err := file.SyscallConn()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &netlinkSocket{file: file, conn: conn, buf: make([]byte, os.Getpagesize()*16)}, nil
+}
+
+func (s *netlinkSocket) Send(msg []byte) error {
+	var sendErr error
+	err := s.conn.Write(func(fd uintptr) bool {
+		sendErr = syscall.Sendto(int(fd), msg, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK})
+		return sendErr != syscall.EAGAIN
+	})
+	if err != nil {
+		return err
+	}
+	return os.NewSyscallError("sendto", sendErr)
+}
+
+func (s *netlinkSocket) Receive() ([]byte, error) {
+	for {
+		var n int
+		var recvErr error
+		err := s.conn.Read(func(fd uintptr) bool {
+			n, _, recvErr = syscall.Recvfrom(int(fd), s.buf, 0)
+			return recvErr != syscall.EAGAIN
+		})
+		if err != nil {
+			return nil, err
+		}
+		if recvErr == syscall.EINTR {
+			continue
+		}
+		if recvErr == syscall.ENOBUFS {
+			// the kernel dropped messages because the socket buffer was full, keep receiving the new ones
+			zap.L().Warn("NFLOG messages lost, socket receive buffer overflowed")
+			continue
+		}
+		if recvErr != nil {
+			return nil, os.NewSyscallError("recvfrom", recvErr)
+		}
+		return s.buf[:n], nil
+	}
+}
+
+func (s *netlinkSocket) Close() error {
+	return s.file.Close()
+}
@@ -0,0 +1,10 @@
+// +build !linux
+
+package drop
+
+import "errors"
+
+// Netlink sockets are only available on linux, NflogWatcher.Run returns this error without waiting for its stop channel
+func dialNetlink() (NetlinkConn, error) {
+	return nil, errors.New("NFLOG watching is only supported on linux")
+}
@@ -0,0 +1,239 @@
+package drop
+
+import (
+	"encoding/hex"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// NFLOG messages recorded from group 5 on a little endian host
+const (
+	// acknowledgement of a config message
+	testNflogAck = "240000000200000000000000000000000000000000000000000000000000000000000000"
+	// TCP SYN 11.111.11.111:56789 -> 22.222.22.222:1234 logged with prefix "calico-drop: "
+	testNflogTcp = "9000000000040000000000000000000002000005080001000800010012000a0063616c69636f2d64726f703a20000000080004000000" +
+		"0002080005000000000306000f000001000006001100000e0000120010005622aa30c4fec6ba6e3156c9080000002c00090045000028" +
+		"1c4640003f0600000b6f0b6f16de16deddd504d200000000000000005002faf000000000"
+	// same packet as testNflogTcp with the kernel timestamp 2019-06-04T08:02:03.178452Z
+	testNflogTcpTimestamp = "a400000000040000000000000000000002000005080001000800010012000a0063616c69636f2d64726f703a2000000008000400" +
+		"00000002080005000000000306000f000001000006001100000e0000120010005622aa30c4fec6ba6e3156c908000000140003000000" +
+		"00005cf6257b000000000002b9142c000900450000281c4640003f0600000b6f0b6f16de16deddd504d2000000000000000050" +
+		"02faf000000000"
+	// UDP fd00:10:244:1::5:5353 -> fd00:10:244:2::7:53 behind a hop-by-hop header logged with prefix "calico-drop: "
+	testNflogUdp6 = "740000000004000000000000000000000a0000050800010086dd030012000a0063616c69636f2d64726f703a200000000800050000" +
+		"0000033c0009006001234500100040fd000010024400010000000000000005fd0000100244000200000000000000071100010400" +
+		"00000014e9003500080000"
+	// UDP 10.0.0.1:53 -> 10.0.0.2:40000 logged with prefix "fw-accept: "
+	testNflogUdp = "5400000000040000000000000000000002000005080001000800030010000a0066772d6163636570743a200008000500000000032000" +
+		"09004500001c1c464000401100000a0000010a00000200359c4000080000"
+)
+
+// FakeNetlinkConn replays the recorded messages and keeps the messages sent to it. Once all the messages are replayed,
+// it blocks until closed if it has a close channel.
+type FakeNetlinkConn struct {
+	sent     [][]byte
+	received []string
+	closeCh  chan struct{}
+}
+
+func (conn *FakeNetlinkConn) Send(msg []byte) error {
+	conn.sent = append(conn.sent, msg)
+	return nil
+}
+
+func (conn *FakeNetlinkConn) Receive() ([]byte, error) {
+	if len(conn.received) == 0 {
+		if conn.closeCh != nil {
+			<-conn.closeCh
+		}
+		return nil, io.EOF
+	}
+	msg := conn.received[0]
+	conn.received = conn.received[1:]
+	return hex.DecodeString(msg)
+}
+
+func (conn *FakeNetlinkConn) Close() error {
+	if conn.closeCh != nil {
+		close(conn.closeCh)
+	}
+	return nil
+}
+
+// Helper function to init a NFLOG watcher using the fake netlink connection
+func initTestNflogWatcher(conn NetlinkConn) *NflogWatcher {
+	watcher := InitNflogWatcher(5, getTestLogPrefixes("calico-drop:"))
+	watcher.hostName = testHostname
+	watcher.conn = conn
+	watcher.interfaceName = func(index int) string {
+		return "eth" + strconv.Itoa(index-2)
+	}
+	return watcher
+}
+
+// Test if NFLOG watcher subscribes to its group and only inserts the required packet drops into channel
+func TestNflogWatcherRun(t *testing.T) {
+	conn := &FakeNetlinkConn{received: []string{
+		testNflogAck,
+		testNflogTcpTimestamp, // expired
+		testNflogTcp,
+		testNflogUdp, // prefix not required
+	}}
+	watcher := initTestNflogWatcher(conn)
+	channel := make(chan PacketDrop, 10)
+	err := watcher.run(nil, channel)
+	if err != io.EOF {
+		t.Fatalf("Expected error %v, but got error %v", io.EOF, err)
+	}
+
+	if len(conn.sent) != 6 {
+		t.Fatalf("Expected 6 config messages sent, but got %d", len(conn.sent))
+	}
+	bind := conn.sent[4]
+	if group := int(bind[18])<<8 | int(bind[19]); group != 5 {
+		t.Fatalf("Expected binding group 5, but got group %d", group)
+	}
+
+	if len(channel) != 1 {
+		t.Fatalf("Expected 1 packet drop in channel, but got %d", len(channel))
+	}
+	result := <-channel
+	expected := PacketDrop{
+		LogTime:           result.LogTime,
+		HostName:          testHostname,
+		LogPrefix:         watcher.logPrefixes[0],
+		Family:            FamilyIPv4,
+		SrcIP:             net.ParseIP(testSrcIP),
+		SrcPort:           testSrcPort,
+		DstIP:             net.ParseIP(testDstIP),
+		DstPort:           testDstPort,
+		Proto:             ProtoTCP,
+		InterfaceReceived: testInterfaceReceived,
+		InterfaceSent:     testInterfaceSent,
+		MacAddress:        testMacAddress,
+		Ttl:               testPacketTtl,
+		Length:            40,
+		IPID:              7238,
+		DontFragment:      true,
+		Window:            64240,
+		TCPFlags:          TCPFlagSYN,
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("Expected %+v, but got result %+v", expected, result)
+	}
+	if time.Since(result.LogTime) > time.Minute {
+		t.Fatalf("Expected current log time, but got %v", result.LogTime)
+	}
+}
+
+// Test if NFLOG watcher closes its netlink connection and returns without error once the stop channel is closed
+func TestNflogWatcherStop(t *testing.T) {
+	conn := &FakeNetlinkConn{received: []string{testNflogAck, testNflogTcp}, closeCh: make(chan struct{})}
+	watcher := initTestNflogWatcher(conn)
+	channel := make(chan PacketDrop, 10)
+	stopCh := make(chan struct{})
+	errCh := make(chan error)
+	go func() {
+		errCh <- watcher.Run(stopCh, channel)
+	}()
+
+	<-channel
+	close(stopCh)
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("Expected error nil, but got error %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected watcher stopped, but it's still running")
+	}
+}
+
+// Test if NFLOG watcher takes the log time from the kernel timestamp
+func TestNflogGetPacketDropTimestamp(t *testing.T) {
+	watcher := initTestNflogWatcher(&FakeNetlinkConn{})
+	msg, _ := hex.DecodeString(testNflogTcpTimestamp)
+	result, prefix, err := watcher.getPacketDrop(msg[nlmsgHdrLen:])
+	if err != nil {
+		t.Fatalf("Expected error nil, but got error %s", err)
+	}
+	if prefix != "calico-drop: " {
+		t.Fatalf("Expected prefix %q, but got prefix %q", "calico-drop: ", prefix)
+	}
+	expected := time.Date(2019, 6, 4, 8, 2, 3, 178452000, time.UTC)
+	if !result.LogTime.Equal(expected) {
+		t.Fatalf("Expected log time %v, but got log time %v", expected, result.LogTime)
+	}
+}
+
+// Test if NFLOG watcher works for IPv6 packets
+func TestNflogGetPacketDropIPv6(t *testing.T) {
+	watcher := initTestNflogWatcher(&FakeNetlinkConn{})
+	msg, _ := hex.DecodeString(testNflogUdp6)
+	result, _, err := watcher.getPacketDrop(msg[nlmsgHdrLen:])
+	if err != nil {
+		t.Fatalf("Expected error nil, but got error %s", err)
+	}
+	expected := PacketDrop{
+		LogTime:         result.LogTime,
+		HostName:        testHostname,
+		Family:          FamilyIPv6,
+		SrcIP:           net.ParseIP("fd00:10:244:1::5"),
+		SrcPort:         5353,
+		DstIP:           net.ParseIP("fd00:10:244:2::7"),
+		DstPort:         53,
+		Proto:           ProtoUDP,
+		InterfaceSent:   testInterfaceSent,
+		Ttl:             64,
+		TrafficClass:    0,
+		FlowLabel:       74565,
+		Length:          56,
+		TransportLength: 8,
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("Expected %+v, but got result %+v", expected, result)
+	}
+}
+
+// Test if NFLOG watcher gets the ICMP fields and the original packet carried by ICMP errors
+func TestNflogGetPacketDropIcmp(t *testing.T) {
+	// ping 10.244.1.5 -> 10.244.2.7
+	echo, _ := hex.DecodeString("4500001c1c4600003f0100000af401050af402070800000000070001")
+	result, err := getPacketDropFromPayload(echo)
+	if err != nil {
+		t.Fatalf("Expected error nil, but got error %s", err)
+	}
+	expected := PacketDrop{Family: FamilyIPv4, SrcIP: net.ParseIP("10.244.1.5"), DstIP: net.ParseIP("10.244.2.7"), Proto: ProtoICMP, Ttl: 63,
+		IcmpType: uint8Pointer(8), IcmpID: 7, IcmpSeq: 1, Length: 28, IPID: 7238}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("Expected %+v, but got result %+v", expected, result)
+	}
+
+	// port unreachable 10.244.2.7 -> 10.244.1.5 for UDP 10.244.1.5:40000 -> 10.244.2.7:53
+	unreachable, _ := hex.DecodeString("450000381c460000400100000af402070af4010503030000000000004500001c1c4600003f11" +
+		"00000af401050af402079c40003500080000")
+	result, err = getPacketDropFromPayload(unreachable)
+	if err != nil {
+		t.Fatalf("Expected error nil, but got error %s", err)
+	}
+	expected = PacketDrop{Family: FamilyIPv4, SrcIP: net.ParseIP("10.244.2.7"), DstIP: net.ParseIP("10.244.1.5"), Proto: ProtoICMP, Ttl: 64,
+		IcmpType: uint8Pointer(3), IcmpCode: 3, Embedded: EmbeddedPacket{SrcIP: net.ParseIP("10.244.1.5"), SrcPort: 40000,
+			DstIP: net.ParseIP("10.244.2.7"), DstPort: 53, Proto: ProtoUDP}, Length: 56, IPID: 7238}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("Expected %+v, but got result %+v", expected, result)
+	}
+}
+
+// Test if NFLOG watcher returns error for payloads which are not valid IP packets
+func TestNflogGetPacketDropFromBadPayload(t *testing.T) {
+	if _, err := getPacketDropFromPayload([]byte{0x45, 0x00}); err == nil {
+		t.Fatalf("Expected error from truncated payload, but got nil")
+	}
+	if _, err := getPacketDropFromPayload(make([]byte, 40)); err == nil {
+		t.Fatalf("Expected error from payload which is not an IP packet, but got nil")
+	}
+}
@@ -0,0 +1,33 @@
+package drop
+
+import "strings"
+
+// nftables logs link layer header of bridge and netdev families in separate fields instead of the MAC field
+const fieldMacSrc = "MACSRC"
+const fieldMacDst = "MACDST"
+const fieldMacProto = "MACPROTO"
+
+// Return the MAC field of given log fields, or build it from the separate MAC fields logged by nftables
+// in the same "destination:source:type" layout as iptables LOG
+func getMacAddress(logFields []string) string {
+	macFields := make(map[string]string)
+	for _, field := range logFields {
+		if parts := strings.SplitN(field, "=", 2); len(parts) == 2 {
+			if _, ok := macFields[parts[0]]; !ok {
+				macFields[parts[0]] = parts[1]
+			}
+		}
+	}
+	if mac, ok := macFields[fieldMacAddress]; ok {
+		return mac
+	}
+	src, dst := macFields[fieldMacSrc], macFields[fieldMacDst]
+	if src == "" || dst == "" {
+		return ""
+	}
+	mac := dst + ":" + src
+	if proto := macFields[fieldMacProto]; len(proto) == 4 {
+		mac += ":" + proto[0:2] + ":" + proto[2:4]
+	}
+	return mac
+}
@@ -3,7 +3,7 @@ package drop
 import (
 	"errors"
 	"fmt"
-	"reflect"
+	"net"
 	"strings"
 	"time"
 
@@ -21,39 +21,227 @@ const fieldProto = "PROTO"
 const fieldInterfaceSent = "OUT"
 const fieldInterfaceReceived = "IN"
 const fieldTtl = "TTL"
+const fieldHopLimit = "HOPLIMIT"
+const fieldTrafficClass = "TC"
+const fieldFlowLabel = "FLOWLBL"
 const fieldMacAddress = "MAC"
+const fieldUid = "UID"
+const fieldGid = "GID"
+const fieldIcmpType = "TYPE"
+const fieldIcmpCode = "CODE"
+const fieldIcmpID = "ID"
+const fieldIcmpSeq = "SEQ"
+const fieldMtu = "MTU"
+const fieldLength = "LEN"
+const fieldTos = "TOS"
+const fieldPrecedence = "PREC"
+const fieldIPID = "ID"
+const fieldCongestion = "CE"
+const fieldDontFragment = "DF"
+const fieldMoreFragments = "MF"
+const fieldFragment = "FRAG"
+const fieldOptions = "OPT"
+const fieldTCPSeq = "SEQ"
+const fieldTCPAck = "ACK"
+const fieldWindow = "WINDOW"
+const fieldReserved = "RES"
+const fieldUrgentPointer = "URGP"
+const fieldMark = "MARK"
+const fieldPhysInterfaceReceived = "PHYSIN"
+const fieldPhysInterfaceSent = "PHYSOUT"
+
+// payload of PacketDrop needs at least 2 different fields: source and destination IPs
+const minPacketDropLogFields = 2
+
+// IP families of PacketDrop
+const (
+	FamilyIPv4 = "IPv4"
+	FamilyIPv6 = "IPv6"
+)
+
+// EmbeddedPacket is the header of the original packet carried by ICMP error messages, e.g. port-unreachable.
+type EmbeddedPacket struct {
+	SrcIP   net.IP
+	SrcPort uint16
+	DstIP   net.IP
+	DstPort uint16
+	Proto   Protocol
+}
 
 // PacketDrop is the result object parsed from single raw log containing information about an iptables packet drop.
+// IPs are kept in their 16-byte form. Ttl holds the hop limit for IPv6 packets, TrafficClass and FlowLabel are only
+// logged by ip6tables. Ports are zero for protocols without them (e.g. ICMP, GRE, ESP), the Icmp fields are only set
+// for ICMP and ICMPv6. Uid, Gid and IcmpType are nil when they are not logged, as zero is one of their values.
+// The numeric header fields are zero when they are not logged, e.g. Mark is only logged for marked packets, and the
+// TCP fields are only set for TCP packets.
 type PacketDrop struct {
 	LogTime           time.Time
 	HostName          string
-	SrcIP             string
-	SrcPort           string
-	DstIP             string
-	DstPort           string
-	Proto             string
+	Family            string
+	SrcIP             net.IP
+	SrcPort           uint16
+	DstIP             net.IP
+	DstPort           uint16
+	Proto             Protocol
 	InterfaceReceived string
 	InterfaceSent     string
 	MacAddress        string
-	Ttl               string
-}
+	Ttl               uint8
+	TrafficClass      uint8
+	FlowLabel         uint32
+	Uid               *uint32
+	Gid               *uint32
+	IcmpType          *uint8
+	IcmpCode          uint8
+	IcmpID            uint16
+	IcmpSeq           uint16
+	Mtu               uint32
+	Embedded          EmbeddedPacket
+
+	Length                uint16
+	Tos                   uint8
+	Precedence            uint8
+	IPID                  uint32
+	Congestion            bool
+	DontFragment          bool
+	MoreFragments         bool
+	FragmentOffset        uint16
+	IPOptions             string
+	TransportLength       uint16
+	TCPSeq                uint32
+	TCPAck                uint32
+	Window                uint16
+	Reserved              uint8
+	TCPFlags              TCPFlags
+	UrgentPointer         uint16
+	TCPOptions            string
+	Mark                  uint32
+	PhysInterfaceReceived string
+	PhysInterfaceSent     string
+
+	// only set for the link layer headers logged by ebtables, arptables and nftables of bridge and netdev families,
+	// ArpOpcode is only set for ARP packets, which have no protocol nor ports
+	SrcMacAddress string
+	DstMacAddress string
+	EtherType     uint16
+	ArpOpcode     uint16
+
+	// only set by the CNIs dropping the packets on their own, e.g. Cilium
+	DropReason  string
+	SrcIdentity uint32
+	DstIdentity uint32
 
-var fieldCount = reflect.ValueOf(PacketDrop{}).NumField()
+	LogPrefix    *LogPrefix
+	PrefixFields map[string]string
+	Source       string // where the log was read from, e.g. the path of the log file
+
+	pending *pendingCheckpoint // checkpoint of the log, kept once the packet drop is done
+}
 
 func (pd *PacketDrop) MarshalLogObject(enc zapcore.ObjectEncoder) error {
 	enc.AddTime("pkt_log_time", pd.LogTime)
-	enc.AddString("pkt_src_ip", pd.SrcIP)
-	enc.AddString("pkt_src_port", pd.SrcPort)
-	enc.AddString("pkt_dst_ip", pd.DstIP)
-	enc.AddString("pkt_dst_port", pd.DstPort)
-	enc.AddString("pkt_proto", pd.Proto)
-	enc.AddString("pkt_ttl", pd.Ttl)
+	enc.AddString("pkt_family", pd.Family)
+	enc.AddString("pkt_src_ip", pd.SrcIP.String())
+	enc.AddUint16("pkt_src_port", pd.SrcPort)
+	enc.AddString("pkt_dst_ip", pd.DstIP.String())
+	enc.AddUint16("pkt_dst_port", pd.DstPort)
+	enc.AddString("pkt_proto", pd.Proto.String())
+	enc.AddUint8("pkt_ttl", pd.Ttl)
+	if pd.Family == FamilyIPv6 {
+		enc.AddUint8("pkt_traffic_class", pd.TrafficClass)
+		enc.AddUint32("pkt_flow_label", pd.FlowLabel)
+	}
+	if pd.Source != "" {
+		enc.AddString("pkt_source", pd.Source)
+	}
 	enc.AddString("pkt_mac_addr", pd.MacAddress)
+	if pd.EtherType != 0 {
+		enc.AddString("pkt_src_mac_addr", pd.SrcMacAddress)
+		enc.AddString("pkt_dst_mac_addr", pd.DstMacAddress)
+		enc.AddString("pkt_ether_type", fmt.Sprintf("0x%04x", pd.EtherType))
+	}
+	if pd.IsArp() {
+		enc.AddUint16("pkt_arp_opcode", pd.ArpOpcode)
+	}
 	enc.AddString("pkt_interface_recv", pd.InterfaceReceived)
 	enc.AddString("pkt_interface_sent", pd.InterfaceSent)
+	if pd.Uid != nil {
+		enc.AddUint32("pkt_uid", *pd.Uid)
+	}
+	if pd.Gid != nil {
+		enc.AddUint32("pkt_gid", *pd.Gid)
+	}
+	if pd.IsIcmp() && pd.IcmpType != nil {
+		enc.AddUint8("pkt_icmp_type", *pd.IcmpType)
+		enc.AddUint8("pkt_icmp_code", pd.IcmpCode)
+		enc.AddUint16("pkt_icmp_id", pd.IcmpID)
+		enc.AddUint16("pkt_icmp_seq", pd.IcmpSeq)
+		enc.AddUint32("pkt_mtu", pd.Mtu)
+	}
+	enc.AddUint16("pkt_len", pd.Length)
+	enc.AddString("pkt_tos", fmt.Sprintf("0x%02X", pd.Tos))
+	enc.AddString("pkt_prec", fmt.Sprintf("0x%02X", pd.Precedence))
+	enc.AddUint32("pkt_id", pd.IPID)
+	enc.AddBool("pkt_ce", pd.Congestion)
+	enc.AddBool("pkt_df", pd.DontFragment)
+	enc.AddBool("pkt_mf", pd.MoreFragments)
+	enc.AddUint16("pkt_frag", pd.FragmentOffset)
+	enc.AddString("pkt_ip_options", pd.IPOptions)
+	enc.AddString("pkt_mark", fmt.Sprintf("0x%x", pd.Mark))
+	enc.AddString("pkt_phys_interface_recv", pd.PhysInterfaceReceived)
+	enc.AddString("pkt_phys_interface_sent", pd.PhysInterfaceSent)
+	if pd.Proto == ProtoTCP {
+		enc.AddUint32("pkt_tcp_seq", pd.TCPSeq)
+		enc.AddUint32("pkt_tcp_ack", pd.TCPAck)
+		enc.AddUint16("pkt_tcp_window", pd.Window)
+		enc.AddString("pkt_tcp_res", fmt.Sprintf("0x%02x", pd.Reserved))
+		enc.AddString("pkt_tcp_flags", pd.TCPFlags.String())
+		enc.AddUint16("pkt_tcp_urgp", pd.UrgentPointer)
+		enc.AddString("pkt_tcp_options", pd.TCPOptions)
+	} else if pd.TransportLength != 0 {
+		enc.AddUint16("pkt_transport_len", pd.TransportLength)
+	}
+	if pd.DropReason != "" {
+		enc.AddString("pkt_drop_reason", pd.DropReason)
+	}
+	if pd.SrcIdentity != 0 || pd.DstIdentity != 0 {
+		enc.AddUint32("pkt_src_identity", pd.SrcIdentity)
+		enc.AddUint32("pkt_dst_identity", pd.DstIdentity)
+	}
+	if pd.LogPrefix != nil {
+		enc.AddString("pkt_log_prefix", pd.LogPrefix.String())
+		for name, value := range pd.PrefixFields {
+			enc.AddString("pkt_prefix_"+name, value)
+		}
+	}
+	if pd.Embedded.SrcIP != nil {
+		enc.AddString("pkt_embedded_src_ip", pd.Embedded.SrcIP.String())
+		enc.AddUint16("pkt_embedded_src_port", pd.Embedded.SrcPort)
+		enc.AddString("pkt_embedded_dst_ip", pd.Embedded.DstIP.String())
+		enc.AddUint16("pkt_embedded_dst_port", pd.Embedded.DstPort)
+		enc.AddString("pkt_embedded_proto", pd.Embedded.Proto.String())
+	}
 	return nil
 }
 
+// Check if PacketDrop is an ICMP or ICMPv6 packet
+func (pd PacketDrop) IsIcmp() bool {
+	return pd.Proto.IsIcmp()
+}
+
+// Check if PacketDrop is an ARP packet
+func (pd PacketDrop) IsArp() bool {
+	return pd.EtherType == EtherTypeARP
+}
+
+// Return the name of the protocol of PacketDrop, which is "ARP" for ARP packets
+func (pd PacketDrop) GetProtocolName() string {
+	if pd.IsArp() {
+		return "ARP"
+	}
+	return pd.Proto.String()
+}
+
 // Check if PacketDrop is expired
 func (pd PacketDrop) IsExpired() bool {
 	logTime := pd.GetLogTime()
@@ -63,151 +251,406 @@ func (pd PacketDrop) IsExpired() bool {
 	return curTime.Sub(logTime).Minutes() > expiredMinutes
 }
 
+// Mark PacketDrop handled, so the position of its log is kept as checkpoint once the logs before it are handled as well
+func (pd PacketDrop) Done() {
+	if pd.pending != nil {
+		pd.pending.markDone()
+	}
+}
+
 // Get the time object of PacketDrop log time
 func (pd PacketDrop) GetLogTime() time.Time {
 	return pd.LogTime
 }
 
-// Parse the logs from given channel and insert objects of PacketDrop as parsing result to another channel
-func RunParsing(logPrefix string, logChangeCh <-chan string, packetDropCh chan<- PacketDrop) {
-	logTimeLayout := util.GetEnvStringOrDefault(util.PacketDropLogTimeLayout, util.DefaultPacketDropLogTimeLayout)
-	for log := range logChangeCh {
-		parseErr := parse(logPrefix, log, packetDropCh, logTimeLayout)
+// Parse the records from given channel, whose raw logs are of given log format, and insert objects of PacketDrop as
+// parsing result to another channel. The checkpoints of the records are set to given checkpoint store (which may be
+// nil) once their packet drops are done, or once they are parsed if they have no packet drop.
+func RunParsing(logPrefixes LogPrefixes, logFormat *LogFormat, recordCh <-chan Record,
+	packetDropCh chan<- PacketDrop, checkpoints *CheckpointStore) {
+	queues := make(map[string]*checkpointQueue) // source of the checkpoints as key
+	for record := range recordCh {
+		if record.Checkpoint != nil && checkpoints != nil {
+			queue, ok := queues[record.Checkpoint.Source]
+			if !ok {
+				queue = &checkpointQueue{store: checkpoints}
+				queues[record.Checkpoint.Source] = queue
+			}
+			record.pending = queue.add(*record.Checkpoint)
+		}
+		parseErr := parse(logPrefixes, logFormat, record, packetDropCh)
+		if record.pending != nil && !record.pending.posted {
+			record.pending.markDone()
+		}
 		if parseErr != nil {
 			// report the current error log but continue the parsing process
 			zap.L().Error("Cannot parse the log line",
-				zap.String("log", log),
+				zap.String("log", record.Message),
+				zap.String("source", record.Source),
 				zap.String("error", parseErr.Error()),
 			)
 		}
 	}
 }
 
-// Parse the given log, and insert the result to PacketDrop's channel if it's not expired
-func parse(logPrefix, log string, packetDropCh chan<- PacketDrop, logTimeLayout string) error {
+// Parse the given record, and insert the result to PacketDrop's channel if it's not expired. The records which are not
+// packet drops (e.g. ebtables logs without the IP header) are ignored.
+func parse(logPrefixes LogPrefixes, logFormat *LogFormat, record Record, packetDropCh chan<- PacketDrop) error {
+	if logFormat.decode != nil {
+		return parseStructured(logPrefixes, logFormat, record, packetDropCh)
+	}
 	// only parse the required packet drop logs
-	if !isRequiredPacketDropLog(logPrefix, log) {
+	logPrefix, prefixFields := logPrefixes.Match(record.Message)
+	if logPrefix == nil {
 		return nil
 	}
-	zap.L().Debug("Parsing new packet", zap.String("raw", log))
+	zap.L().Debug("Parsing new packet", zap.String("raw", record.Message))
 	// parse the log and get an object of PacketDrop as result
-	packetDrop, err := getPacketDrop(log, logTimeLayout)
+	packetDrop, err := getPacketDrop(record, logFormat)
+	if err == errNotPacketDrop {
+		return nil
+	}
 	if err != nil {
 		return err
 	}
-	// only insert the packetDrop into channel if it's not expired
-	if !packetDrop.IsExpired() {
-		packetDropCh <- packetDrop
-	}
+	packetDrop.LogPrefix, packetDrop.PrefixFields = logPrefix, prefixFields
+	sendPacketDrop(record, packetDrop, packetDropCh)
 
 	return nil
 }
 
-// Check if a log is a required packet drop containing the given log prefix
-func isRequiredPacketDropLog(logPrefix, log string) bool {
-	for _, field := range strings.Fields(log) {
-		if field == logPrefix {
-			return true
+// Parse the given record of structured log, whose log prefix is matched after decoding it as it is in a field of its
+// own, and insert the result to PacketDrop's channel if it's not expired. The records which are not packet drops (e.g.
+// the flows forwarded) are ignored.
+func parseStructured(logPrefixes LogPrefixes, logFormat *LogFormat, record Record,
+	packetDropCh chan<- PacketDrop) error {
+	packetDrop, prefix, err := logFormat.decode(logFormat, record)
+	if err == errNotPacketDrop {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	logPrefix, prefixFields := logPrefixes.Match(prefix)
+	if logPrefix == nil {
+		return nil
+	}
+	// the fields decoded from the log can be used like the named parts of the log prefix
+	for name, value := range prefixFields {
+		if packetDrop.PrefixFields == nil {
+			packetDrop.PrefixFields = make(map[string]string)
 		}
+		packetDrop.PrefixFields[name] = value
 	}
-	return false
+	packetDrop.LogPrefix = logPrefix
+	zap.L().Info("Parsed new packet", zap.String("raw", record.Message), zap.Object("packet_drop", &packetDrop))
+	sendPacketDrop(record, packetDrop, packetDropCh)
+	return nil
 }
 
-// Return a PacketDrop object constructed from given PacketDropLog
-func getPacketDrop(packetDropLog, logTimeLayout string) (PacketDrop, error) {
-	// object PacketDrop needs at least 4 different fields
-	logFields, err := getPacketDropLogFields(packetDropLog)
-	if err != nil {
-		return PacketDrop{}, err
+// Helper function to insert given packet drop of given record into channel if it's not expired, where the poster marks
+// it done
+func sendPacketDrop(record Record, packetDrop PacketDrop, packetDropCh chan<- PacketDrop) {
+	if packetDrop.IsExpired() {
+		return
 	}
+	if record.pending != nil {
+		record.pending.posted = true
+		packetDrop.pending = record.pending
+	}
+	packetDropCh <- packetDrop
+}
 
-	// get log time and host name
+// Return a PacketDrop object constructed from given record, whose raw log is of given log format
+func getPacketDrop(record Record, logFormat *LogFormat) (PacketDrop, error) {
+	packetDropLog := record.Message
+	// get log time, host name and the payload containing the fields, unless the source has already got them
+	logTime, hostName, payload := record.Time, record.Host, record.Message
+	if !record.HasTime() {
+		var err error
+		if logTime, hostName, payload, err = logFormat.Parse(packetDropLog); err != nil {
+			return PacketDrop{}, err
+		}
+	}
 
-	logTime, err := time.Parse(logTimeLayout, logFields[0])
+	// ebtables and arptables log in their own layout
+	if isBridgeLog(payload) {
+		pd, err := getBridgePacketDrop(payload)
+		if err != nil {
+			return PacketDrop{}, err
+		}
+		pd.LogTime, pd.HostName, pd.Source = logTime, hostName, record.Source
+		zap.L().Info("Parsed new packet", zap.String("raw", packetDropLog), zap.Object("packet_drop", &pd))
+		return pd, nil
+	}
+
+	logFields, err := getPacketDropLogFields(payload)
 	if err != nil {
 		return PacketDrop{}, err
 	}
 
-	hostName := logFields[1]
+	// ICMP errors carry the header of the original packet, keep its fields apart from the ones of the ICMP packet
+	logFields, embeddedFields := splitEmbeddedFields(logFields)
+
+	logFieldMap := getFieldMap(logFields)
+	pd := PacketDrop{
+		LogTime:  logTime,
+		HostName: hostName,
+		Source:   record.Source,
+		// nftables logs don't always contain the OUT field
+		InterfaceSent: logFieldMap[fieldInterfaceSent],
+		// Logs don't always contain the MAC field
+		MacAddress: getMacAddress(logFields),
+	}
+	// UID and GID are only logged for local sockets with --log-uid or nftables "log flags skuid"
+	if pd.Uid, err = getOptionalUint32Field(logFieldMap, fieldUid); err != nil {
+		return PacketDrop{}, err
+	}
+	if pd.Gid, err = getOptionalUint32Field(logFieldMap, fieldGid); err != nil {
+		return PacketDrop{}, err
+	}
 
 	// get src and dst IPs
-	srcIP, err := getFieldValue(logFields, fieldSrcIP)
-	if err != nil {
+	if pd.SrcIP, err = getIPField(logFieldMap, fieldSrcIP); err != nil {
 		return PacketDrop{}, err
 	}
-	srcPort, err := getFieldValue(logFields, fieldSrcPort)
-	if err != nil {
+	if pd.DstIP, err = getIPField(logFieldMap, fieldDstIP); err != nil {
 		return PacketDrop{}, err
 	}
-	dstIP, err := getFieldValue(logFields, fieldDstIP)
-	if err != nil {
+	if pd.Proto, err = getProtocolField(logFieldMap, fieldProto); err != nil {
 		return PacketDrop{}, err
 	}
-	dstPort, err := getFieldValue(logFields, fieldDstPort)
-	if err != nil {
+	// ports are only logged for the protocols having them
+	if pd.SrcPort, pd.DstPort, err = getPortFields(logFieldMap, pd.Proto); err != nil {
 		return PacketDrop{}, err
 	}
-	proto, err := getFieldValue(logFields, fieldProto)
-	if err != nil {
+
+	interfaceReceived, ok := logFieldMap[fieldInterfaceReceived]
+	if !ok {
+		return PacketDrop{}, &ParseError{Field: fieldInterfaceReceived, Err: ErrMissingField}
+	}
+	pd.InterfaceReceived = interfaceReceived
+	if err := setLinkLayerFields(&pd, logFieldMap); err != nil {
 		return PacketDrop{}, err
 	}
 
-	interfaceReceived, err := getFieldValue(logFields, fieldInterfaceReceived)
+	// ip6tables logs the hop limit, traffic class and flow label instead of TTL
+	pd.Family = getFamily(pd.SrcIP)
+	ttlField := fieldTtl
+	if pd.Family == FamilyIPv6 {
+		ttlField = fieldHopLimit
+	}
+	if _, ok := logFieldMap[ttlField]; !ok {
+		return PacketDrop{}, &ParseError{Field: ttlField, Err: ErrMissingField}
+	}
+	ttl, err := getUintField(logFieldMap, ttlField, 8)
 	if err != nil {
 		return PacketDrop{}, err
 	}
-	interfaceSent, err := getFieldValue(logFields, fieldInterfaceSent)
+	trafficClass, err := getUintField(logFieldMap, fieldTrafficClass, 8)
 	if err != nil {
 		return PacketDrop{}, err
 	}
-
-	// Logs don't always contain the MAC field
-	macAddress, _ := getFieldValue(logFields, fieldMacAddress)
-
-	ttl, err := getFieldValue(logFields, fieldTtl)
+	flowLabel, err := getUintField(logFieldMap, fieldFlowLabel, 20)
 	if err != nil {
 		return PacketDrop{}, err
 	}
+	pd.Ttl, pd.TrafficClass, pd.FlowLabel = uint8(ttl), uint8(trafficClass), uint32(flowLabel)
 
-	pd := PacketDrop{
-		LogTime:           logTime,
-		HostName:          hostName,
-		SrcIP:             srcIP,
-		SrcPort:           srcPort,
-		DstIP:             dstIP,
-		DstPort:           dstPort,
-		Proto:             proto,
-		InterfaceReceived: interfaceReceived,
-		InterfaceSent:     interfaceSent,
-		MacAddress:        macAddress,
-		Ttl:               ttl}
+	if pd.IsIcmp() {
+		// the ICMP ID has the same name as the IP ID, only look for it after the PROTO field
+		transportFieldMap := getFieldMap(getTransportFields(logFields))
+		if err := setIcmpFields(&pd, transportFieldMap); err != nil {
+			return PacketDrop{}, err
+		}
+	}
+	if len(embeddedFields) > 0 {
+		if pd.Embedded, err = getEmbeddedPacket(embeddedFields); err != nil {
+			return PacketDrop{}, err
+		}
+	}
+	if err := setHeaderFields(&pd, logFields); err != nil {
+		return PacketDrop{}, err
+	}
 
 	zap.L().Info("Parsed new packet", zap.String("raw", packetDropLog), zap.Object("packet_drop", &pd))
 
 	return pd, nil
 }
 
-// Helper function to check and return fields (if there are enough of them) of given PacketDrop log
+// Helper function to check and return fields (if there are enough of them) of given PacketDrop log payload
 func getPacketDropLogFields(packetDropLog string) ([]string, error) {
 	logFields := strings.Fields(packetDropLog)
 	// check if the logFields contain enough information about a packet drop
-	if len(logFields) < fieldCount {
+	if len(logFields) < minPacketDropLogFields {
 		return []string{}, errors.New(fmt.Sprintf("Invalid packet drop: log=%+v", packetDropLog))
 	}
 	return logFields, nil
 }
 
-// Helper function to get the field from log: "... fieldName=1.1.1" returns "1.1.1"
-func getFieldValue(logFields []string, fieldName string) (string, error) {
-	for _, field := range logFields {
-		if strings.HasPrefix(field, fieldName) {
-			fieldStrs := strings.Split(field, "=")
-			if len(fieldStrs) < 2 {
-				return "", errors.New(fmt.Sprintf("Missing value: field=%+v", fieldName))
+// Helper function to split given log fields into the fields of the logged packet and the fields of the original
+// packet embedded in ICMP errors, which iptables logs inside brackets after the ICMP fields: "... [SRC=1.1.1.1 ...]"
+func splitEmbeddedFields(logFields []string) ([]string, []string) {
+	transportStart := len(logFields) - len(getTransportFields(logFields))
+	for start := transportStart; start < len(logFields); start++ {
+		if !strings.HasPrefix(logFields[start], "[SRC=") {
+			continue
+		}
+		for end := start; end < len(logFields); end++ {
+			if strings.HasSuffix(logFields[end], "]") {
+				embedded := append([]string{}, logFields[start:end+1]...)
+				embedded[0] = strings.TrimPrefix(embedded[0], "[")
+				embedded[len(embedded)-1] = strings.TrimSuffix(embedded[len(embedded)-1], "]")
+				outer := append(append([]string{}, logFields[:start]...), logFields[end+1:]...)
+				return outer, embedded
 			}
-			return fieldStrs[1], nil
+		}
+	}
+	return logFields, nil
+}
+
+// Helper function to set the fields of network and transport headers of given PacketDrop from given log fields
+func setHeaderFields(pd *PacketDrop, logFields []string) error {
+	var err error
+	getUint := func(fieldMap map[string]string, fieldName string, bitSize int) uint64 {
+		if err != nil {
+			return 0
+		}
+		var number uint64
+		number, err = getUintField(fieldMap, fieldName, bitSize)
+		return number
+	}
+
+	// fields of the whole log, e.g. MARK is logged after the transport header
+	logFieldMap := getFieldMap(logFields)
+	pd.Mark = uint32(getUint(logFieldMap, fieldMark, 32))
+	pd.PhysInterfaceReceived = logFieldMap[fieldPhysInterfaceReceived]
+	pd.PhysInterfaceSent = logFieldMap[fieldPhysInterfaceSent]
+
+	// fields of the network header, from SRC to PROTO
+	networkFields := getNetworkFields(logFields)
+	networkFieldMap := getFieldMap(networkFields)
+	pd.Length = uint16(getUint(networkFieldMap, fieldLength, 16))
+	pd.Tos = uint8(getUint(networkFieldMap, fieldTos, 8))
+	pd.Precedence = uint8(getUint(networkFieldMap, fieldPrecedence, 8))
+	pd.IPID = uint32(getUint(networkFieldMap, fieldIPID, 32))
+	pd.FragmentOffset = uint16(getUint(networkFieldMap, fieldFragment, 16))
+	pd.Congestion = hasFlag(networkFields, fieldCongestion)
+	pd.DontFragment = hasFlag(networkFields, fieldDontFragment)
+	pd.MoreFragments = hasFlag(networkFields, fieldMoreFragments)
+	pd.IPOptions = networkFieldMap[fieldOptions]
+
+	// fields of the transport header, after PROTO
+	transportFields := getTransportFields(logFields)
+	transportFieldMap := getFieldMap(transportFields)
+	if pd.Proto == ProtoTCP {
+		pd.TCPSeq = uint32(getUint(transportFieldMap, fieldTCPSeq, 32))
+		pd.TCPAck = uint32(getUint(transportFieldMap, fieldTCPAck, 32))
+		pd.Window = uint16(getUint(transportFieldMap, fieldWindow, 16))
+		pd.Reserved = uint8(getUint(transportFieldMap, fieldReserved, 8))
+		pd.UrgentPointer = uint16(getUint(transportFieldMap, fieldUrgentPointer, 16))
+		pd.TCPFlags = getTCPFlags(transportFields)
+		pd.TCPOptions = transportFieldMap[fieldOptions]
+	} else if !pd.IsIcmp() {
+		// UDP logs the length of its own header and payload
+		pd.TransportLength = uint16(getUint(transportFieldMap, fieldLength, 16))
+	}
+	return err
+}
+
+// Helper function to get the fields logged from the SRC field to the PROTO field, which belong to the network header
+func getNetworkFields(logFields []string) []string {
+	start, end := -1, -1
+	for i, field := range logFields {
+		if start < 0 && strings.HasPrefix(field, fieldSrcIP+"=") {
+			start = i
+		}
+		if strings.HasPrefix(field, fieldProto+"=") {
+			end = i
+			break
+		}
+	}
+	if start < 0 || end < start {
+		return []string{}
+	}
+	return logFields[start:end]
+}
 
+// Helper function to get the fields logged after the PROTO field, which belong to the transport header
+func getTransportFields(logFields []string) []string {
+	for i, field := range logFields {
+		if strings.HasPrefix(field, fieldProto+"=") {
+			return logFields[i+1:]
 		}
 	}
-	return "", errors.New(fmt.Sprintf("Missing field=%+v", fieldName))
+	return []string{}
+}
+
+// Helper function to get the original packet from the fields embedded in ICMP errors
+func getEmbeddedPacket(embeddedFields []string) (EmbeddedPacket, error) {
+	var embedded EmbeddedPacket
+	var err error
+	embeddedFieldMap := getFieldMap(embeddedFields)
+	if embedded.SrcIP, err = getIPField(embeddedFieldMap, fieldSrcIP); err != nil {
+		return EmbeddedPacket{}, err
+	}
+	if embedded.DstIP, err = getIPField(embeddedFieldMap, fieldDstIP); err != nil {
+		return EmbeddedPacket{}, err
+	}
+	if embedded.Proto, err = getProtocolField(embeddedFieldMap, fieldProto); err != nil {
+		return EmbeddedPacket{}, err
+	}
+	srcPort, err := getUintField(embeddedFieldMap, fieldSrcPort, 16)
+	if err != nil {
+		return EmbeddedPacket{}, err
+	}
+	dstPort, err := getUintField(embeddedFieldMap, fieldDstPort, 16)
+	if err != nil {
+		return EmbeddedPacket{}, err
+	}
+	embedded.SrcPort, embedded.DstPort = uint16(srcPort), uint16(dstPort)
+	return embedded, nil
+}
+
+// Helper function to get the IP family of given IP address, return empty string if it's not a valid IP
+func getFamily(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	if ip.To4() != nil {
+		return FamilyIPv4
+	}
+	return FamilyIPv6
+}
+
+// Helper function to set the ICMP fields of given PacketDrop from the fields logged after its PROTO field. The ICMP
+// header isn't logged for the fragments after the first one.
+func setIcmpFields(pd *PacketDrop, transportFieldMap map[string]string) error {
+	if _, ok := transportFieldMap[fieldIcmpType]; !ok {
+		return nil
+	}
+	icmpType, err := getUintField(transportFieldMap, fieldIcmpType, 8)
+	if err != nil {
+		return err
+	}
+	icmpCode, err := getUintField(transportFieldMap, fieldIcmpCode, 8)
+	if err != nil {
+		return err
+	}
+	icmpID, err := getUintField(transportFieldMap, fieldIcmpID, 16)
+	if err != nil {
+		return err
+	}
+	icmpSeq, err := getUintField(transportFieldMap, fieldIcmpSeq, 16)
+	if err != nil {
+		return err
+	}
+	mtu, err := getUintField(transportFieldMap, fieldMtu, 32)
+	if err != nil {
+		return err
+	}
+	typeValue := uint8(icmpType)
+	pd.IcmpType = &typeValue
+	pd.IcmpCode, pd.IcmpID, pd.IcmpSeq, pd.Mtu = uint8(icmpCode), uint16(icmpID), uint16(icmpSeq), uint32(mtu)
+	return nil
 }
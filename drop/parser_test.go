@@ -1,7 +1,10 @@
 package drop
 
 import (
+	"errors"
 	"fmt"
+	"net"
+	"reflect"
 	"testing"
 	"time"
 
@@ -12,16 +15,42 @@ const (
 	testHostname          = "hostname"
 	testLogPrefix         = "log-prefix"
 	testSrcIP             = "11.111.11.111"
-	testSrcPort           = "56789"
+	testSrcPort           = 56789
 	testDstIP             = "22.222.22.222"
-	testDstPort           = "1234"
+	testDstPort           = 1234
 	testProto             = "TCP"
 	testInterfaceReceived = "eth0"
 	testInterfaceSent     = "eth1"
 	testMacAddress        = "56:22:aa:30:c4:fe:c6:ba:6e:31:56:c9:08:00"
-	testPacketTtl         = "63"
+	testPacketTtl         = 63
 )
 
+// log prefixes matching testLogPrefix
+var testLogPrefixes = getTestLogPrefixes(testLogPrefix)
+
+// log format of the logs written with util.DefaultPacketDropLogTimeLayout
+var testLogFormat, _ = InitLogFormat(LogFormatDefault, "", nil)
+
+// Helper function to get the pointer of given UID or GID
+func uint32Pointer(value uint32) *uint32 {
+	return &value
+}
+
+// Helper function to get the pointer of given ICMP type
+func uint8Pointer(value uint8) *uint8 {
+	return &value
+}
+
+// Helper function to get the log prefixes matching given exact prefixes
+func getTestLogPrefixes(prefixes ...string) LogPrefixes {
+	var logPrefixes LogPrefixes
+	for _, prefix := range prefixes {
+		logPrefix, _ := InitLogPrefix(prefix)
+		logPrefixes = append(logPrefixes, logPrefix)
+	}
+	return logPrefixes
+}
+
 // Test if PacketDrop.IsExpired() works
 func TestPacketDropIsExpired(t *testing.T) {
 	expiredTime := util.GetExpiredTimeIn(util.DefaultPacketDropExpirationMinutes)
@@ -44,27 +73,29 @@ func TestParsingDropLog(t *testing.T) {
 	// need to use curTime because parse() will not insert expired packetDrop
 	curTime := time.Now().Truncate(time.Second)
 	logTime := curTime.Format(util.DefaultPacketDropLogTimeLayout)
-	testLog := fmt.Sprintf("%s %s %s SRC=%s SPT=%s DST=%s DPT=%s PROTO=%s IN=%s OUT=%s MAC=%s TTL=%s", logTime, testHostname, testLogPrefix, testSrcIP, testSrcPort, testDstIP, testDstPort, testProto, testInterfaceReceived, testInterfaceSent, testMacAddress, testPacketTtl)
+	testLog := fmt.Sprintf("%s %s %s SRC=%s SPT=%d DST=%s DPT=%d PROTO=%s IN=%s OUT=%s MAC=%s TTL=%d", logTime, testHostname, testLogPrefix, testSrcIP, testSrcPort, testDstIP, testDstPort, testProto, testInterfaceReceived, testInterfaceSent, testMacAddress, testPacketTtl)
 	expected := PacketDrop{
 		LogTime:           curTime,
 		HostName:          testHostname,
-		SrcIP:             testSrcIP,
+		LogPrefix:         testLogPrefixes[0],
+		Family:            FamilyIPv4,
+		SrcIP:             net.ParseIP(testSrcIP),
 		SrcPort:           testSrcPort,
-		DstIP:             testDstIP,
+		DstIP:             net.ParseIP(testDstIP),
 		DstPort:           testDstPort,
-		Proto:             testProto,
+		Proto:             ProtoTCP,
 		InterfaceReceived: testInterfaceReceived,
 		InterfaceSent:     testInterfaceSent,
 		MacAddress:        testMacAddress,
 		Ttl:               testPacketTtl,
 	}
-	err := parse(testLogPrefix, testLog, channel, util.DefaultPacketDropLogTimeLayout)
+	err := parse(testLogPrefixes, testLogFormat, Record{Message: testLog}, channel)
 	if err != nil {
 		t.Fatalf("Expected %+v, but got error %s", expected, err)
 	}
 
 	result := <-channel
-	if result != expected {
+	if !reflect.DeepEqual(result, expected) {
 		t.Fatalf("Expected %+v, but got result %+v", expected, result)
 	}
 }
@@ -75,7 +106,7 @@ func TestParsingExpiredPacketDropLog(t *testing.T) {
 	expiredTime := util.GetExpiredTimeIn(util.DefaultPacketDropExpirationMinutes).Format(util.DefaultPacketDropLogTimeLayout)
 	expiredLog := fmt.Sprintf("%s %s %s SRC=%s DST=%s",
 		expiredTime, testHostname, testLogPrefix, testSrcIP, testDstIP)
-	parse(testLogPrefix, expiredLog, channel, util.DefaultPacketDropLogTimeLayout)
+	parse(testLogPrefixes, testLogFormat, Record{Message: expiredLog}, channel)
 
 	select {
 	case result := <-channel:
@@ -91,13 +122,13 @@ func TestParsingBadPacketDropLog(t *testing.T) {
 	// testing bad log without source IP
 	curTime := time.Now().Format(util.DefaultPacketDropLogTimeLayout)
 	testLog1 := fmt.Sprintf("%s %s %s %s", curTime, testHostname, testLogPrefix, testDstIP)
-	err := parse(testLogPrefix, testLog1, channel, util.DefaultPacketDropLogTimeLayout)
+	err := parse(testLogPrefixes, testLogFormat, Record{Message: testLog1}, channel)
 	if err == nil {
 		t.Fatalf("Expected error, but got error nil!")
 	}
 	// testing bad log without destination IP
 	testLog2 := fmt.Sprintf("%s %s %s %s", curTime, testHostname, testLogPrefix, testSrcIP)
-	err = parse(testLogPrefix, testLog2, channel, util.DefaultPacketDropLogTimeLayout)
+	err = parse(testLogPrefixes, testLogFormat, Record{Message: testLog2}, channel)
 	if err == nil {
 		t.Fatalf("Expected error, but got error nil!")
 	}
@@ -108,7 +139,7 @@ func TestParsingNonePacketDropLog(t *testing.T) {
 	channel := make(chan PacketDrop)
 	curTime := time.Now().Format(util.DefaultPacketDropLogTimeLayout)
 	testLog := fmt.Sprintf("%s %s None Packet Drop Log", curTime, testHostname)
-	err := parse(testLogPrefix, testLog, channel, util.DefaultPacketDropLogTimeLayout)
+	err := parse(testLogPrefixes, testLogFormat, Record{Message: testLog}, channel)
 
 	if err != nil {
 		t.Fatalf("Expected error nil, but got error %s", err)
@@ -123,11 +154,448 @@ func TestGetPacketDropLogFields(t *testing.T) {
 		t.Fatalf("Expected error from empty log, but got nil")
 	}
 
-	curTime := time.Now().Format(util.DefaultPacketDropLogTimeLayout)
 	// missing IPs
-	packetDropLogMissingField := fmt.Sprintf("%s %s ", curTime, testHostname)
+	packetDropLogMissingField := fmt.Sprintf("%s ", testLogPrefix)
 	_, err = getPacketDropLogFields(packetDropLogMissingField)
 	if err == nil {
 		t.Fatalf("Expected error from log %s, but got nil", packetDropLogMissingField)
 	}
 }
+
+// Test if packet parser works for the logs written by nftables
+func TestParsingNftablesDropLog(t *testing.T) {
+	curTime := time.Now().Truncate(time.Second)
+	logTime := curTime.Format(util.DefaultPacketDropLogTimeLayout)
+	// lines written by the kernel for "log prefix ... flags all" rules
+	testCases := []struct {
+		prefix   string
+		log      string
+		expected PacketDrop
+	}{
+		{
+			// output hook of an inet table, UID and GID of the socket are logged by "flags skuid"
+			prefix: "nft-drop:",
+			log: "kernel: nft-drop: IN= OUT=lo SRC=127.0.0.1 DST=127.0.0.1 LEN=60 TOS=0x00 PREC=0x00 TTL=64 " +
+				"ID=39224 DF PROTO=TCP SPT=55132 DPT=9 SEQ=4088876828 ACK=0 WINDOW=65495 RES=0x00 SYN URGP=0 " +
+				"OPT (0204FFD70402080A1CCE2693000000000103030A) UID=1000 GID=1000",
+			expected: PacketDrop{Family: FamilyIPv4, SrcIP: net.ParseIP("127.0.0.1"), SrcPort: 55132,
+				DstIP: net.ParseIP("127.0.0.1"), DstPort: 9, Proto: ProtoTCP, InterfaceSent: "lo", Ttl: 64,
+				Uid: uint32Pointer(1000), Gid: uint32Pointer(1000), Length: 60, IPID: 39224, DontFragment: true,
+				TCPSeq: 4088876828, Window: 65495, TCPFlags: TCPFlagSYN,
+				TCPOptions: "0204FFD70402080A1CCE2693000000000103030A"},
+		},
+		{
+			// input hook of an inet table
+			prefix: "nft-drop:",
+			log: "kernel: nft-drop: IN=lo OUT= MAC=00:00:00:00:00:00:00:00:00:00:00:00:08:00 SRC=127.0.0.1 " +
+				"DST=127.0.0.1 LEN=40 TOS=0x00 PREC=0x00 TTL=64 ID=0 DF PROTO=TCP SPT=9 DPT=55132 SEQ=0 " +
+				"ACK=4088876829 WINDOW=0 RES=0x00 ACK RST URGP=0",
+			expected: PacketDrop{Family: FamilyIPv4, SrcIP: net.ParseIP("127.0.0.1"), SrcPort: 9,
+				DstIP: net.ParseIP("127.0.0.1"), DstPort: 55132, Proto: ProtoTCP, InterfaceReceived: "lo",
+				MacAddress: "00:00:00:00:00:00:00:00:00:00:00:00:08:00", Ttl: 64, Length: 40, DontFragment: true,
+				TCPAck: 4088876829, TCPFlags: TCPFlagACK | TCPFlagRST},
+		},
+		{
+			// output hook of an inet table, ICMP error carrying the original UDP header
+			prefix: "nft-drop:",
+			log: "kernel: nft-drop: IN= OUT=lo SRC=127.0.0.1 DST=127.0.0.1 LEN=61 TOS=0x00 PREC=0xC0 TTL=64 " +
+				"ID=47285 PROTO=ICMP TYPE=3 CODE=3 [SRC=127.0.0.1 DST=127.0.0.1 LEN=33 TOS=0x00 PREC=0x00 TTL=64 " +
+				"ID=55366 DF PROTO=UDP SPT=48289 DPT=53 LEN=13 ]",
+			expected: PacketDrop{Family: FamilyIPv4, SrcIP: net.ParseIP("127.0.0.1"), DstIP: net.ParseIP("127.0.0.1"),
+				Proto: ProtoICMP, InterfaceSent: "lo", Ttl: 64, IcmpType: uint8Pointer(3), IcmpCode: 3,
+				Embedded: EmbeddedPacket{SrcIP: net.ParseIP("127.0.0.1"), SrcPort: 48289,
+					DstIP: net.ParseIP("127.0.0.1"), DstPort: 53, Proto: ProtoUDP},
+				Length: 61, Precedence: 0xC0, IPID: 47285},
+		},
+		{
+			// output hook of an inet table, IPv6
+			prefix: "nft-drop:",
+			log: "kernel: nft-drop: IN= OUT=lo SRC=0000:0000:0000:0000:0000:0000:0000:0001 " +
+				"DST=0000:0000:0000:0000:0000:0000:0000:0001 LEN=80 TC=0 HOPLIMIT=64 FLOWLBL=27908 PROTO=TCP " +
+				"SPT=35420 DPT=9 SEQ=1128904025 ACK=0 WINDOW=65476 RES=0x00 SYN URGP=0 " +
+				"OPT (0204FFC40402080A8B298BD5000000000103030A) UID=1000 GID=1000",
+			expected: PacketDrop{Family: FamilyIPv6, SrcIP: net.ParseIP("::1"), SrcPort: 35420,
+				DstIP: net.ParseIP("::1"), DstPort: 9, Proto: ProtoTCP, InterfaceSent: "lo", Ttl: 64,
+				Uid: uint32Pointer(1000), Gid: uint32Pointer(1000), FlowLabel: 27908, Length: 80,
+				TCPSeq: 1128904025, Window: 65476, TCPFlags: TCPFlagSYN,
+				TCPOptions: "0204FFC40402080A8B298BD5000000000103030A"},
+		},
+		{
+			// filter-output chain of the "ip kube-proxy" table of kube-proxy in nftables mode
+			prefix: "kube-proxy-drop:",
+			log: "kernel: kube-proxy-drop: IN= OUT=lo SRC=127.0.0.1 DST=127.0.0.1 LEN=33 TOS=0x00 PREC=0x00 " +
+				"TTL=64 ID=55366 DF PROTO=UDP SPT=48289 DPT=53 LEN=13 UID=1000 GID=1000",
+			expected: PacketDrop{Family: FamilyIPv4, SrcIP: net.ParseIP("127.0.0.1"), SrcPort: 48289,
+				DstIP: net.ParseIP("127.0.0.1"), DstPort: 53, Proto: ProtoUDP, InterfaceSent: "lo", Ttl: 64,
+				Uid: uint32Pointer(1000), Gid: uint32Pointer(1000), Length: 33, IPID: 55366, DontFragment: true,
+				TransportLength: 13},
+		},
+		{
+			// ingress hook of a netdev table, which logs the link layer header in separate fields
+			prefix: "nft-netdev:",
+			log: "kernel: nft-netdev: IN=vA OUT= MACSRC=22:60:30:3a:c8:92 MACDST=ea:bb:45:09:77:58 MACPROTO=0800 " +
+				"SRC=10.99.0.2 DST=10.99.0.3 LEN=60 TOS=0x00 PREC=0x00 TTL=64 ID=8128 DF PROTO=TCP SPT=45228 " +
+				"DPT=9 SEQ=2098534125 ACK=0 WINDOW=64240 RES=0x00 SYN URGP=0 " +
+				"OPT (020405B40402080AD52A6B09000000000103030A)",
+			expected: PacketDrop{Family: FamilyIPv4, SrcIP: net.ParseIP("10.99.0.2"), SrcPort: 45228,
+				DstIP: net.ParseIP("10.99.0.3"), DstPort: 9, Proto: ProtoTCP, InterfaceReceived: "vA",
+				MacAddress: "ea:bb:45:09:77:58:22:60:30:3a:c8:92:08:00", SrcMacAddress: "22:60:30:3a:c8:92",
+				DstMacAddress: "ea:bb:45:09:77:58", EtherType: EtherTypeIPv4, Ttl: 64, Length: 60, IPID: 8128,
+				DontFragment: true, TCPSeq: 2098534125, Window: 64240, TCPFlags: TCPFlagSYN,
+				TCPOptions: "020405B40402080AD52A6B09000000000103030A"},
+		},
+		{
+			// forward hook of a bridge table
+			prefix: "br-drop:",
+			log: "kernel: br-drop: IN=vA OUT=vB MACSRC=22:60:30:3a:c8:92 MACDST=ea:bb:45:09:77:58 MACPROTO=0800 " +
+				"SRC=10.99.0.2 DST=10.99.0.3 LEN=28 TOS=0x00 PREC=0x00 TTL=64 ID=58915 DF PROTO=ICMP TYPE=8 " +
+				"CODE=0 ID=7 SEQ=0",
+			expected: PacketDrop{Family: FamilyIPv4, SrcIP: net.ParseIP("10.99.0.2"), DstIP: net.ParseIP("10.99.0.3"),
+				Proto: ProtoICMP, InterfaceReceived: "vA", InterfaceSent: "vB",
+				MacAddress: "ea:bb:45:09:77:58:22:60:30:3a:c8:92:08:00", SrcMacAddress: "22:60:30:3a:c8:92",
+				DstMacAddress: "ea:bb:45:09:77:58", EtherType: EtherTypeIPv4, Ttl: 64, IcmpType: uint8Pointer(8),
+				IcmpID: 7, Length: 28, IPID: 58915, DontFragment: true},
+		},
+		{
+			// forward hook of a bridge table, ARP request
+			prefix: "br-drop:",
+			log: "kernel: br-drop: IN=vA OUT=vB MACSRC=22:60:30:3a:c8:92 MACDST=ff:ff:ff:ff:ff:ff MACPROTO=0806 " +
+				"ARP HTYPE=1 PTYPE=0x0800 OPCODE=1 MACSRC=22:60:30:3a:c8:92 IPSRC=10.99.0.2 " +
+				"MACDST=00:00:00:00:00:00 IPDST=10.99.0.3",
+			expected: PacketDrop{Family: FamilyIPv4, SrcIP: net.ParseIP("10.99.0.2"), DstIP: net.ParseIP("10.99.0.3"),
+				InterfaceReceived: "vA", InterfaceSent: "vB",
+				MacAddress: "ff:ff:ff:ff:ff:ff:22:60:30:3a:c8:92:08:06", SrcMacAddress: "22:60:30:3a:c8:92",
+				DstMacAddress: "ff:ff:ff:ff:ff:ff", EtherType: EtherTypeARP, ArpOpcode: 1},
+		},
+	}
+
+	for _, testCase := range testCases {
+		channel := make(chan PacketDrop, 1)
+		logPrefixes := getTestLogPrefixes(testCase.prefix)
+		testLog := fmt.Sprintf("%s %s %s", logTime, testHostname, testCase.log)
+		err := parse(logPrefixes, testLogFormat, Record{Message: testLog}, channel)
+		if err != nil {
+			t.Fatalf("Expected %+v, but got error %s", testCase.expected, err)
+		}
+
+		expected := testCase.expected
+		expected.LogTime = curTime
+		expected.HostName = testHostname
+		expected.LogPrefix = logPrefixes[0]
+		result := <-channel
+		if !reflect.DeepEqual(result, expected) {
+			t.Fatalf("Expected %+v, but got result %+v", expected, result)
+		}
+	}
+}
+
+// Test if packet parser works for the logs written by ip6tables
+func TestParsingIPv6DropLog(t *testing.T) {
+	channel := make(chan PacketDrop, 1)
+	curTime := time.Now().Truncate(time.Second)
+	logTime := curTime.Format(util.DefaultPacketDropLogTimeLayout)
+	testLog := fmt.Sprintf("%s %s %s IN=%s OUT=%s MAC=%s "+
+		"SRC=fd00:0010:0244:0001:0000:0000:0000:0005 DST=fd00:0010:0244:0002:0000:0000:0000:0007 LEN=80 TC=0 "+
+		"HOPLIMIT=63 FLOWLBL=527432 PROTO=TCP SPT=%d DPT=%d WINDOW=64800 RES=0x00 SYN URGP=0",
+		logTime, testHostname, testLogPrefix, testInterfaceReceived, testInterfaceSent, testMacAddress, testSrcPort,
+		testDstPort)
+	expected := PacketDrop{
+		LogTime:           curTime,
+		HostName:          testHostname,
+		LogPrefix:         testLogPrefixes[0],
+		Family:            FamilyIPv6,
+		SrcIP:             net.ParseIP("fd00:10:244:1::5"),
+		SrcPort:           testSrcPort,
+		DstIP:             net.ParseIP("fd00:10:244:2::7"),
+		DstPort:           testDstPort,
+		Proto:             ProtoTCP,
+		InterfaceReceived: testInterfaceReceived,
+		InterfaceSent:     testInterfaceSent,
+		MacAddress:        testMacAddress,
+		Ttl:               63,
+		TrafficClass:      0,
+		FlowLabel:         527432,
+		Length:            80,
+		Window:            64800,
+		TCPFlags:          TCPFlagSYN,
+	}
+	err := parse(testLogPrefixes, testLogFormat, Record{Message: testLog}, channel)
+	if err != nil {
+		t.Fatalf("Expected %+v, but got error %s", expected, err)
+	}
+
+	result := <-channel
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("Expected %+v, but got result %+v", expected, result)
+	}
+}
+
+// Test if packet parser works for the protocols without ports
+func TestParsingDropLogWithoutPorts(t *testing.T) {
+	curTime := time.Now().Truncate(time.Second)
+	logTime := curTime.Format(util.DefaultPacketDropLogTimeLayout)
+	testCases := []struct {
+		log      string
+		expected PacketDrop
+	}{
+		{
+			// ping
+			log: "IN=eth0 OUT=eth1 SRC=10.244.1.5 DST=10.244.2.7 LEN=84 TOS=0x00 PREC=0x00 TTL=63 ID=41000 DF " +
+				"PROTO=ICMP TYPE=8 CODE=0 ID=7 SEQ=1",
+			expected: PacketDrop{Family: FamilyIPv4, SrcIP: net.ParseIP("10.244.1.5"), DstIP: net.ParseIP("10.244.2.7"), Proto: ProtoICMP,
+				InterfaceReceived: "eth0", InterfaceSent: "eth1", Ttl: 63, IcmpType: uint8Pointer(8),
+				IcmpID: 7, IcmpSeq: 1, Length: 84, IPID: 41000, DontFragment: true},
+		},
+		{
+			// PMTU message carrying the original TCP header, "[" of kernel time stamp is not an embedded header
+			log: "[ 8123.456789] IN=eth0 OUT=eth1 SRC=192.168.10.1 DST=10.244.2.7 LEN=576 TOS=0x00 PREC=0xC0 " +
+				"TTL=63 ID=1919 PROTO=ICMP TYPE=3 CODE=4 [SRC=10.244.2.7 DST=192.168.10.4 LEN=1500 TOS=0x00 " +
+				"PREC=0x00 TTL=62 ID=0 DF PROTO=TCP SPT=51234 DPT=443 WINDOW=501 RES=0x00 ACK PSH URGP=0 ] MTU=1400",
+			expected: PacketDrop{Family: FamilyIPv4, SrcIP: net.ParseIP("192.168.10.1"), DstIP: net.ParseIP("10.244.2.7"), Proto: ProtoICMP,
+				InterfaceReceived: "eth0", InterfaceSent: "eth1", Ttl: 63, IcmpType: uint8Pointer(3), IcmpCode: 4,
+				Mtu: 1400, Embedded: EmbeddedPacket{SrcIP: net.ParseIP("10.244.2.7"), SrcPort: 51234,
+					DstIP: net.ParseIP("192.168.10.4"), DstPort: 443, Proto: ProtoTCP}, Length: 576, Precedence: 0xC0, IPID: 1919},
+		},
+		{
+			// port unreachable carrying the original UDP header
+			log: "IN=eth0 OUT= SRC=10.244.2.7 DST=10.244.1.5 LEN=88 TOS=0x00 PREC=0xC0 TTL=64 ID=51000 " +
+				"PROTO=ICMP TYPE=3 CODE=3 [SRC=10.244.1.5 DST=10.244.2.7 LEN=60 TOS=0x00 PREC=0x00 TTL=63 ID=5 " +
+				"PROTO=UDP SPT=40000 DPT=53 LEN=40 ]",
+			expected: PacketDrop{Family: FamilyIPv4, SrcIP: net.ParseIP("10.244.2.7"), DstIP: net.ParseIP("10.244.1.5"), Proto: ProtoICMP,
+				InterfaceReceived: "eth0", Ttl: 64, IcmpType: uint8Pointer(3), IcmpCode: 3,
+				Embedded: EmbeddedPacket{SrcIP: net.ParseIP("10.244.1.5"), SrcPort: 40000, DstIP: net.ParseIP("10.244.2.7"),
+					DstPort: 53, Proto: ProtoUDP}, Length: 88, Precedence: 0xC0, IPID: 51000},
+		},
+		{
+			// ping6
+			log: "IN=eth0 OUT=eth1 SRC=fd00:0010:0244:0001:0000:0000:0000:0005 " +
+				"DST=fd00:0010:0244:0002:0000:0000:0000:0007 LEN=104 TC=0 HOPLIMIT=63 FLOWLBL=91063 " +
+				"PROTO=ICMPv6 TYPE=128 CODE=0 ID=12 SEQ=3",
+			expected: PacketDrop{Family: FamilyIPv6, SrcIP: net.ParseIP("fd00:10:244:1::5"), DstIP: net.ParseIP("fd00:10:244:2::7"),
+				Proto: ProtoICMPv6, InterfaceReceived: "eth0", InterfaceSent: "eth1", Ttl: 63, TrafficClass: 0,
+				FlowLabel: 91063, IcmpType: uint8Pointer(128), IcmpID: 12, IcmpSeq: 3, Length: 104},
+		},
+		{
+			// GRE
+			log: "IN=eth0 OUT=eth1 SRC=10.0.0.1 DST=10.0.0.2 LEN=100 TOS=0x00 PREC=0x00 TTL=64 ID=0 DF PROTO=47",
+			expected: PacketDrop{Family: FamilyIPv4, SrcIP: net.ParseIP("10.0.0.1"), DstIP: net.ParseIP("10.0.0.2"), Proto: ProtoGRE,
+				InterfaceReceived: "eth0", InterfaceSent: "eth1", Ttl: 64, Length: 100, DontFragment: true},
+		},
+		{
+			// ESP
+			log: "IN=eth0 OUT= SRC=10.0.0.1 DST=10.0.0.2 LEN=152 TOS=0x00 PREC=0x00 TTL=64 ID=0 DF PROTO=ESP " +
+				"SPI=0xc1a0b2d3",
+			expected: PacketDrop{Family: FamilyIPv4, SrcIP: net.ParseIP("10.0.0.1"), DstIP: net.ParseIP("10.0.0.2"), Proto: ProtoESP,
+				InterfaceReceived: "eth0", Ttl: 64, Length: 152, DontFragment: true},
+		},
+		{
+			// UDP fragment after the first one, which has no transport header
+			log: "IN=eth0 OUT= SRC=10.0.0.1 DST=10.0.0.2 LEN=548 TOS=0x00 PREC=0x00 TTL=64 ID=4321 FRAG:185 PROTO=UDP",
+			expected: PacketDrop{Family: FamilyIPv4, SrcIP: net.ParseIP("10.0.0.1"), DstIP: net.ParseIP("10.0.0.2"), Proto: ProtoUDP,
+				InterfaceReceived: "eth0", Ttl: 64, Length: 548, IPID: 4321, FragmentOffset: 185},
+		},
+	}
+
+	for _, testCase := range testCases {
+		channel := make(chan PacketDrop, 1)
+		testLog := fmt.Sprintf("%s %s %s %s", logTime, testHostname, testLogPrefix, testCase.log)
+		err := parse(testLogPrefixes, testLogFormat, Record{Message: testLog}, channel)
+		if err != nil {
+			t.Fatalf("Expected %+v, but got error %s", testCase.expected, err)
+		}
+
+		expected := testCase.expected
+		expected.LogTime = curTime
+		expected.HostName = testHostname
+		expected.LogPrefix = testLogPrefixes[0]
+		result := <-channel
+		if !reflect.DeepEqual(result, expected) {
+			t.Fatalf("Expected %+v, but got result %+v", expected, result)
+		}
+	}
+}
+
+// Test if packet parser gets all the header fields logged by iptables LOG
+func TestParsingDropLogHeaderFields(t *testing.T) {
+	channel := make(chan PacketDrop, 1)
+	curTime := time.Now().Truncate(time.Second)
+	logTime := curTime.Format(util.DefaultPacketDropLogTimeLayout)
+	testLog := fmt.Sprintf("%s %s %s IN=%s OUT=%s PHYSIN=veth1234 PHYSOUT=veth5678 MAC=%s SRC=%s DST=%s "+
+		"LEN=64 TOS=0x10 PREC=0x20 TTL=%d ID=54321 CE DF MF FRAG:0 OPT (94040000) PROTO=%s SPT=%d DPT=%d "+
+		"SEQ=3735928559 ACK=0 WINDOW=64240 RES=0x00 CWR ECE SYN URGP=0 OPT (020405B40402080A) UID=1000 GID=1000 "+
+		"MARK=0x4000",
+		logTime, testHostname, testLogPrefix, testInterfaceReceived, testInterfaceSent, testMacAddress, testSrcIP,
+		testDstIP, testPacketTtl, testProto, testSrcPort, testDstPort)
+	expected := PacketDrop{
+		LogTime:               curTime,
+		HostName:              testHostname,
+		LogPrefix:             testLogPrefixes[0],
+		Family:                FamilyIPv4,
+		SrcIP:                 net.ParseIP(testSrcIP),
+		SrcPort:               testSrcPort,
+		DstIP:                 net.ParseIP(testDstIP),
+		DstPort:               testDstPort,
+		Proto:                 ProtoTCP,
+		InterfaceReceived:     testInterfaceReceived,
+		InterfaceSent:         testInterfaceSent,
+		MacAddress:            testMacAddress,
+		Ttl:                   testPacketTtl,
+		Uid:                   uint32Pointer(1000),
+		Gid:                   uint32Pointer(1000),
+		Length:                64,
+		Tos:                   0x10,
+		Precedence:            0x20,
+		IPID:                  54321,
+		Congestion:            true,
+		DontFragment:          true,
+		MoreFragments:         true,
+		IPOptions:             "94040000",
+		TCPSeq:                3735928559, // "ACK=0" is the acknowledgment number, not the ACK flag
+		Window:                64240,
+		TCPFlags:              TCPFlagCWR | TCPFlagECE | TCPFlagSYN,
+		TCPOptions:            "020405B40402080A",
+		Mark:                  0x4000,
+		PhysInterfaceReceived: "veth1234",
+		PhysInterfaceSent:     "veth5678",
+	}
+	err := parse(testLogPrefixes, testLogFormat, Record{Message: testLog}, channel)
+	if err != nil {
+		t.Fatalf("Expected %+v, but got error %s", expected, err)
+	}
+
+	result := <-channel
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("Expected %+v, but got result %+v", expected, result)
+	}
+}
+
+// Test if packet parser returns error for header fields with invalid values
+func TestParsingDropLogBadHeaderFields(t *testing.T) {
+	curTime := time.Now().Truncate(time.Second)
+	logTime := curTime.Format(util.DefaultPacketDropLogTimeLayout)
+	badFields := []string{"LEN=70000", "TOS=0xZZ", "ID=-1", "MARK=mark"}
+	for _, badField := range badFields {
+		channel := make(chan PacketDrop, 1)
+		testLog := fmt.Sprintf("%s %s %s IN=%s OUT= SRC=%s DST=%s TTL=%d %s PROTO=%s SPT=%d DPT=%d",
+			logTime, testHostname, testLogPrefix, testInterfaceReceived, testSrcIP, testDstIP, testPacketTtl,
+			badField, testProto, testSrcPort, testDstPort)
+		if err := parse(testLogPrefixes, testLogFormat, Record{Message: testLog}, channel); err == nil {
+			t.Fatalf("Expected error from field %s, but got nil", badField)
+		}
+	}
+}
+
+// Test if packet parser returns ParseError naming the field which is missing or has an invalid value
+func TestParsingDropLogFieldErrors(t *testing.T) {
+	curTime := time.Now().Truncate(time.Second)
+	logTime := curTime.Format(util.DefaultPacketDropLogTimeLayout)
+	testCases := []struct {
+		log     string
+		field   string
+		missing bool
+	}{
+		{log: "IN=eth0 OUT= DST=10.0.0.2 TTL=64 PROTO=TCP SPT=1 DPT=2", field: fieldSrcIP, missing: true},
+		{log: "IN=eth0 OUT= SRC=10.0.0 DST=10.0.0.2 TTL=64 PROTO=TCP SPT=1 DPT=2", field: fieldSrcIP},
+		{log: "IN=eth0 OUT= SRC=10.0.0.1 DST=10.0.0.2 TTL=64 PROTO=TCP SPT=1 DPT=65536", field: fieldDstPort},
+		{log: "IN=eth0 OUT= SRC=10.0.0.1 DST=10.0.0.2 TTL=256 PROTO=TCP SPT=1 DPT=2", field: fieldTtl},
+		{log: "IN=eth0 OUT= SRC=10.0.0.1 DST=10.0.0.2 TTL=64 PROTO=TCP", field: fieldSrcPort, missing: true},
+		{log: "IN=eth0 OUT= SRC=10.0.0.1 DST=10.0.0.2 TTL=64 PROTO=UDP SPT=1", field: fieldDstPort, missing: true},
+		{log: "IN=eth0 OUT= SRC=10.0.0.1 DST=10.0.0.2 TTL=64 PROTO=FOO", field: fieldProto},
+		// INVALID is not the IN field
+		{log: "INVALID=1 OUT= SRC=10.0.0.1 DST=10.0.0.2 TTL=64 PROTO=TCP SPT=1 DPT=2",
+			field: fieldInterfaceReceived, missing: true},
+		{log: "IN=eth0 OUT= SRC=10.0.0.1 DST=10.0.0.2 PROTO=ICMP TYPE=3 CODE=3 [SRC=10.0.0.2 DST=10.0.0.1 " +
+			"PROTO=UDP SPT=abc DPT=53 ] TTL=64", field: fieldSrcPort},
+		{log: "IN= OUT=eth0 SRC=10.0.0.1 DST=10.0.0.2 TTL=64 PROTO=TCP SPT=1 DPT=2 UID=root GID=0", field: fieldUid},
+		{log: "IN=eth0 OUT= SRC=10.0.0.1 DST=10.0.0.2 TTL=64 PROTO=ICMP TYPE=256 CODE=0", field: fieldIcmpType},
+		{log: "IN=eth0 OUT= SRC=10.0.0.1 DST=10.0.0.2 TTL=64 PROTO=ICMP TYPE=3 CODE=4 MTU=-1", field: fieldMtu},
+	}
+	for _, testCase := range testCases {
+		channel := make(chan PacketDrop, 1)
+		testLog := fmt.Sprintf("%s %s %s %s", logTime, testHostname, testLogPrefix, testCase.log)
+		err := parse(testLogPrefixes, testLogFormat, Record{Message: testLog}, channel)
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			t.Fatalf("Expected ParseError from log %s, but got error %v", testCase.log, err)
+		}
+		if parseErr.Field != testCase.field || errors.Is(err, ErrMissingField) != testCase.missing {
+			t.Fatalf("Expected field %s missing %v, but got error %v", testCase.field, testCase.missing, err)
+		}
+	}
+}
+
+// Test if packet parser matches the field names exactly
+func TestParsingDropLogFieldNames(t *testing.T) {
+	channel := make(chan PacketDrop, 1)
+	curTime := time.Now().Truncate(time.Second)
+	logTime := curTime.Format(util.DefaultPacketDropLogTimeLayout)
+	testLog := fmt.Sprintf("%s %s %s INVALID=1 IN=%s OUTPUT=1 OUT=%s SRCHOST=1 SRC=%s DST=%s TTLS=1 TTL=%d "+
+		"PROTO=UDP SPTR=1 SPT=%d DPT=%d",
+		logTime, testHostname, testLogPrefix, testInterfaceReceived, testInterfaceSent, testSrcIP, testDstIP,
+		testPacketTtl, testSrcPort, testDstPort)
+	expected := PacketDrop{
+		LogTime:           curTime,
+		HostName:          testHostname,
+		LogPrefix:         testLogPrefixes[0],
+		Family:            FamilyIPv4,
+		SrcIP:             net.ParseIP(testSrcIP),
+		SrcPort:           testSrcPort,
+		DstIP:             net.ParseIP(testDstIP),
+		DstPort:           testDstPort,
+		Proto:             ProtoUDP,
+		InterfaceReceived: testInterfaceReceived,
+		InterfaceSent:     testInterfaceSent,
+		Ttl:               testPacketTtl,
+	}
+	err := parse(testLogPrefixes, testLogFormat, Record{Message: testLog}, channel)
+	if err != nil {
+		t.Fatalf("Expected %+v, but got error %s", expected, err)
+	}
+
+	result := <-channel
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("Expected %+v, but got result %+v", expected, result)
+	}
+}
+
+// Test if packet parser works for the log prefixes containing spaces
+func TestParsingDropLogPrefixWithSpaces(t *testing.T) {
+	channel := make(chan PacketDrop, 1)
+	logPrefixes := getTestLogPrefixes("calico-drop:", "fw reject: ")
+	curTime := time.Now().Truncate(time.Second)
+	logTime := curTime.Format(util.DefaultPacketDropLogTimeLayout)
+	testLog := fmt.Sprintf("%s %s fw reject: IN=%s OUT= SRC=%s DST=%s TTL=%d PROTO=UDP SPT=%d DPT=%d",
+		logTime, testHostname, testInterfaceReceived, testSrcIP, testDstIP, testPacketTtl, testSrcPort, testDstPort)
+	err := parse(logPrefixes, testLogFormat, Record{Message: testLog}, channel)
+	if err != nil {
+		t.Fatalf("Expected error nil, but got error %s", err)
+	}
+	if len(channel) != 1 {
+		t.Fatalf("Expected 1 packet drop in channel, but got %d", len(channel))
+	}
+	if result := <-channel; result.LogPrefix != logPrefixes[1] {
+		t.Fatalf("Expected log prefix %v, but got result %v", logPrefixes[1], result.LogPrefix)
+	}
+}
+
+// Test if packet parser uses the timestamp and host name of records which have them, e.g. journal entries
+func TestParsingRecordWithTime(t *testing.T) {
+	channel := make(chan PacketDrop, 1)
+	curTime := time.Now().Round(time.Microsecond)
+	record := Record{
+		Time:    curTime,
+		Host:    testHostname,
+		Message: fmt.Sprintf("%s IN=%s OUT= SRC=%s DST=%s TTL=%d PROTO=ICMP", testLogPrefix, testInterfaceReceived, testSrcIP, testDstIP, testPacketTtl),
+		Source:  "journal",
+	}
+	// the log format doesn't match the message, which would fail to parse if the record had no timestamp
+	if err := parse(testLogPrefixes, testLogFormat, record, channel); err != nil {
+		t.Fatalf("Expected error nil, but got error %s", err)
+	}
+	if len(channel) != 1 {
+		t.Fatalf("Expected 1 packet drop in channel, but got %d", len(channel))
+	}
+	if result := <-channel; !result.LogTime.Equal(curTime) || result.HostName != testHostname {
+		t.Fatalf("Expected %v %v, but got result %v %v", curTime, testHostname, result.LogTime, result.HostName)
+	}
+}
@@ -0,0 +1,229 @@
+package drop
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// event types a log prefix may use, same as the types of Kubernetes Events
+const (
+	eventTypeNormal  = "Normal"
+	eventTypeWarning = "Warning"
+)
+
+// metric labels which are always set and cannot be overridden by a log prefix
+var reservedLabelNames = map[string]bool{"src": true, "dst": true, "proto": true}
+
+// valid names of Prometheus metric labels, names starting with "__" are reserved for internal use of Prometheus
+var labelNameRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// placeholder of templates, e.g. "{policy}"
+var placeholderRegexp = regexp.MustCompile(`\{(\w+)\}`)
+
+// LogPrefix is a rule to match the prefix of packet drop logs by either the exact Prefix (which may contain spaces),
+// a regular expression Pattern, or a Template with placeholders like "np-{policy}-{chain}:". The named parts of
+// Pattern and Template can be used as "{name}" in Reason, Message and Labels, together with "{message}" which is the
+// default event message in Message. Empty Reason, EventType and Message keep their defaults.
+type LogPrefix struct {
+	Prefix    string            `json:"prefix,omitempty"`
+	Pattern   string            `json:"pattern,omitempty"`
+	Template  string            `json:"template,omitempty"`
+	Reason    string            `json:"reason,omitempty"`
+	EventType string            `json:"eventType,omitempty"`
+	Message   string            `json:"message,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+
+	regexp *regexp.Regexp
+}
+
+// LogPrefixes is the list of log prefixes to match in order, the first matching one is used
+type LogPrefixes []*LogPrefix
+
+// Init a log prefix matching given exact prefix and return its pointer
+func InitLogPrefix(prefix string) (*LogPrefix, error) {
+	logPrefix := &LogPrefix{Prefix: prefix}
+	if err := logPrefix.compile(); err != nil {
+		return nil, err
+	}
+	return logPrefix, nil
+}
+
+// Parse the log prefixes of given JSON list, where each item is either a string of exact prefix or an object of
+// LogPrefix, e.g. `["calico-drop:", {"template": "np-{policy}:", "reason": "PolicyDrop"}]`
+func ParseLogPrefixes(config string) (LogPrefixes, error) {
+	var items []json.RawMessage
+	if err := json.Unmarshal([]byte(config), &items); err != nil {
+		return nil, fmt.Errorf("Invalid log prefixes: %v", err)
+	}
+	var prefixes LogPrefixes
+	for _, item := range items {
+		logPrefix := &LogPrefix{}
+		if err := json.Unmarshal(item, &logPrefix.Prefix); err != nil {
+			if err := json.Unmarshal(item, logPrefix); err != nil {
+				return nil, fmt.Errorf("Invalid log prefix %s: %v", item, err)
+			}
+		}
+		if err := logPrefix.compile(); err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, logPrefix)
+	}
+	if len(prefixes) == 0 {
+		return nil, errors.New("Invalid log prefixes: empty list")
+	}
+	return prefixes, nil
+}
+
+// Helper function to check the fields of log prefix and compile its pattern or template
+func (prefix *LogPrefix) compile() error {
+	var pattern string
+	switch {
+	case prefix.Prefix != "" && prefix.Pattern == "" && prefix.Template == "":
+		pattern = `(?:^|\s)` + regexp.QuoteMeta(strings.TrimSpace(prefix.Prefix)) + `(?:\s|$)`
+	case prefix.Pattern != "" && prefix.Prefix == "" && prefix.Template == "":
+		pattern = prefix.Pattern
+	case prefix.Template != "" && prefix.Prefix == "" && prefix.Pattern == "":
+		pattern = getTemplatePattern(strings.TrimSpace(prefix.Template))
+	default:
+		return fmt.Errorf("Invalid log prefix %v: exactly one of prefix, pattern and template must be set", prefix)
+	}
+	compiled, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("Invalid log prefix %v: %v", prefix, err)
+	}
+	prefix.regexp = compiled
+
+	if prefix.EventType != "" && prefix.EventType != eventTypeNormal && prefix.EventType != eventTypeWarning {
+		return fmt.Errorf("Invalid log prefix %v: event type must be %s or %s", prefix, eventTypeNormal,
+			eventTypeWarning)
+	}
+	for name := range prefix.Labels {
+		if reservedLabelNames[name] || strings.HasPrefix(name, "__") {
+			return fmt.Errorf("Invalid log prefix %v: label %s is reserved", prefix, name)
+		}
+		if !labelNameRegexp.MatchString(name) {
+			return fmt.Errorf("Invalid log prefix %v: label %q is not a valid Prometheus label name", prefix, name)
+		}
+	}
+	return nil
+}
+
+// Helper function to get the regular expression of given template: the literal parts are matched as they are and
+// each placeholder matches a part of a word, e.g. "np-{policy}:" matches "np-allow-web:" with policy "allow-web"
+func getTemplatePattern(template string) string {
+	var buffer strings.Builder
+	buffer.WriteString(`(?:^|\s)`)
+	last := 0
+	for _, loc := range placeholderRegexp.FindAllStringSubmatchIndex(template, -1) {
+		buffer.WriteString(regexp.QuoteMeta(template[last:loc[0]]))
+		buffer.WriteString(fmt.Sprintf(`(?P<%s>\S+?)`, template[loc[2]:loc[3]]))
+		last = loc[1]
+	}
+	buffer.WriteString(regexp.QuoteMeta(template[last:]))
+	buffer.WriteString(`(?:\s|$)`)
+	return buffer.String()
+}
+
+// Return the prefix, pattern or template of log prefix
+func (prefix *LogPrefix) String() string {
+	switch {
+	case prefix.Pattern != "":
+		return prefix.Pattern
+	case prefix.Template != "":
+		return prefix.Template
+	default:
+		return prefix.Prefix
+	}
+}
+
+// Return the first log prefix matching given log and its named parts, return nil if none of them matches
+func (prefixes LogPrefixes) Match(log string) (*LogPrefix, map[string]string) {
+	for _, prefix := range prefixes {
+		match := prefix.regexp.FindStringSubmatch(log)
+		if match == nil {
+			continue
+		}
+		var fields map[string]string
+		for i, name := range prefix.regexp.SubexpNames() {
+			if name == "" || i >= len(match) {
+				continue
+			}
+			if fields == nil {
+				fields = make(map[string]string)
+			}
+			fields[name] = match[i]
+		}
+		return prefix, fields
+	}
+	return nil, nil
+}
+
+// Return the names of all the metric labels set by log prefixes in alphabetical order
+func (prefixes LogPrefixes) LabelNames() []string {
+	var names []string
+	found := make(map[string]bool)
+	for _, prefix := range prefixes {
+		for name := range prefix.Labels {
+			if !found[name] {
+				found[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Helper function to replace the placeholders of given template by given fields, unknown placeholders are kept
+func expandTemplate(template string, fields map[string]string) string {
+	return placeholderRegexp.ReplaceAllStringFunc(template, func(placeholder string) string {
+		if value, ok := fields[placeholder[1:len(placeholder)-1]]; ok {
+			return value
+		}
+		return placeholder
+	})
+}
+
+// Return the event reason of PacketDrop set by its log prefix, or given default reason
+func (pd PacketDrop) GetEventReason(defaultReason string) string {
+	if pd.LogPrefix == nil || pd.LogPrefix.Reason == "" {
+		return defaultReason
+	}
+	return expandTemplate(pd.LogPrefix.Reason, pd.PrefixFields)
+}
+
+// Return the event type of PacketDrop set by its log prefix, or given default event type
+func (pd PacketDrop) GetEventType(defaultType string) string {
+	if pd.LogPrefix == nil || pd.LogPrefix.EventType == "" {
+		return defaultType
+	}
+	return pd.LogPrefix.EventType
+}
+
+// Return the event message of PacketDrop set by its log prefix, or given default message
+func (pd PacketDrop) GetEventMessage(defaultMessage string) string {
+	if pd.LogPrefix == nil || pd.LogPrefix.Message == "" {
+		return defaultMessage
+	}
+	fields := map[string]string{"message": defaultMessage}
+	for name, value := range pd.PrefixFields {
+		fields[name] = value
+	}
+	return expandTemplate(pd.LogPrefix.Message, fields)
+}
+
+// Return the metric labels of PacketDrop set by its log prefix
+func (pd PacketDrop) GetMetricLabels() map[string]string {
+	labels := make(map[string]string)
+	if pd.LogPrefix == nil {
+		return labels
+	}
+	for name, value := range pd.LogPrefix.Labels {
+		labels[name] = expandTemplate(value, pd.PrefixFields)
+	}
+	return labels
+}
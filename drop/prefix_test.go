@@ -0,0 +1,116 @@
+package drop
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Test if ParseLogPrefixes() accepts both exact prefixes and objects of LogPrefix
+func TestParseLogPrefixes(t *testing.T) {
+	config := `["calico-drop:", "fw reject: ", {"template": "np-{policy}-{chain}:", "reason": "PolicyDrop"},
+		{"pattern": "egress-deny-(?P<policy>\\S+):", "eventType": "Normal"}]`
+	prefixes, err := ParseLogPrefixes(config)
+	if err != nil {
+		t.Fatalf("Expected error nil, but got error %s", err)
+	}
+	if len(prefixes) != 4 {
+		t.Fatalf("Expected 4 log prefixes, but got %d", len(prefixes))
+	}
+
+	testCases := []struct {
+		log    string
+		index  int
+		fields map[string]string
+	}{
+		{log: "kernel: calico-drop: IN=eth0", index: 0},
+		{log: "kernel: fw reject: IN=eth0", index: 1},
+		{log: "kernel: np-allow-web-INPUT: IN=eth0", index: 2,
+			fields: map[string]string{"policy": "allow", "chain": "web-INPUT"}},
+		{log: "kernel: egress-deny-db: IN=eth0", index: 3, fields: map[string]string{"policy": "db"}},
+	}
+	for _, testCase := range testCases {
+		prefix, fields := prefixes.Match(testCase.log)
+		if prefix != prefixes[testCase.index] {
+			t.Fatalf("Expected prefix %v, but got result %v", prefixes[testCase.index], prefix)
+		}
+		if !reflect.DeepEqual(fields, testCase.fields) {
+			t.Fatalf("Expected fields %v, but got result %v", testCase.fields, fields)
+		}
+	}
+
+	// prefixes only match whole words
+	for _, log := range []string{"kernel: calico-drop:x IN=eth0", "kernel: xfw reject: IN=eth0"} {
+		if prefix, _ := prefixes.Match(log); prefix != nil {
+			t.Fatalf("Expected no prefix matching %s, but got result %v", log, prefix)
+		}
+	}
+}
+
+// Test if ParseLogPrefixes() returns error for invalid log prefixes
+func TestParseBadLogPrefixes(t *testing.T) {
+	configs := []string{
+		`calico-drop:`,
+		`[]`,
+		`[{"reason": "NoPrefix"}]`,
+		`[{"prefix": "calico-drop:", "pattern": "calico-drop:"}]`,
+		`[{"pattern": "calico-(drop:"}]`,
+		`[{"prefix": "calico-drop:", "eventType": "Error"}]`,
+		`[{"prefix": "calico-drop:", "labels": {"src": "calico"}}]`,
+		`[{"prefix": "calico-drop:", "labels": {"__name__": "calico"}}]`,
+		`[{"prefix": "calico-drop:", "labels": {"network-policy": "calico"}}]`,
+		`[{"prefix": "calico-drop:", "labels": {"1st": "calico"}}]`,
+		`[{"prefix": "calico-drop:", "labels": {"": "calico"}}]`,
+	}
+	for _, config := range configs {
+		if _, err := ParseLogPrefixes(config); err == nil {
+			t.Fatalf("Expected error from config %s, but got nil", config)
+		}
+	}
+}
+
+// Test if the error of an invalid metric label names both the log prefix and the label
+func TestParseLogPrefixesBadLabelName(t *testing.T) {
+	_, err := ParseLogPrefixes(`["calico-drop:", {"template": "np-{policy}:", "labels": {"network-policy": "{policy}"}}]`)
+	if err == nil {
+		t.Fatal("Expected error from invalid label name, but got nil")
+	}
+	if !strings.Contains(err.Error(), "np-{policy}:") || !strings.Contains(err.Error(), `"network-policy"`) {
+		t.Fatalf("Expected error naming log prefix and label, but got result %v", err)
+	}
+}
+
+// Test if the log prefix of PacketDrop overrides the event reason, type, message and metric labels
+func TestPacketDropLogPrefixOverrides(t *testing.T) {
+	prefixes, err := ParseLogPrefixes(`[{"template": "np-{policy}:", "reason": "Drop-{policy}", ` +
+		`"eventType": "Normal", "message": "{message} by policy {policy}", "labels": {"policy": "{policy}"}}]`)
+	if err != nil {
+		t.Fatalf("Expected error nil, but got error %s", err)
+	}
+	prefix, fields := prefixes.Match("kernel: np-allow-web: IN=eth0")
+	pd := PacketDrop{LogPrefix: prefix, PrefixFields: fields}
+
+	if result := pd.GetEventReason("PacketDrop"); result != "Drop-allow-web" {
+		t.Fatalf("Expected %v, but got result %v", "Drop-allow-web", result)
+	}
+	if result := pd.GetEventType("Warning"); result != "Normal" {
+		t.Fatalf("Expected %v, but got result %v", "Normal", result)
+	}
+	if result := pd.GetEventMessage("Packet dropped"); result != "Packet dropped by policy allow-web" {
+		t.Fatalf("Expected %v, but got result %v", "Packet dropped by policy allow-web", result)
+	}
+	expectedLabels := map[string]string{"policy": "allow-web"}
+	if result := pd.GetMetricLabels(); !reflect.DeepEqual(result, expectedLabels) {
+		t.Fatalf("Expected %v, but got result %v", expectedLabels, result)
+	}
+	if result := prefixes.LabelNames(); !reflect.DeepEqual(result, []string{"policy"}) {
+		t.Fatalf("Expected %v, but got result %v", []string{"policy"}, result)
+	}
+
+	// defaults are kept without log prefix
+	pd = PacketDrop{}
+	if pd.GetEventReason("PacketDrop") != "PacketDrop" || pd.GetEventType("Warning") != "Warning" ||
+		pd.GetEventMessage("Packet dropped") != "Packet dropped" || len(pd.GetMetricLabels()) != 0 {
+		t.Fatalf("Expected default event and no labels, but got %+v", pd)
+	}
+}
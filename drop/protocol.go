@@ -0,0 +1,82 @@
+package drop
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Protocol is the IP protocol number of a packet (the next header for IPv6)
+type Protocol uint8
+
+const (
+	ProtoICMP    Protocol = 1
+	ProtoIPIP    Protocol = 4
+	ProtoTCP     Protocol = 6
+	ProtoUDP     Protocol = 17
+	ProtoDCCP    Protocol = 33
+	ProtoGRE     Protocol = 47
+	ProtoESP     Protocol = 50
+	ProtoAH      Protocol = 51
+	ProtoICMPv6  Protocol = 58
+	ProtoSCTP    Protocol = 132
+	ProtoUDPLite Protocol = 136
+)
+
+// names of protocols as logged by iptables LOG, or by nftables for the ones iptables logs as numbers
+var protocolNames = map[Protocol]string{
+	ProtoICMP:    "ICMP",
+	ProtoTCP:     "TCP",
+	ProtoUDP:     "UDP",
+	ProtoDCCP:    "DCCP",
+	ProtoESP:     "ESP",
+	ProtoAH:      "AH",
+	ProtoICMPv6:  "ICMPv6",
+	ProtoSCTP:    "SCTP",
+	ProtoUDPLite: "UDPLITE",
+}
+
+// other names of protocols used by nftables, which follows /etc/protocols
+var protocolAliases = map[string]Protocol{
+	"IPIP":      ProtoIPIP,
+	"GRE":       ProtoGRE,
+	"IPV6-ICMP": ProtoICMPv6,
+}
+
+// Return the name of protocol as iptables LOG prints it, e.g. "TCP", or its number if it has no name, e.g. "47"
+func (proto Protocol) String() string {
+	if name, ok := protocolNames[proto]; ok {
+		return name
+	}
+	return strconv.Itoa(int(proto))
+}
+
+// Check if the header of protocol starts with source and destination ports
+func (proto Protocol) HasPorts() bool {
+	switch proto {
+	case ProtoTCP, ProtoUDP, ProtoDCCP, ProtoSCTP, ProtoUDPLite:
+		return true
+	default:
+		return false
+	}
+}
+
+// Check if the protocol is ICMP or ICMPv6
+func (proto Protocol) IsIcmp() bool {
+	return proto == ProtoICMP || proto == ProtoICMPv6
+}
+
+// Return the protocol of given name or number, names are case insensitive, e.g. "TCP", "tcp" and "6" are all TCP
+func ParseProtocol(value string) (Protocol, error) {
+	if number, err := strconv.ParseUint(value, 10, 8); err == nil {
+		return Protocol(number), nil
+	}
+	for proto, name := range protocolNames {
+		if strings.EqualFold(name, value) {
+			return proto, nil
+		}
+	}
+	if proto, ok := protocolAliases[strings.ToUpper(value)]; ok {
+		return proto, nil
+	}
+	return 0, &ParseError{Field: fieldProto, Value: value}
+}
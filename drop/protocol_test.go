@@ -0,0 +1,41 @@
+package drop
+
+import "testing"
+
+// Test if ParseProtocol() accepts the names and numbers logged by iptables and nftables
+func TestParseProtocol(t *testing.T) {
+	testCases := map[string]Protocol{
+		"TCP":       ProtoTCP,
+		"udp":       ProtoUDP,
+		"ICMPv6":    ProtoICMPv6,
+		"ipv6-icmp": ProtoICMPv6,
+		"gre":       ProtoGRE,
+		"47":        ProtoGRE,
+		"132":       ProtoSCTP,
+	}
+	for value, expected := range testCases {
+		result, err := ParseProtocol(value)
+		if err != nil {
+			t.Fatalf("Expected %v, but got error %s", expected, err)
+		}
+		if result != expected {
+			t.Fatalf("Expected %v, but got result %v", expected, result)
+		}
+	}
+	if _, err := ParseProtocol("256"); err == nil {
+		t.Fatal("Expected error from protocol number out of range, but got nil")
+	}
+}
+
+// Test if Protocol prints the same names as iptables LOG
+func TestProtocolString(t *testing.T) {
+	testCases := map[Protocol]string{ProtoTCP: "TCP", ProtoICMPv6: "ICMPv6", ProtoUDPLite: "UDPLITE", ProtoGRE: "47"}
+	for proto, expected := range testCases {
+		if proto.String() != expected {
+			t.Fatalf("Expected %v, but got result %v", expected, proto.String())
+		}
+	}
+	if !ProtoSCTP.HasPorts() || ProtoICMP.HasPorts() {
+		t.Fatal("Expected only SCTP to have ports")
+	}
+}
@@ -0,0 +1,37 @@
+package drop
+
+import (
+	"errors"
+	"time"
+)
+
+// error of the helpers sending records when given stop channel is closed before the record is sent
+var errStopped = errors.New("stopped")
+
+// Record is a single log read by a Source. Sources reading raw log lines (e.g. files) only set Message to the whole
+// line, whose timestamp and host name are parsed by the log format. Sources knowing them (e.g. journald) set Time and
+// Host, and Message is the payload containing the log prefix and the fields of the packet.
+type Record struct {
+	Time     time.Time
+	Host     string
+	Message  string
+	Source   string            // name of the source, e.g. the path of the log file
+	Metadata map[string]string // source specific fields, e.g. the journal fields
+	Cursor   string            // position of the record in sources addressed by cursors, e.g. the journal cursor
+	Offset   int64             // position right after the record in sources addressed by offsets, e.g. files
+
+	// checkpoint right after the record, kept once the record is handled, nil if the source keeps no checkpoint
+	Checkpoint *Checkpoint
+	pending    *pendingCheckpoint
+}
+
+// Source reads the logs from somewhere and inserts them as records into a channel to be parsed. Run blocks until the
+// source fails or given stop channel is closed.
+type Source interface {
+	Run(stopCh <-chan struct{}, recordCh chan<- Record) error
+}
+
+// Check if the record has its own timestamp, so its message doesn't need to be parsed by the log format
+func (record Record) HasTime() bool {
+	return !record.Time.IsZero()
+}
@@ -0,0 +1,402 @@
+package drop
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// protocols of the syslog receiver
+const (
+	SyslogProtocolUDP = "udp"
+	SyslogProtocolTCP = "tcp"
+)
+
+// name of the syslog receiver as the source of records, followed by the address of the sender
+const syslogSourceName = "syslog"
+
+// maximum size of a syslog message, which is also the maximum size of UDP datagrams
+const maxSyslogMessageSize = 64 * 1024
+
+// default limits of the TCP connections of the syslog receiver, see SyslogReceiver.SetConnectionLimits()
+const (
+	DefaultSyslogMaxConnections = 100
+	DefaultSyslogIdleTimeout    = 5 * time.Minute
+)
+
+// time for the TLS handshake of a new connection, after which the connection is closed
+const syslogTLSHandshakeTimeout = 10 * time.Second
+
+// delays to retry accepting TCP connections after errors, doubled after each error in a row like net/http
+const (
+	minAcceptRetryDelay = 5 * time.Millisecond
+	maxAcceptRetryDelay = time.Second
+)
+
+// byte order mark which may start the message of RFC5424
+const syslogBOM = "\ufeff"
+
+// syslog message of RFC5424, e.g. "<4>1 2019-02-04T10:10:12.345678-07:00 hostname kernel - - - ..."
+var syslogRFC5424Regexp = regexp.MustCompile(`^\s*<\d{1,3}>\d{1,2}\s`)
+
+// SyslogReceiver listens as a syslog server, so the logs are forwarded by rsyslog or syslog-ng over the network
+// instead of being read from the log files of the node. It receives the messages of RFC3164 and RFC5424 over UDP, or
+// over TCP with octet-counting or newline framing (RFC6587) and optional TLS. The timestamp and host name of each
+// message are taken from its syslog header, so it can receive the logs of several hosts.
+type SyslogReceiver struct {
+	protocol  string
+	address   string
+	tlsConfig *tls.Config // nil if TLS is not used
+
+	maxConnections      int           // maximum number of TCP connections served at the same time
+	idleTimeout         time.Duration // time a TCP connection may be idle before it is closed, 0 if never
+	tlsHandshakeTimeout time.Duration
+
+	// the formats of syslog headers tried in order: RFC5424, RFC3164, and RFC3164 with the timestamp of RFC3339 (e.g.
+	// RSYSLOG_ForwardFormat)
+	logFormats []*LogFormat
+}
+
+// Init a syslog receiver listening to given address by given protocol (TLS is used over TCP if given TLS config is
+// not nil), where the timestamps of RFC3164 are in given location, and return its pointer
+func InitSyslogReceiver(protocol, address string, tlsConfig *tls.Config,
+	location *time.Location) (*SyslogReceiver, error) {
+	if protocol != SyslogProtocolUDP && protocol != SyslogProtocolTCP {
+		return nil, fmt.Errorf("Invalid syslog protocol %s: must be %s or %s", protocol, SyslogProtocolUDP,
+			SyslogProtocolTCP)
+	}
+	if protocol == SyslogProtocolUDP && tlsConfig != nil {
+		return nil, errors.New("Invalid syslog protocol: TLS is only supported over TCP")
+	}
+	receiver := &SyslogReceiver{
+		protocol:            protocol,
+		address:             address,
+		tlsConfig:           tlsConfig,
+		maxConnections:      DefaultSyslogMaxConnections,
+		idleTimeout:         DefaultSyslogIdleTimeout,
+		tlsHandshakeTimeout: syslogTLSHandshakeTimeout,
+	}
+	for _, format := range []string{LogFormatRFC5424, LogFormatRFC3164, LogFormatRsyslog} {
+		logFormat, err := InitLogFormat(format, "", location)
+		if err != nil {
+			return nil, err
+		}
+		receiver.logFormats = append(receiver.logFormats, logFormat)
+	}
+	return receiver, nil
+}
+
+// Set the maximum number of TCP connections served at the same time, further connections wait to be accepted until
+// another one is closed, and how long a TCP connection may be idle between two messages before it is closed (0 keeps
+// idle connections open)
+func (receiver *SyslogReceiver) SetConnectionLimits(maxConnections int, idleTimeout time.Duration) {
+	receiver.maxConnections, receiver.idleTimeout = maxConnections, idleTimeout
+}
+
+// Run the receiver and insert the received messages as records into given channel until given stop channel is closed
+func (receiver *SyslogReceiver) Run(stopCh <-chan struct{}, recordCh chan<- Record) error {
+	if receiver.protocol == SyslogProtocolUDP {
+		conn, err := net.ListenPacket(receiver.protocol, receiver.address)
+		if err != nil {
+			return err
+		}
+		return receiver.serveUDP(conn, stopCh, recordCh)
+	}
+	listener, err := net.Listen(receiver.protocol, receiver.address)
+	if err != nil {
+		return err
+	}
+	return receiver.serveTCP(listener, stopCh, recordCh)
+}
+
+// Receive the messages from given UDP connection, one message per datagram, until given stop channel is closed
+func (receiver *SyslogReceiver) serveUDP(conn net.PacketConn, stopCh <-chan struct{}, recordCh chan<- Record) error {
+	go func() {
+		<-stopCh
+		conn.Close()
+	}()
+	zap.L().Info("Receiving syslog messages", zap.String("protocol", SyslogProtocolUDP),
+		zap.String("address", conn.LocalAddr().String()))
+
+	buffer := make([]byte, maxSyslogMessageSize)
+	for {
+		n, addr, err := conn.ReadFrom(buffer)
+		if err != nil {
+			select {
+			case <-stopCh:
+				return nil
+			default:
+				return err
+			}
+		}
+		if !receiver.handleMessage(string(buffer[:n]), addr, stopCh, recordCh) {
+			return nil
+		}
+	}
+}
+
+// Accept the connections of given TCP listener and receive their messages until given stop channel is closed. The TLS
+// handshake is done by the goroutine of each connection, so a client not completing it doesn't block the others.
+func (receiver *SyslogReceiver) serveTCP(listener net.Listener, stopCh <-chan struct{},
+	recordCh chan<- Record) error {
+	var lock sync.Mutex
+	conns := make(map[net.Conn]bool)
+	go func() {
+		<-stopCh
+		listener.Close()
+		lock.Lock()
+		defer lock.Unlock()
+		for conn := range conns {
+			conn.Close()
+		}
+	}()
+	zap.L().Info("Receiving syslog messages", zap.String("protocol", SyslogProtocolTCP),
+		zap.String("address", listener.Addr().String()), zap.Bool("tls", receiver.tlsConfig != nil),
+		zap.Int("max_connections", receiver.maxConnections))
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	// a slot is taken before accepting each connection, so the connections over the limit wait in the backlog
+	slots := make(chan struct{}, receiver.maxConnections)
+	var retryDelay time.Duration
+	for {
+		select {
+		case slots <- struct{}{}:
+		default:
+			zap.L().Warn("Syslog connections reached the limit, waiting for one to close",
+				zap.Int("max_connections", receiver.maxConnections))
+			select {
+			case slots <- struct{}{}:
+			case <-stopCh:
+				return nil
+			}
+		}
+		conn, err := listener.Accept()
+		if err != nil {
+			<-slots
+			select {
+			case <-stopCh:
+				return nil
+			default:
+			}
+			// the listener is only closed by the stop channel, so the error may go away, e.g. too many open files
+			if retryDelay == 0 {
+				retryDelay = minAcceptRetryDelay
+			} else if retryDelay *= 2; retryDelay > maxAcceptRetryDelay {
+				retryDelay = maxAcceptRetryDelay
+			}
+			zap.L().Warn("Cannot accept syslog connection, retrying",
+				zap.String("error", err.Error()),
+				zap.Duration("retry", retryDelay),
+			)
+			select {
+			case <-time.After(retryDelay):
+			case <-stopCh:
+				return nil
+			}
+			continue
+		}
+		retryDelay = 0
+		if receiver.tlsConfig != nil {
+			conn = tls.Server(conn, receiver.tlsConfig)
+		}
+		lock.Lock()
+		conns[conn] = true
+		lock.Unlock()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				lock.Lock()
+				delete(conns, conn)
+				lock.Unlock()
+				conn.Close()
+				<-slots
+			}()
+			err := receiver.serveConn(conn, stopCh, recordCh)
+			if err != nil {
+				select {
+				case <-stopCh:
+				default:
+					zap.L().Warn("Cannot receive syslog messages",
+						zap.String("remote_addr", conn.RemoteAddr().String()),
+						zap.String("error", err.Error()),
+					)
+				}
+			}
+		}()
+	}
+}
+
+// Receive the messages of given TCP connection until it is closed, or it is idle longer than the idle timeout. The
+// TLS handshake of TLS connections must complete within the handshake timeout.
+func (receiver *SyslogReceiver) serveConn(conn net.Conn, stopCh <-chan struct{}, recordCh chan<- Record) error {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn.SetDeadline(time.Now().Add(receiver.tlsHandshakeTimeout))
+		if err := tlsConn.Handshake(); err != nil {
+			return fmt.Errorf("TLS handshake failed: %v", err)
+		}
+		conn.SetDeadline(time.Time{})
+	}
+	refreshDeadline := func() {
+		if receiver.idleTimeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(receiver.idleTimeout))
+		}
+	}
+	refreshDeadline()
+	// the connection is closed once the stop channel is closed, so the rest of its frames are not read
+	return readSyslogFrames(bufio.NewReader(conn), func(message string) {
+		receiver.handleMessage(message, conn.RemoteAddr(), stopCh, recordCh)
+		// the deadline is refreshed on each frame, so only the connections idle between two frames time out
+		refreshDeadline()
+	})
+}
+
+// Read the syslog messages framed by octet-counting ("LENGTH MESSAGE") or by newlines from given reader, and handle
+// them by given function until the reader ends. The frames starting with anything else than the length and a space
+// are framed by newlines, and the messages longer than maxSyslogMessageSize are skipped.
+func readSyslogFrames(reader *bufio.Reader, handle func(message string)) error {
+	for {
+		if _, err := reader.Peek(1); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if isOctetCounted(reader) {
+			length, err := reader.ReadString(' ')
+			if err != nil {
+				return err
+			}
+			// the length has been checked, so there is no error
+			n, _ := strconv.Atoi(strings.TrimSuffix(length, " "))
+			if n > maxSyslogMessageSize {
+				zap.L().Warn("Skipping overlong syslog message", zap.Int("length", n),
+					zap.Int("max_length", maxSyslogMessageSize))
+				if _, err := reader.Discard(n); err != nil {
+					return err
+				}
+				continue
+			}
+			message := make([]byte, n)
+			if _, err := io.ReadFull(reader, message); err != nil {
+				return err
+			}
+			handle(string(message))
+			continue
+		}
+
+		line, err := reader.ReadString('\n')
+		if line = strings.TrimRight(line, "\r\n"); strings.TrimSpace(line) != "" {
+			handle(line)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Helper function to check if the next frame of given reader starts with its length followed by a space, e.g.
+// "123 <4>...", otherwise the frame is framed by newlines. The lengths with one more digit than maxSyslogMessageSize
+// are still lengths, so the overlong messages can be skipped.
+func isOctetCounted(reader *bufio.Reader) bool {
+	maxDigits := len(strconv.Itoa(maxSyslogMessageSize)) + 1
+	for n := 1; n <= maxDigits+1; n++ {
+		peeked, err := reader.Peek(n)
+		if err != nil {
+			return false
+		}
+		switch c := peeked[n-1]; {
+		case c == ' ':
+			return n > 1
+		case c >= '1' && c <= '9', c == '0' && n > 1:
+			continue
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+// Helper function to insert given message received from given address as a record into given channel, return false if
+// given stop channel is closed before the record is inserted
+func (receiver *SyslogReceiver) handleMessage(message string, addr net.Addr, stopCh <-chan struct{},
+	recordCh chan<- Record) bool {
+	record, err := receiver.getSyslogRecord(strings.TrimRight(message, "\r\n\x00"), addr)
+	if err != nil {
+		zap.L().Error("Cannot parse the syslog message",
+			zap.String("log", message),
+			zap.String("remote_addr", addr.String()),
+			zap.String("error", err.Error()),
+		)
+		return true
+	}
+	select {
+	case recordCh <- record:
+		return true
+	case <-stopCh:
+		return false
+	}
+}
+
+// Helper function to get the record of given syslog message received from given address, whose timestamp and host
+// name are parsed from its header. The host name is the address of the sender if the header has none.
+func (receiver *SyslogReceiver) getSyslogRecord(message string, addr net.Addr) (Record, error) {
+	remoteHost := addr.String()
+	if host, _, err := net.SplitHostPort(remoteHost); err == nil {
+		remoteHost = host
+	}
+
+	logFormats := receiver.logFormats[1:]
+	if syslogRFC5424Regexp.MatchString(message) {
+		logFormats = receiver.logFormats[:1]
+	} else {
+		// the priority is only optional in RFC3164 messages
+		message = trimSyslogPriority(message)
+	}
+	var err error
+	for _, logFormat := range logFormats {
+		var logTime time.Time
+		var host, payload string
+		if logTime, host, payload, err = logFormat.Parse(message); err == nil {
+			if host == "" {
+				host = remoteHost
+			}
+			return Record{
+				Time:     logTime,
+				Host:     host,
+				Message:  strings.TrimPrefix(payload, syslogBOM),
+				Source:   syslogSourceName + "://" + remoteHost,
+				Metadata: map[string]string{"remote_addr": addr.String()},
+			}, nil
+		}
+	}
+	return Record{}, err
+}
+
+// Helper function to remove the priority in front of given syslog message, e.g. "<4>"
+func trimSyslogPriority(message string) string {
+	trimmed := strings.TrimLeft(message, " ")
+	if strings.HasPrefix(trimmed, "<") {
+		if i := strings.Index(trimmed, ">"); i > 1 && i <= 4 {
+			if _, err := strconv.Atoi(trimmed[1:i]); err == nil {
+				return trimmed[i+1:]
+			}
+		}
+	}
+	return message
+}
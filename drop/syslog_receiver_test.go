@@ -0,0 +1,363 @@
+package drop
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSyslogPayload = "kernel: calico-drop: IN=eth0 OUT= SRC=10.0.1.2 DST=10.0.2.3 LEN=60 TTL=63 PROTO=TCP"
+
+// Helper function to init a syslog receiver for tests, whose RFC3164 timestamps are in UTC
+func initTestSyslogReceiver(t *testing.T, protocol string, tlsConfig *tls.Config) *SyslogReceiver {
+	receiver, err := InitSyslogReceiver(protocol, "127.0.0.1:0", tlsConfig, time.UTC)
+	if err != nil {
+		t.Fatalf("Expected no error, but got error %+v", err)
+	}
+	return receiver
+}
+
+// Test if the syslog headers of RFC3164, RFC5424 and RSYSLOG_ForwardFormat are parsed
+func TestGetSyslogRecord(t *testing.T) {
+	defer func(now func() time.Time) { timeNow = now }(timeNow)
+	timeNow = func() time.Time { return time.Date(2019, 2, 5, 0, 0, 0, 0, time.UTC) }
+	receiver := initTestSyslogReceiver(t, SyslogProtocolUDP, nil)
+	addr := &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 51234}
+
+	for message, expected := range map[string]Record{
+		"<4>Feb  4 10:10:12 node-1 " + testSyslogPayload: {
+			Time: time.Date(2019, 2, 4, 10, 10, 12, 0, time.UTC), Host: "node-1", Message: testSyslogPayload,
+		},
+		"<4>1 2019-02-04T10:10:12.345678-07:00 node-2 kernel - - [meta x=\"1\"] \ufeffcalico-drop: IN=eth0": {
+			Time: time.Date(2019, 2, 4, 17, 10, 12, 345678000, time.UTC), Host: "node-2",
+			Message: "calico-drop: IN=eth0",
+		},
+		"<4>1 2019-02-04T10:10:12Z - kernel - - - calico-drop: IN=eth0": {
+			Time: time.Date(2019, 2, 4, 10, 10, 12, 0, time.UTC), Host: "10.0.0.1", Message: "calico-drop: IN=eth0",
+		},
+		"<4>2019-02-04T10:10:12.345678+00:00 node-3 " + testSyslogPayload: {
+			Time: time.Date(2019, 2, 4, 10, 10, 12, 345678000, time.UTC), Host: "node-3",
+			Message: testSyslogPayload,
+		},
+	} {
+		record, err := receiver.getSyslogRecord(message, addr)
+		if err != nil {
+			t.Fatalf("Expected no error of message %s, but got error %+v", message, err)
+		}
+		if !record.Time.Equal(expected.Time) || record.Host != expected.Host || record.Message != expected.Message ||
+			record.Source != "syslog://10.0.0.1" || record.Metadata["remote_addr"] != "10.0.0.1:51234" {
+			t.Fatalf("Expected %+v of message %s, but got result %+v", expected, message, record)
+		}
+	}
+
+	if _, err := receiver.getSyslogRecord("<4>not a syslog message", addr); err == nil {
+		t.Fatalf("Expected error, but got nil")
+	}
+}
+
+// Test if the messages framed by octet-counting and newlines are read
+func TestReadSyslogFrames(t *testing.T) {
+	input := "20 <4>first message\nend\n<4>second message\r\n\n22 <4>third message 1 2 3<4>last message"
+	var messages []string
+	err := readSyslogFrames(bufio.NewReader(strings.NewReader(input)), func(message string) {
+		messages = append(messages, message)
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, but got error %+v", err)
+	}
+	expected := []string{"<4>first message\nend", "<4>second message", "<4>third message 1 2 3", "<4>last message"}
+	if !reflect.DeepEqual(messages, expected) {
+		t.Fatalf("Expected %q, but got result %q", expected, messages)
+	}
+
+	// the frames not starting with a length are framed by newlines, and the overlong messages are skipped
+	input = "1x <4>not counted\n0 <4>not counted\n99999 " + strings.Repeat("x", 99999) + "11 <4>last one"
+	messages = nil
+	err = readSyslogFrames(bufio.NewReader(strings.NewReader(input)), func(message string) {
+		messages = append(messages, message)
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, but got error %+v", err)
+	}
+	expected = []string{"1x <4>not counted", "0 <4>not counted", "<4>last one"}
+	if !reflect.DeepEqual(messages, expected) {
+		t.Fatalf("Expected %q, but got result %q", expected, messages)
+	}
+
+	// the connection closed in the middle of a message
+	if err := readSyslogFrames(bufio.NewReader(strings.NewReader("20 <4>short")), func(string) {}); err == nil {
+		t.Fatalf("Expected error, but got nil")
+	}
+}
+
+// Test if invalid protocols return error
+func TestInitSyslogReceiverInvalid(t *testing.T) {
+	if _, err := InitSyslogReceiver("sctp", ":514", nil, nil); err == nil {
+		t.Fatalf("Expected error, but got nil")
+	}
+	if _, err := InitSyslogReceiver(SyslogProtocolUDP, ":514", &tls.Config{}, nil); err == nil {
+		t.Fatalf("Expected error, but got nil")
+	}
+}
+
+// Helper function to receive the message of a record from given channel, or fail the test after a while
+func receiveSyslogMessage(t *testing.T, channel <-chan Record) string {
+	select {
+	case record := <-channel:
+		return record.Message
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected a record, but got nothing")
+		return ""
+	}
+}
+
+// Test if the receiver receives the messages over UDP
+func TestSyslogReceiverServeUDP(t *testing.T) {
+	receiver := initTestSyslogReceiver(t, SyslogProtocolUDP, nil)
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Cannot listen, err=%+v", err)
+	}
+	stopCh, channel, errCh := make(chan struct{}), make(chan Record, 10), make(chan error)
+	go func() {
+		errCh <- receiver.serveUDP(conn, stopCh, channel)
+	}()
+
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("Cannot connect, err=%+v", err)
+	}
+	defer client.Close()
+	client.Write([]byte("<4>Feb  4 10:10:12 node-1 " + testSyslogPayload + "\n"))
+	if result := receiveSyslogMessage(t, channel); result != testSyslogPayload {
+		t.Fatalf("Expected %v, but got result %v", testSyslogPayload, result)
+	}
+	close(stopCh)
+	if err := <-errCh; err != nil {
+		t.Fatalf("Expected error nil, but got error %s", err)
+	}
+}
+
+// Helper function to get a TLS config with a self-signed certificate of 127.0.0.1
+func getTestTLSConfig(t *testing.T) *tls.Config {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Cannot generate key, err=%+v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "kube-iptables-tailer"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Cannot create certificate, err=%+v", err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{cert}, PrivateKey: key}}}
+}
+
+// Test if the receiver receives the messages over TCP, with and without TLS, from several connections
+func TestSyslogReceiverServeTCP(t *testing.T) {
+	for _, tlsConfig := range []*tls.Config{nil, getTestTLSConfig(t)} {
+		receiver := initTestSyslogReceiver(t, SyslogProtocolTCP, tlsConfig)
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Cannot listen, err=%+v", err)
+		}
+		stopCh, channel, errCh := make(chan struct{}), make(chan Record, 10), make(chan error)
+		go func() {
+			errCh <- receiver.serveTCP(listener, stopCh, channel)
+		}()
+
+		for _, host := range []string{"node-1", "node-2"} {
+			var client net.Conn
+			if tlsConfig != nil {
+				client, err = tls.Dial("tcp", listener.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+			} else {
+				client, err = net.Dial("tcp", listener.Addr().String())
+			}
+			if err != nil {
+				t.Fatalf("Cannot connect, err=%+v", err)
+			}
+			message := "<4>Feb  4 10:10:12 " + host + " " + testSyslogPayload
+			client.Write([]byte(message + "\n"))
+			client.Write([]byte(strconv.Itoa(len(message)) + " " + message))
+			for i := 0; i < 2; i++ {
+				if result := receiveSyslogMessage(t, channel); result != testSyslogPayload {
+					t.Fatalf("Expected %v, but got result %v", testSyslogPayload, result)
+				}
+			}
+			// the connection is kept open until the receiver stops
+			defer client.Close()
+		}
+		close(stopCh)
+		if err := <-errCh; err != nil {
+			t.Fatalf("Expected error nil, but got error %s", err)
+		}
+	}
+}
+
+// Helper function to serve the TCP connections of given receiver, return the address and the channels of records and of
+// the error returned once given stop channel is closed
+func serveTestSyslogTCP(t *testing.T, receiver *SyslogReceiver, stopCh <-chan struct{}) (string, chan Record,
+	chan error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Cannot listen, err=%+v", err)
+	}
+	channel, errCh := make(chan Record, 10), make(chan error)
+	go func() {
+		errCh <- receiver.serveTCP(listener, stopCh, channel)
+	}()
+	return listener.Addr().String(), channel, errCh
+}
+
+// Helper function to check if given connection is closed by the receiver after a while
+func checkSyslogConnClosed(t *testing.T, conn net.Conn) {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatalf("Expected connection closed, but got data")
+	} else if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		t.Fatalf("Expected connection closed, but it is still open")
+	}
+}
+
+// Test if the connections idle longer than the idle timeout are closed, and the timeout is refreshed by each message
+func TestSyslogReceiverIdleTimeout(t *testing.T) {
+	receiver := initTestSyslogReceiver(t, SyslogProtocolTCP, nil)
+	receiver.SetConnectionLimits(DefaultSyslogMaxConnections, 200*time.Millisecond)
+	stopCh := make(chan struct{})
+	address, channel, errCh := serveTestSyslogTCP(t, receiver, stopCh)
+
+	client, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("Cannot connect, err=%+v", err)
+	}
+	defer client.Close()
+	for i := 0; i < 3; i++ {
+		time.Sleep(100 * time.Millisecond)
+		client.Write([]byte("<4>Feb  4 10:10:12 node-1 " + testSyslogPayload + "\n"))
+		if result := receiveSyslogMessage(t, channel); result != testSyslogPayload {
+			t.Fatalf("Expected %v, but got result %v", testSyslogPayload, result)
+		}
+	}
+	checkSyslogConnClosed(t, client)
+	close(stopCh)
+	if err := <-errCh; err != nil {
+		t.Fatalf("Expected error nil, but got error %s", err)
+	}
+}
+
+// Test if the connections over the limit are only served once another connection is closed
+func TestSyslogReceiverMaxConnections(t *testing.T) {
+	receiver := initTestSyslogReceiver(t, SyslogProtocolTCP, nil)
+	receiver.SetConnectionLimits(1, 0)
+	stopCh := make(chan struct{})
+	address, channel, errCh := serveTestSyslogTCP(t, receiver, stopCh)
+
+	var clients []net.Conn
+	for _, host := range []string{"node-1", "node-2"} {
+		client, err := net.Dial("tcp", address)
+		if err != nil {
+			t.Fatalf("Cannot connect, err=%+v", err)
+		}
+		defer client.Close()
+		client.Write([]byte("<4>Feb  4 10:10:12 " + host + " " + testSyslogPayload + "\n"))
+		clients = append(clients, client)
+	}
+	if record := <-channel; record.Host != "node-1" {
+		t.Fatalf("Expected record of node-1, but got result %+v", record)
+	}
+	select {
+	case record := <-channel:
+		t.Fatalf("Expected no record over the connection limit, but got result %+v", record)
+	case <-time.After(100 * time.Millisecond):
+	}
+	clients[0].Close()
+	select {
+	case record := <-channel:
+		if record.Host != "node-2" {
+			t.Fatalf("Expected record of node-2, but got result %+v", record)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected a record, but got nothing")
+	}
+	close(stopCh)
+	if err := <-errCh; err != nil {
+		t.Fatalf("Expected error nil, but got error %s", err)
+	}
+}
+
+// Test if the TLS connections not completing the handshake are closed after the handshake timeout
+func TestSyslogReceiverTLSHandshakeTimeout(t *testing.T) {
+	receiver := initTestSyslogReceiver(t, SyslogProtocolTCP, getTestTLSConfig(t))
+	receiver.tlsHandshakeTimeout = 100 * time.Millisecond
+	stopCh := make(chan struct{})
+	address, _, errCh := serveTestSyslogTCP(t, receiver, stopCh)
+
+	client, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("Cannot connect, err=%+v", err)
+	}
+	defer client.Close()
+	checkSyslogConnClosed(t, client)
+	close(stopCh)
+	if err := <-errCh; err != nil {
+		t.Fatalf("Expected error nil, but got error %s", err)
+	}
+}
+
+// failingListener is a listener failing to accept the connections a number of times before accepting them
+type failingListener struct {
+	net.Listener
+	failures int
+}
+
+func (listener *failingListener) Accept() (net.Conn, error) {
+	if listener.failures > 0 {
+		listener.failures--
+		return nil, errors.New("too many open files")
+	}
+	return listener.Listener.Accept()
+}
+
+// Test if accepting the connections is retried after errors
+func TestSyslogReceiverAcceptRetry(t *testing.T) {
+	receiver := initTestSyslogReceiver(t, SyslogProtocolTCP, nil)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Cannot listen, err=%+v", err)
+	}
+	stopCh, channel, errCh := make(chan struct{}), make(chan Record, 10), make(chan error)
+	go func() {
+		errCh <- receiver.serveTCP(&failingListener{Listener: listener, failures: 3}, stopCh, channel)
+	}()
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Cannot connect, err=%+v", err)
+	}
+	defer client.Close()
+	client.Write([]byte("<4>Feb  4 10:10:12 node-1 " + testSyslogPayload + "\n"))
+	if result := receiveSyslogMessage(t, channel); result != testSyslogPayload {
+		t.Fatalf("Expected %v, but got result %v", testSyslogPayload, result)
+	}
+	close(stopCh)
+	if err := <-errCh; err != nil {
+		t.Fatalf("Expected error nil, but got error %s", err)
+	}
+}
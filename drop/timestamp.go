@@ -0,0 +1,161 @@
+package drop
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/box/kube-iptables-tailer/util"
+)
+
+// special time layouts
+const (
+	// TimeLayoutAuto tries all the layouts of autoTimeLayouts
+	TimeLayoutAuto = "auto"
+	// TimeLayoutUptime is the seconds since boot of dmesg, e.g. "[12345.678901]"
+	TimeLayoutUptime = "uptime"
+)
+
+// separator of multiple time layouts, which never appears in Go time layouts
+const timeLayoutSeparator = "|"
+
+// time layouts tried in order by TimeLayoutAuto
+var autoTimeLayouts = []string{
+	util.DefaultPacketDropLogTimeLayout,
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999Z0700",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	rfc3164TimeLayout,
+	time.ANSIC,
+	TimeLayoutUptime,
+}
+
+// file containing the boot time of kernel in seconds since epoch, e.g. "btime 1549300212"
+const procStatFile = "/proc/stat"
+
+// functions to get the current time and the boot time, replaced by tests
+var timeNow = time.Now
+var readBootTime = readProcStatBootTime
+
+// how far timestamps without year may be ahead of the current time, set by SetYearRollover()
+var yearRollover = 24 * time.Hour
+
+// Set how far the timestamps without year (e.g. the ones of BSD syslog) may be ahead of the current time, e.g. by the
+// clock skew of the hosts sending the logs. The timestamps further ahead are in the previous year.
+func SetYearRollover(window time.Duration) {
+	yearRollover = window
+}
+
+// Helper function to get the list of time layouts from given time layouts separated by "|", where "auto" stands for
+// all the layouts of autoTimeLayouts. All the layouts of autoTimeLayouts are returned if given time layouts are empty.
+func getTimeLayouts(timeLayout string) []string {
+	var layouts []string
+	for _, layout := range strings.Split(timeLayout, timeLayoutSeparator) {
+		if layout == TimeLayoutAuto {
+			layouts = append(layouts, autoTimeLayouts...)
+		} else if layout != "" {
+			layouts = append(layouts, layout)
+		}
+	}
+	if len(layouts) == 0 {
+		return autoTimeLayouts
+	}
+	return layouts
+}
+
+// Helper function to parse given timestamp by the time layouts of log format. The layout which parsed the last
+// timestamp is tried first, so the layout of a log is detected from its first line.
+func (format *LogFormat) parseTime(timestamp string) (time.Time, error) {
+	format.lock.Lock()
+	defer format.lock.Unlock()
+
+	last := format.lastTimeLayout
+	if logTime, err := format.parseTimeWithLayout(timestamp, format.TimeLayouts[last]); err == nil {
+		return logTime, nil
+	}
+	for i, layout := range format.TimeLayouts {
+		if i == last {
+			continue
+		}
+		if logTime, err := format.parseTimeWithLayout(timestamp, layout); err == nil {
+			format.lastTimeLayout = i
+			return logTime, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("Invalid timestamp %s: none of time layouts %s matches", timestamp,
+		strings.Join(format.TimeLayouts, timeLayoutSeparator))
+}
+
+// Helper function to parse given timestamp by given time layout. Timestamps without time zone are in the location of
+// log format, and timestamps without year (e.g. the ones of BSD syslog) are in the year closest to the current time.
+func (format *LogFormat) parseTimeWithLayout(timestamp, layout string) (time.Time, error) {
+	if layout == TimeLayoutUptime {
+		return format.parseUptime(timestamp)
+	}
+	logTime, err := time.ParseInLocation(layout, timestamp, format.Location)
+	if err != nil || logTime.Year() != 0 {
+		return logTime, err
+	}
+	return getTimeInClosestYear(logTime, timeNow().In(format.Location), yearRollover), nil
+}
+
+// Helper function to move given time without year into the latest of the next, current and previous years of given
+// current time where it is at most given window ahead of the current time, e.g. the logs of January read in late
+// December are in the next year, and the logs of December read in January are in the previous year
+func getTimeInClosestYear(logTime, curTime time.Time, window time.Duration) time.Time {
+	latest := curTime.Add(window)
+	for _, year := range []int{curTime.Year() + 1, curTime.Year()} {
+		if yearTime := logTime.AddDate(year, 0, 0); !yearTime.After(latest) {
+			return yearTime
+		}
+	}
+	return logTime.AddDate(curTime.Year()-1, 0, 0)
+}
+
+// Helper function to parse given seconds since boot, e.g. "[12345.678901]", into the time since the boot time
+func (format *LogFormat) parseUptime(timestamp string) (time.Time, error) {
+	uptime := strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(timestamp, "["), "]"))
+	if _, err := strconv.ParseFloat(uptime, 64); err != nil {
+		return time.Time{}, fmt.Errorf("Invalid uptime %s", timestamp)
+	}
+	duration, err := time.ParseDuration(uptime + "s")
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid uptime %s: %v", timestamp, err)
+	}
+	if format.bootTime.IsZero() {
+		if format.bootTime, err = readBootTime(); err != nil {
+			return time.Time{}, err
+		}
+	}
+	return format.bootTime.Add(duration), nil
+}
+
+// Helper function to read the boot time of kernel from /proc/stat
+func readProcStatBootTime() (time.Time, error) {
+	file, err := os.Open(procStatFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "btime" {
+			seconds, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("Invalid boot time %s: %v", fields[1], err)
+			}
+			return time.Unix(seconds, 0), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return time.Time{}, err
+	}
+	return time.Time{}, errors.New("Cannot find boot time in " + procStatFile)
+}
@@ -0,0 +1,153 @@
+package drop
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/box/kube-iptables-tailer/util"
+)
+
+// Test if getTimeLayouts() splits the time layouts and expands "auto"
+func TestGetTimeLayouts(t *testing.T) {
+	result := getTimeLayouts("2006-01-02|uptime")
+	expected := []string{"2006-01-02", TimeLayoutUptime}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("Expected %v, but got result %v", expected, result)
+	}
+	result = getTimeLayouts("Jan _2 2006|auto")
+	if len(result) != len(autoTimeLayouts)+1 || result[0] != "Jan _2 2006" {
+		t.Fatalf("Expected %v followed by %v, but got result %v", "Jan _2 2006", autoTimeLayouts, result)
+	}
+	// a list without any time layout is the same as "auto"
+	if result = getTimeLayouts("|"); !reflect.DeepEqual(result, autoTimeLayouts) {
+		t.Fatalf("Expected %v, but got result %v", autoTimeLayouts, result)
+	}
+	logFormat, err := InitLogFormat(LogFormatCiliumJSON, "|", time.UTC)
+	if err != nil || !reflect.DeepEqual(logFormat.TimeLayouts, autoTimeLayouts) {
+		t.Fatalf("Expected time layouts %v, but got result %+v and error %v", autoTimeLayouts, logFormat, err)
+	}
+}
+
+// Test if the time layout is detected from the timestamps of logs
+func TestParseTimeAuto(t *testing.T) {
+	logFormat, err := InitLogFormat(`(?P<timestamp>.+) - (?P<payload>.*)`, TimeLayoutAuto, time.UTC)
+	if err != nil {
+		t.Fatalf("Expected error nil, but got error %s", err)
+	}
+	defer func(now func() time.Time) { timeNow = now }(timeNow)
+	timeNow = func() time.Time { return time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC) }
+
+	testCases := []struct {
+		timestamp string
+		expected  time.Time
+	}{
+		{"2019-02-04T10:10:12.345678-07:00", time.Date(2019, 2, 4, 17, 10, 12, 345678000, time.UTC)},
+		{"2019-02-04T10:10:12.123456789Z", time.Date(2019, 2, 4, 10, 10, 12, 123456789, time.UTC)},
+		{"2019-02-04 10:10:12.5", time.Date(2019, 2, 4, 10, 10, 12, 500000000, time.UTC)},
+		{"Feb  4 10:10:12", time.Date(2019, 2, 4, 10, 10, 12, 0, time.UTC)},
+		{"Feb  4 10:10:12.345", time.Date(2019, 2, 4, 10, 10, 12, 345000000, time.UTC)},
+	}
+	for _, testCase := range testCases {
+		result, _, _, err := logFormat.Parse(testCase.timestamp + " - calico-drop:")
+		if err != nil {
+			t.Fatalf("Expected error nil, but got error %s", err)
+		}
+		if !result.Equal(testCase.expected) {
+			t.Fatalf("Expected %v, but got result %v", testCase.expected, result)
+		}
+		// the detected time layout is tried first for the next timestamp
+		if layout := logFormat.TimeLayouts[logFormat.lastTimeLayout]; layout == TimeLayoutUptime {
+			t.Fatalf("Expected time layout detected from %s, but got result %v", testCase.timestamp, layout)
+		}
+	}
+	if _, _, _, err := logFormat.Parse("yesterday - calico-drop:"); err == nil {
+		t.Fatalf("Expected error from invalid timestamp, but got nil")
+	}
+}
+
+// Test if the timestamps without year and time zone are in the closest year and given time zone
+func TestParseTimeWithoutYear(t *testing.T) {
+	location, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		t.Skipf("Cannot load time zone: %s", err)
+	}
+	logFormat, _ := InitLogFormat(LogFormatRFC3164, "", location)
+	defer func(now func() time.Time) { timeNow = now }(timeNow)
+
+	testCases := []struct {
+		curTime   time.Time
+		timestamp string
+		expected  time.Time
+	}{
+		{time.Date(2019, 2, 4, 20, 0, 0, 0, time.UTC), "Feb  4 10:10:12", time.Date(2019, 2, 4, 10, 10, 12, 0, location)},
+		{time.Date(2020, 1, 1, 9, 0, 0, 0, time.UTC), "Dec 31 23:59:59", time.Date(2019, 12, 31, 23, 59, 59, 0, location)},
+		{time.Date(2019, 12, 31, 23, 0, 0, 0, location), "Jan  1 00:00:01", time.Date(2020, 1, 1, 0, 0, 1, 0, location)},
+	}
+	for _, testCase := range testCases {
+		timeNow = func() time.Time { return testCase.curTime }
+		result, _, _, err := logFormat.Parse("<4>" + testCase.timestamp + " hostname kernel: calico-drop:")
+		if err != nil {
+			t.Fatalf("Expected error nil, but got error %s", err)
+		}
+		if !result.Equal(testCase.expected) {
+			t.Fatalf("Expected %v, but got result %v", testCase.expected, result)
+		}
+	}
+}
+
+// Test if the timestamps without year further ahead of the current time than the window are in the previous year
+func TestGetTimeInClosestYear(t *testing.T) {
+	curTime := time.Date(2019, 3, 1, 0, 0, 0, 0, time.UTC)
+	logTime := time.Date(0, 3, 20, 10, 10, 12, 0, time.UTC)
+	testCases := []struct {
+		window   time.Duration
+		expected time.Time
+	}{
+		{24 * time.Hour, time.Date(2018, 3, 20, 10, 10, 12, 0, time.UTC)},
+		{30 * 24 * time.Hour, time.Date(2019, 3, 20, 10, 10, 12, 0, time.UTC)},
+	}
+	for _, testCase := range testCases {
+		if result := getTimeInClosestYear(logTime, curTime, testCase.window); !result.Equal(testCase.expected) {
+			t.Fatalf("Expected %v, but got result %v", testCase.expected, result)
+		}
+	}
+}
+
+// Test if the uptime of dmesg is converted by the boot time, and the packet drop is not expired
+func TestParseTimeUptime(t *testing.T) {
+	bootTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	defer func(read func() (time.Time, error)) { readBootTime = read }(readBootTime)
+	readBootTime = func() (time.Time, error) { return bootTime, nil }
+
+	logFormat, _ := InitLogFormat(LogFormatDmesg, "", nil)
+	log := "[ 3599.123456] calico-drop: IN=eth0 OUT= SRC=1.1.1.1 DST=2.2.2.2 TTL=64 PROTO=UDP SPT=53 DPT=53"
+	channel := make(chan PacketDrop, 1)
+	if err := parse(getTestLogPrefixes("calico-drop:"), logFormat, Record{Message: log}, channel); err != nil {
+		t.Fatalf("Expected error nil, but got error %s", err)
+	}
+	if len(channel) != 1 {
+		t.Fatalf("Expected 1 packet drop in channel, but got %d", len(channel))
+	}
+	expected := bootTime.Add(3599*time.Second + 123456*time.Microsecond)
+	if result := <-channel; !result.LogTime.Equal(expected) || result.HostName != "" {
+		t.Fatalf("Expected log time %v, but got result %v", expected, result.LogTime)
+	}
+
+	if _, err := logFormat.parseUptime("[abc]"); err == nil {
+		t.Fatalf("Expected error from invalid uptime, but got nil")
+	}
+	// the boot time is read only once
+	readBootTime = func() (time.Time, error) { return time.Time{}, nil }
+	if result, _ := logFormat.parseUptime("[1.5]"); !result.Equal(bootTime.Add(1500 * time.Millisecond)) {
+		t.Fatalf("Expected %v, but got result %v", bootTime.Add(1500*time.Millisecond), result)
+	}
+}
+
+// Test if the default log format only uses the default time layout
+func TestParseTimeDefaultLayout(t *testing.T) {
+	logFormat, _ := InitLogFormat(LogFormatDefault, "", nil)
+	if !reflect.DeepEqual(logFormat.TimeLayouts, []string{util.DefaultPacketDropLogTimeLayout}) {
+		t.Fatalf("Expected %v, but got result %v", util.DefaultPacketDropLogTimeLayout, logFormat.TimeLayouts)
+	}
+}
@@ -0,0 +1,144 @@
+package drop
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// time layouts of the timestamps written by the JSON output plugin of ulogd2, e.g. "2019-02-04T10:10:12.345678+0100",
+// which has no time zone if the plugin is set to write local time without offset
+const ulogdTimeLayout = "2006-01-02T15:04:05.999999999Z0700|2006-01-02T15:04:05.999999999"
+
+// names of the ulogd2 keys, which are also the fields of its JSON objects
+const (
+	ulogdKeySrcIP       = "src_ip"
+	ulogdKeyDstIP       = "dest_ip"
+	ulogdKeyProtocol    = "ip.protocol"
+	ulogdKeyInterfaceIn = "oob.in"
+)
+
+// ulogdEntry is a JSON object written by the JSON output plugin of ulogd2 for a logged packet, e.g.
+// `{"timestamp": "2019-02-04T10:10:12.345678+0100", "oob.prefix": "calico-drop: ", "oob.in": "eth0", "oob.out": "",
+// "raw.mac": "00:15:5d:01:02:03:00:15:5d:04:05:06:08:00", "ip.protocol": 6, "src_ip": "10.0.1.2",
+// "dest_ip": "10.0.2.3", "src_port": 51234, "dest_port": 80}`. The addresses must be written as strings by the IP2STR
+// filter. The keys which are not logged (e.g. the ports of ICMP) are nil.
+type ulogdEntry struct {
+	Timestamp string  `json:"timestamp"`
+	TimeSec   *int64  `json:"oob.time.sec"`
+	TimeUsec  int64   `json:"oob.time.usec"`
+	Prefix    string  `json:"oob.prefix"`
+	In        *string `json:"oob.in"`
+	Out       string  `json:"oob.out"`
+	Mac       string  `json:"raw.mac"`
+	Mark      uint32  `json:"oob.mark"`
+	Uid       *uint32 `json:"oob.uid"`
+	Gid       *uint32 `json:"oob.gid"`
+	Protocol  *uint8  `json:"ip.protocol"`
+	SrcIP     string  `json:"src_ip"`
+	DstIP     string  `json:"dest_ip"`
+	SrcPort   uint16  `json:"src_port"`
+	DstPort   uint16  `json:"dest_port"`
+	Length    uint16  `json:"ip.totlen"`
+	Ttl       uint8   `json:"ip.ttl"`
+	HopLimit  uint8   `json:"ip6.hoplimit"`
+	IcmpType  *uint8  `json:"icmp.type"`
+	IcmpCode  *uint8  `json:"icmp.code"`
+	Icmp6Type *uint8  `json:"icmpv6.type"`
+	Icmp6Code *uint8  `json:"icmpv6.code"`
+}
+
+// Decode the JSON object of ulogd2 in given record into PacketDrop, and return it with the log prefix of the packet.
+// The time of the packet is the one logged by the kernel if ulogd2 writes it, and the host name is the one of the
+// record or of this host, as ulogd2 doesn't write host names.
+func decodeUlogdJSON(format *LogFormat, record Record) (PacketDrop, string, error) {
+	var entry ulogdEntry
+	if err := json.Unmarshal([]byte(record.Message), &entry); err != nil {
+		return PacketDrop{}, "", fmt.Errorf("Invalid ulogd2 log: %v", err)
+	}
+
+	pd := PacketDrop{
+		HostName:      record.Host,
+		Source:        record.Source,
+		InterfaceSent: entry.Out,
+		MacAddress:    entry.Mac,
+		Mark:          entry.Mark,
+		Length:        entry.Length,
+		SrcPort:       entry.SrcPort,
+		DstPort:       entry.DstPort,
+	}
+	var err error
+	if pd.LogTime, err = format.getUlogdTime(entry, record); err != nil {
+		return PacketDrop{}, "", err
+	}
+	if pd.HostName == "" {
+		pd.HostName = format.getHostName()
+	}
+	if pd.SrcIP, err = getUlogdIP(entry.SrcIP, ulogdKeySrcIP); err != nil {
+		return PacketDrop{}, "", err
+	}
+	if pd.DstIP, err = getUlogdIP(entry.DstIP, ulogdKeyDstIP); err != nil {
+		return PacketDrop{}, "", err
+	}
+	if entry.Protocol == nil {
+		return PacketDrop{}, "", &ParseError{Field: ulogdKeyProtocol, Err: ErrMissingField}
+	}
+	pd.Proto = Protocol(*entry.Protocol)
+	if entry.In == nil {
+		return PacketDrop{}, "", &ParseError{Field: ulogdKeyInterfaceIn, Err: ErrMissingField}
+	}
+	pd.InterfaceReceived = *entry.In
+
+	pd.Family, pd.Ttl = getFamily(pd.SrcIP), entry.Ttl
+	if pd.Family == FamilyIPv6 {
+		pd.Ttl = entry.HopLimit
+	}
+	pd.Uid, pd.Gid = entry.Uid, entry.Gid
+	icmpType, icmpCode := entry.IcmpType, entry.IcmpCode
+	if pd.Proto == ProtoICMPv6 {
+		icmpType, icmpCode = entry.Icmp6Type, entry.Icmp6Code
+	}
+	if pd.IsIcmp() && icmpType != nil && icmpCode != nil {
+		pd.IcmpType, pd.IcmpCode = icmpType, *icmpCode
+	}
+	return pd, entry.Prefix, nil
+}
+
+// Helper function to get the time of given ulogd2 entry: the time logged by the kernel if it's written, otherwise the
+// timestamp of the entry or the time of given record
+func (format *LogFormat) getUlogdTime(entry ulogdEntry, record Record) (time.Time, error) {
+	if entry.TimeSec != nil {
+		return time.Unix(*entry.TimeSec, entry.TimeUsec*int64(time.Microsecond)), nil
+	}
+	if entry.Timestamp == "" && record.HasTime() {
+		return record.Time, nil
+	}
+	if entry.Timestamp == "" {
+		return time.Time{}, &ParseError{Field: formatFieldTimestamp, Err: ErrMissingField}
+	}
+	return format.parseTime(entry.Timestamp)
+}
+
+// Helper function to get the host name of this host, which is read on its first use
+func (format *LogFormat) getHostName() string {
+	format.lock.Lock()
+	defer format.lock.Unlock()
+	if format.hostName == "" {
+		format.hostName, _ = os.Hostname()
+	}
+	return format.hostName
+}
+
+// Helper function to parse the IP address of given ulogd2 key
+func getUlogdIP(value, key string) (net.IP, error) {
+	if value == "" {
+		return nil, &ParseError{Field: key, Err: ErrMissingField}
+	}
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil, &ParseError{Field: key, Value: value}
+	}
+	return ip, nil
+}
@@ -0,0 +1,115 @@
+package drop
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// log format of the JSON objects of ulogd2, whose timestamps without time zone are in UTC
+var testUlogdFormat, _ = InitLogFormat(LogFormatUlogdJSON, "", time.UTC)
+
+// Helper function to get the JSON object of ulogd2 of a TCP packet logged at given time
+func getTestUlogdLog(logTime time.Time, prefix string) string {
+	return fmt.Sprintf(`{"timestamp": "%s", "dvc": "Netfilter", "oob.time.sec": %d, "oob.time.usec": %d, `+
+		`"oob.prefix": "%s", "oob.mark": 0, "oob.in": "%s", "oob.out": "%s", "raw.mac": "%s", "ip.protocol": 6, `+
+		`"ip.ttl": %d, "ip.totlen": 60, "src_ip": "%s", "dest_ip": "%s", "src_port": %d, "dest_port": %d}`,
+		logTime.Format("2006-01-02T15:04:05.000000-0700"), logTime.Unix(), logTime.Nanosecond()/1000, prefix,
+		testInterfaceReceived, testInterfaceSent, testMacAddress, testPacketTtl, testSrcIP, testDstIP, testSrcPort,
+		testDstPort)
+}
+
+// Test if the JSON objects of ulogd2 are parsed with the log prefix of "oob.prefix"
+func TestParsingUlogdLog(t *testing.T) {
+	channel := make(chan PacketDrop, 1)
+	curTime := time.Now().Round(time.Microsecond)
+	record := Record{Message: getTestUlogdLog(curTime, testLogPrefix+" "), Host: testHostname, Source: "ulogd.json"}
+	expected := PacketDrop{
+		LogTime:           curTime,
+		HostName:          testHostname,
+		LogPrefix:         testLogPrefixes[0],
+		Family:            FamilyIPv4,
+		SrcIP:             net.ParseIP(testSrcIP),
+		SrcPort:           testSrcPort,
+		DstIP:             net.ParseIP(testDstIP),
+		DstPort:           testDstPort,
+		Proto:             ProtoTCP,
+		InterfaceReceived: testInterfaceReceived,
+		InterfaceSent:     testInterfaceSent,
+		MacAddress:        testMacAddress,
+		Ttl:               testPacketTtl,
+		Length:            60,
+		Source:            "ulogd.json",
+	}
+	if err := parse(testLogPrefixes, testUlogdFormat, record, channel); err != nil {
+		t.Fatalf("Expected %+v, but got error %s", expected, err)
+	}
+	if result := <-channel; !reflect.DeepEqual(result, expected) {
+		t.Fatalf("Expected %+v, but got result %+v", expected, result)
+	}
+
+	// the packets of other log prefixes and the expired packets are skipped
+	for _, record := range []Record{
+		{Message: getTestUlogdLog(curTime, "other-prefix: ")},
+		{Message: getTestUlogdLog(curTime.Add(-time.Hour), testLogPrefix+" ")},
+	} {
+		if err := parse(testLogPrefixes, testUlogdFormat, record, channel); err != nil {
+			t.Fatalf("Expected error nil, but got error %s", err)
+		}
+		if len(channel) != 0 {
+			t.Fatalf("Expected no packet drop of log %s, but got result %+v", record.Message, <-channel)
+		}
+	}
+}
+
+// Test if the JSON objects of ulogd2 without kernel time nor ports are decoded
+func TestDecodeUlogdJSON(t *testing.T) {
+	log := `{"timestamp": "2019-02-04T10:10:12.345678", "oob.prefix": "log-prefix ", "oob.in": "eth0", ` +
+		`"oob.out": "", "oob.uid": 1000, "ip.protocol": 58, "ip6.hoplimit": 255, "src_ip": "fd00::1", ` +
+		`"dest_ip": "fd00::2", "icmpv6.type": 128, "icmpv6.code": 0}`
+	result, prefix, err := decodeUlogdJSON(testUlogdFormat, Record{Message: log, Host: testHostname})
+	if err != nil {
+		t.Fatalf("Expected error nil, but got error %s", err)
+	}
+	expected := PacketDrop{
+		LogTime:           time.Date(2019, 2, 4, 10, 10, 12, 345678000, time.UTC),
+		HostName:          testHostname,
+		Family:            FamilyIPv6,
+		SrcIP:             net.ParseIP("fd00::1"),
+		DstIP:             net.ParseIP("fd00::2"),
+		Proto:             ProtoICMPv6,
+		InterfaceReceived: "eth0",
+		Ttl:               255,
+		Uid:               uint32Pointer(1000),
+		IcmpType:          uint8Pointer(128),
+	}
+	if !reflect.DeepEqual(result, expected) || prefix != "log-prefix " {
+		t.Fatalf("Expected %+v, but got result %+v", expected, result)
+	}
+
+	// the time of ulogd2 is kept in backfill
+	logTime, _, _, err := testUlogdFormat.Parse(log)
+	if err != nil || !logTime.Equal(expected.LogTime) {
+		t.Fatalf("Expected %v, but got result %v and error %v", expected.LogTime, logTime, err)
+	}
+}
+
+// Test if the JSON objects of ulogd2 with missing or invalid fields return error
+func TestDecodeBadUlogdJSON(t *testing.T) {
+	for _, log := range []string{
+		`not json`,
+		`{"oob.in": "eth0", "ip.protocol": 6, "src_ip": "10.0.0.1", "dest_ip": "10.0.0.2"}`,
+		`{"timestamp": "2019-02-04T10:10:12", "oob.in": "eth0", "ip.protocol": 6, "dest_ip": "10.0.0.2"}`,
+		`{"timestamp": "2019-02-04T10:10:12", "oob.in": "eth0", "ip.protocol": 6, "src_ip": 167772161, ` +
+			`"dest_ip": "10.0.0.2"}`,
+		`{"timestamp": "2019-02-04T10:10:12", "oob.in": "eth0", "src_ip": "10.0.0.1", "dest_ip": "10.0.0.2"}`,
+		`{"timestamp": "2019-02-04T10:10:12", "ip.protocol": 6, "src_ip": "10.0.0.1", "dest_ip": "10.0.0.2"}`,
+		`{"timestamp": "04/02/2019", "oob.in": "eth0", "ip.protocol": 6, "src_ip": "10.0.0.1", "dest_ip": "10.0.0.2"}`,
+	} {
+		if _, _, err := decodeUlogdJSON(testUlogdFormat, Record{Message: log}); err == nil {
+			t.Fatalf("Expected error of log %s, but got nil", log)
+		}
+	}
+}
@@ -2,44 +2,175 @@ package drop
 
 import (
 	"bufio"
-	"errors"
-	"fmt"
+	"bytes"
 	"io"
 	"os"
+	"strings"
 	"time"
 
 	"go.uber.org/zap"
 )
 
-const fingerprintSize = 64 // using the first log in your iptables log file (should have length > 64) as fingerprint
+// events of the watched file
+type fileEvent int
+
+const (
+	fileModified fileEvent = iota // content of the file changed
+	fileCreated                   // a new file took the name of the file, e.g. after rotation
+	fileRemoved                   // the file was renamed or deleted
+)
+
+// DefaultMaxLineLength is the maximum length of the lines read by a watcher unless SetLineLimits() is called, which is
+// the longest line bufio.Scanner reads
+const DefaultMaxLineLength = bufio.MaxScanTokenSize
+
+// reasons of counting the lines of log files which cannot be read as they are
+const (
+	LineOverlong   = "overlong"   // the line is longer than the maximum length and is skipped
+	LineIncomplete = "incomplete" // the line has no newline after the partial line timeout and is read as it is
+)
+
+// function to count the lines of log files by the reason, set by SetLineCounter()
+var countLine = func(fileName, reason string) {}
+
+// Set the function counting the lines of log files which are skipped as they are overlong, or read without newline
+// as they are incomplete, e.g. to update the metrics
+func SetLineCounter(counter func(fileName, reason string)) {
+	countLine = counter
+}
+
+// fileNotifier sends the events of a file, or an error if it cannot watch the file anymore. Follow tells the notifier
+// the file being read, whose changes are sent even after it is renamed.
+type fileNotifier interface {
+	Events() <-chan fileEvent
+	Errors() <-chan error
+	Follow(fileName string) error
+	Close() error
+}
 
 // Watcher handles detecting any changes on the given file and passing those changes through Go Channel to be parsed.
+// The changes are detected from the file events (inotify), or by checking the file at every watch interval if polling
+// is used or the file events don't work.
+// The file being read is kept open and identified by its device and inode, so a rotated file is read to its end
+// before the new file is opened, and a file truncated by copytruncate is read again from its beginning. The identity
+// and the offset read are kept as checkpoint, and the file is read from the offset of its checkpoint when it's opened.
+// The last line of the file is held back until it ends with a newline or the partial line timeout passes, so a line
+// being written is not read in pieces, and the lines longer than the maximum line length are skipped.
 type Watcher struct {
 	watchFileName    string
 	watchInterval    time.Duration
+	usePolling       bool
 	lastReadPosition int64
-	curFingerprint   string
+	curFile          *os.File
+	curFileInfo      os.FileInfo
+	notifier         fileNotifier     // nil if polling is used
+	checkpoints      *CheckpointStore // nil if checkpoints are not kept
+	backfill         *backfill        // nil if the rotated files are not read on start
+
+	maxLineLength      int
+	partialLineTimeout time.Duration // 0 if the incomplete last line is read right away
+	partialLineTime    time.Time     // when the incomplete last line was found, zero if there is none
+	skippingLine       bool          // true if the rest of an overlong line is still to be skipped
 
 	failedOpenfile bool
 }
 
-// Init a watcher object and return its pointer
-func InitWatcher(watchFileName string, watchInterval time.Duration) *Watcher {
-	watcher := Watcher{watchFileName: watchFileName, watchInterval: watchInterval}
+// Init a watcher object keeping its checkpoint in given checkpoint store (which may be nil) and return its pointer
+func InitWatcher(watchFileName string, watchInterval time.Duration, usePolling bool,
+	checkpoints *CheckpointStore) *Watcher {
+	watcher := Watcher{
+		watchFileName: watchFileName,
+		watchInterval: watchInterval,
+		usePolling:    usePolling,
+		checkpoints:   checkpoints,
+		maxLineLength: DefaultMaxLineLength,
+	}
 	return &watcher
 }
 
-// Run the watcher and insert newly found logs into given channel
-func (watcher *Watcher) Run(logChangeCh chan<- string) {
-	for range time.Tick(watcher.watchInterval) {
-		// check the file inside loop to get updated content at every watch interval
-		watcher.checkFile(logChangeCh)
+// Set the maximum length of the lines to read, and how long an incomplete last line is held back for the rest of it
+func (watcher *Watcher) SetLineLimits(maxLineLength int, partialLineTimeout time.Duration) {
+	watcher.maxLineLength, watcher.partialLineTimeout = maxLineLength, partialLineTimeout
+}
+
+// Run the watcher and insert newly found logs as records into given channel until given stop channel is closed
+func (watcher *Watcher) Run(stopCh <-chan struct{}, recordCh chan<- Record) error {
+	defer watcher.closeCurFile()
+	if watcher.backfill != nil && !watcher.runBackfill(stopCh, recordCh) {
+		return nil
+	}
+	if !watcher.usePolling {
+		notifier, err := newFileNotifier(watcher.watchFileName)
+		if err == nil {
+			if err = watcher.runNotified(stopCh, recordCh, notifier); err == nil {
+				return nil
+			}
+		}
+		// the file being read is kept, so polling continues from the position read so far
+		zap.L().Warn("Cannot watch file events, polling the file instead",
+			zap.String("file", watcher.watchFileName),
+			zap.String("error", err.Error()),
+		)
 	}
+	return watcher.runPolling(stopCh, recordCh)
 }
 
-// Open the watched file and check its content if it is opened successfully
-func (watcher *Watcher) checkFile(logChangeCh chan<- string) {
-	file, err := os.Open(watcher.watchFileName)
+// Check the file whenever given notifier tells it changed until given stop channel is closed
+func (watcher *Watcher) runNotified(stopCh <-chan struct{}, recordCh chan<- Record, notifier fileNotifier) error {
+	watcher.notifier = notifier
+	defer func() {
+		watcher.notifier = nil
+		notifier.Close()
+	}()
+	if watcher.curFile != nil {
+		watcher.follow()
+	}
+
+	watcher.checkFile(stopCh, recordCh)
+	for {
+		select {
+		case <-stopCh:
+			return nil
+		case err := <-notifier.Errors():
+			return err
+		case <-notifier.Events():
+			watcher.checkFile(stopCh, recordCh)
+		case <-watcher.partialLineTimer():
+			// no event may come if the writer never finishes the line
+			watcher.checkFile(stopCh, recordCh)
+		}
+	}
+}
+
+// Helper function to get the channel receiving the time when the incomplete last line times out, which is nil if
+// there is no incomplete line
+func (watcher *Watcher) partialLineTimer() <-chan time.Time {
+	if watcher.partialLineTime.IsZero() {
+		return nil
+	}
+	return time.After(watcher.partialLineTime.Add(watcher.partialLineTimeout).Sub(timeNow()))
+}
+
+// Check the file at every watch interval and insert newly found logs into given channel until given stop channel is
+// closed
+func (watcher *Watcher) runPolling(stopCh <-chan struct{}, recordCh chan<- Record) error {
+	ticker := time.NewTicker(watcher.watchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return nil
+		case <-ticker.C:
+			// check the file inside loop to get updated content at every watch interval
+			watcher.checkFile(stopCh, recordCh)
+		}
+	}
+}
+
+// Check the watched file and send its new content to the channel until given stop channel is closed. Once the file is
+// rotated, the rest of the rotated file is read before switching to the new file.
+func (watcher *Watcher) checkFile(stopCh <-chan struct{}, recordCh chan<- Record) {
+	info, err := os.Stat(watcher.watchFileName)
 	if err != nil {
 		if !watcher.failedOpenfile {
 			zap.L().Error("Failed to open file",
@@ -47,72 +178,246 @@ func (watcher *Watcher) checkFile(logChangeCh chan<- string) {
 				zap.String("error", err.Error()),
 			)
 		}
-
 		watcher.failedOpenfile = true
+		// the file may be renamed without being recreated yet, keep reading the rotated file
+		watcher.readCurFile(stopCh, recordCh)
 		return
 	}
-
 	watcher.failedOpenfile = false
 
-	defer closeFile(file)
-	checkErr := watcher.check(file, logChangeCh)
-	if checkErr != nil {
-		zap.L().Error("Failed to check the contents of file", zap.String("error", checkErr.Error()))
+	if watcher.curFile != nil && !os.SameFile(watcher.curFileInfo, info) {
+		if !watcher.readCurFile(stopCh, recordCh) {
+			return
+		}
+		// the logs may still be written to the rotated file until the new file is written, e.g. before rsyslog is
+		// told to reopen its files
+		if info.Size() == 0 {
+			return
+		}
+		zap.L().Info("File rotated, switching to the new file", zap.String("file", watcher.watchFileName))
+		if !watcher.readCurFileToEnd(stopCh, recordCh) {
+			return
+		}
+		watcher.closeCurFile()
 	}
+	if watcher.curFile == nil {
+		if err := watcher.openCurFile(); err != nil {
+			zap.L().Error("Failed to open file",
+				zap.String("file", watcher.watchFileName),
+				zap.String("error", err.Error()),
+			)
+			return
+		}
+	}
+	watcher.readCurFile(stopCh, recordCh)
 }
 
-// Check the content from given input, and send changes to the channel
-func (watcher *Watcher) check(input io.ReadSeeker, logChangeCh chan<- string) error {
-	// check log rotation first from current input
-	err := watcher.checkRotation(input)
+// Helper function to open the watched file as current file to read from its beginning, or from the offset of its
+// checkpoint if the checkpoint is of the same file
+func (watcher *Watcher) openCurFile() error {
+	file, err := os.Open(watcher.watchFileName)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
 	if err != nil {
+		closeFile(file)
 		return err
 	}
+	watcher.curFile, watcher.curFileInfo = file, info
+	watcher.reset()
+	if checkpoint, ok := watcher.checkpoints.Get(watcher.watchFileName); ok && watcher.isCurFile(checkpoint) {
+		zap.L().Info("Resuming file from checkpoint",
+			zap.String("file", watcher.watchFileName),
+			zap.Int64("offset", checkpoint.Offset),
+		)
+		// the file truncated since the checkpoint is read from its beginning by readCurFile()
+		watcher.lastReadPosition = checkpoint.Offset
+	}
+	if watcher.notifier != nil {
+		watcher.follow()
+	}
+	return nil
+}
+
+// Helper function to check if given checkpoint is of the current file
+func (watcher *Watcher) isCurFile(checkpoint Checkpoint) bool {
+	device, inode, ok := getFileID(watcher.curFileInfo)
+	return ok && checkpoint.Device == device && checkpoint.Inode == inode
+}
+
+// Helper function to get the offset read in current file as checkpoint, return nil if no checkpoint is kept
+func (watcher *Watcher) getCheckpoint() *Checkpoint {
+	if watcher.checkpoints == nil || watcher.curFileInfo == nil {
+		return nil
+	}
+	device, inode, ok := getFileID(watcher.curFileInfo)
+	if !ok {
+		return nil
+	}
+	return &Checkpoint{Source: watcher.watchFileName, Device: device, Inode: inode, Offset: watcher.lastReadPosition}
+}
+
+// Helper function to let the notifier send the changes of current file even after it is renamed
+func (watcher *Watcher) follow() {
+	if err := watcher.notifier.Follow(watcher.watchFileName); err != nil {
+		zap.L().Warn("Cannot watch the events of file",
+			zap.String("file", watcher.watchFileName),
+			zap.String("error", err.Error()),
+		)
+	}
+}
+
+// Helper function to close the current file
+func (watcher *Watcher) closeCurFile() {
+	if watcher.curFile != nil {
+		closeFile(watcher.curFile)
+		watcher.curFile, watcher.curFileInfo = nil, nil
+	}
+}
+
+// Helper function to read the new content of current file, which is read again from its beginning if it is truncated.
+// Return false if given stop channel is closed meanwhile.
+func (watcher *Watcher) readCurFile(stopCh <-chan struct{}, recordCh chan<- Record) bool {
+	if watcher.curFile == nil {
+		return true
+	}
+	info, err := watcher.curFile.Stat()
+	if err != nil {
+		zap.L().Error("Failed to check the contents of file", zap.String("error", err.Error()))
+		return true
+	}
+	if info.Size() < watcher.lastReadPosition {
+		zap.L().Info("File truncated, reading it from the beginning", zap.String("file", watcher.watchFileName))
+		watcher.reset()
+	}
+	if err := watcher.readLines(watcher.curFile, stopCh, recordCh); err == errStopped {
+		return false
+	} else if err != nil {
+		zap.L().Error("Failed to check the contents of file", zap.String("error", err.Error()))
+	}
+	return true
+}
 
+// Helper function to read the rest of current file including its incomplete last line, as the rotated file is not
+// written anymore. Return false if given stop channel is closed meanwhile.
+func (watcher *Watcher) readCurFileToEnd(stopCh <-chan struct{}, recordCh chan<- Record) bool {
+	timeout := watcher.partialLineTimeout
+	watcher.partialLineTimeout = 0
+	defer func() {
+		watcher.partialLineTimeout = timeout
+	}()
+	return watcher.readCurFile(stopCh, recordCh)
+}
+
+// Read the lines after the last read position from given input, and send them to the channel until given stop channel
+// is closed. The incomplete last line is left unread until it's complete or it times out, and the overlong lines are
+// skipped. Return errStopped if given stop channel is closed meanwhile.
+func (watcher *Watcher) readLines(input io.ReadSeeker, stopCh <-chan struct{}, recordCh chan<- Record) error {
 	// skip the content already read
 	if _, err := input.Seek(watcher.lastReadPosition, 0); err != nil {
 		return err
 	}
-	scanner := bufio.NewScanner(input)
-	scanLines := func(data []byte, atEOF bool) (advance int, token []byte, err error) {
-		advance, token, err = bufio.ScanLines(data, atEOF)
-		watcher.lastReadPosition += int64(advance)
-		return
-	}
-	scanner.Split(scanLines)
+	reader := bufio.NewReader(input)
+	for {
+		line, err := readLine(reader, watcher.maxLineLength)
+		if err != nil {
+			return err
+		}
+		if line.size == 0 {
+			watcher.partialLineTime = time.Time{}
+			return nil
+		}
+
+		skip := watcher.skippingLine || line.overlong
+		if line.overlong && !watcher.skippingLine {
+			zap.L().Warn("Skipping overlong line",
+				zap.String("file", watcher.watchFileName),
+				zap.Int64("offset", watcher.lastReadPosition),
+				zap.Int("max_length", watcher.maxLineLength),
+			)
+			countLine(watcher.watchFileName, LineOverlong)
+		}
+		if !skip && !line.complete && !watcher.isPartialLineTimedOut() {
+			// the rest of the line may not be written yet
+			return nil
+		}
 
-	// send updated logs to channel
-	for scanner.Scan() {
-		newLog := scanner.Text()
-		logChangeCh <- newLog
+		watcher.lastReadPosition += line.size
+		watcher.partialLineTime = time.Time{}
+		if skip {
+			// the rest of an overlong line is skipped as well
+			watcher.skippingLine = !line.complete
+		} else {
+			record := Record{Message: line.text, Source: watcher.watchFileName, Offset: watcher.lastReadPosition,
+				Checkpoint: watcher.getCheckpoint()}
+			select {
+			case recordCh <- record:
+			case <-stopCh:
+				return errStopped
+			}
+		}
 	}
-	return nil
 }
 
-// Check rotation from the given input and update fingerprint if input is rotated
-func (watcher *Watcher) checkRotation(input io.Reader) error {
-	// get fingerprint of the input
-	bytes := make([]byte, fingerprintSize)
-	sizeRead, err := input.Read(bytes)
-	// check sizeRead before err according to documentation of Reader.Read()
-	if sizeRead < fingerprintSize {
-		return errors.New(fmt.Sprint("Error getting fingerprint, insufficient content."))
+// Helper function to check if the incomplete last line has been held back for the partial line timeout since it was
+// found
+func (watcher *Watcher) isPartialLineTimedOut() bool {
+	if watcher.partialLineTime.IsZero() {
+		watcher.partialLineTime = timeNow()
 	}
-	if err != nil {
-		return errors.New(fmt.Sprintf("Error checking rotation: error=%+v", err.Error()))
+	if timeNow().Sub(watcher.partialLineTime) < watcher.partialLineTimeout {
+		return false
 	}
-	fingerprint := string(bytes[:])
-	if fingerprint != watcher.curFingerprint {
-		// need to reset the watcher because the log file has been rotated (fingerprint didn't match)
-		watcher.reset(fingerprint)
+	if watcher.partialLineTimeout > 0 {
+		zap.L().Warn("Reading incomplete line after timeout",
+			zap.String("file", watcher.watchFileName),
+			zap.Int64("offset", watcher.lastReadPosition),
+		)
+		countLine(watcher.watchFileName, LineIncomplete)
+	}
+	return true
+}
+
+// logLine is a line read from a log file
+type logLine struct {
+	text     string // the line without line break, empty if it's overlong
+	size     int64  // number of bytes read including the line break
+	complete bool   // true if the line ends with a newline
+	overlong bool   // true if the line is longer than the maximum length
+}
+
+// Helper function to read a line from given reader, only keeping the line if it's not longer than given max length
+func readLine(reader *bufio.Reader, maxLength int) (logLine, error) {
+	var line logLine
+	var buffer []byte
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		line.size += int64(len(chunk))
+		if !line.overlong {
+			buffer = append(buffer, chunk...)
+			if len(bytes.TrimRight(buffer, "\r\n")) > maxLength {
+				line.overlong, buffer = true, nil
+			}
+		}
+		switch err {
+		case bufio.ErrBufferFull:
+			continue
+		case nil:
+			line.complete = true
+		case io.EOF:
+		default:
+			return logLine{}, err
+		}
+		line.text = strings.TrimSuffix(strings.TrimSuffix(string(buffer), "\n"), "\r")
+		return line, nil
 	}
-	return nil
 }
 
-// Reset watcher's lastReadPosition and update its fingerprint
-func (watcher *Watcher) reset(fingerprint string) {
+// Reset watcher's lastReadPosition, together with the state of the line being read
+func (watcher *Watcher) reset() {
 	watcher.lastReadPosition = 0
-	watcher.curFingerprint = fingerprint
+	watcher.partialLineTime, watcher.skippingLine = time.Time{}, false
 }
 
 // Helper function to close the file properly
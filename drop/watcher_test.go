@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"io/ioutil"
 	"os"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -14,25 +15,43 @@ const TestLog2 = "2019-02-04T04:05:06.178452-07:00 hostname logPrefix: SRC=22.22
 
 //Test if init the watcher object works (InitWatcher() includes the Reset() method)
 func TestWatcherReset(t *testing.T) {
-	watcher := InitWatcher("", time.Second)
+	watcher := InitWatcher("", time.Second, true, nil)
 	watcher.lastReadPosition = 256
-	watcher.curFingerprint = "test-fingerprint"
 	expectedLastReadPosition := int64(0)
-	expectedFingerprint := "new-fingerprint"
 
-	watcher.reset(expectedFingerprint)
+	watcher.reset()
 	resultLastReadPosition := watcher.lastReadPosition
-	resultFingerprint := watcher.curFingerprint
 
 	if expectedLastReadPosition != resultLastReadPosition {
 		t.Fatalf("Expected last read position %v, but got actual last read position %v",
 			expectedLastReadPosition,
 			resultLastReadPosition)
 	}
-	if expectedFingerprint != resultFingerprint {
-		t.Fatalf("Expected fingerprint %s, but got actual fingerprint %s",
-			expectedFingerprint,
-			resultFingerprint)
+}
+
+// Test if the watcher stops while nobody receives its records
+func TestWatcherRunStopWhileSending(t *testing.T) {
+	fileName := "test-stop.txt"
+	if err := ioutil.WriteFile(fileName, []byte(TestLog1+"\n"+TestLog2+"\n"), 0755); err != nil {
+		t.Fatalf("Cannot create the test file, err=%+v", err)
+	}
+	defer os.Remove(fileName)
+
+	watcher := InitWatcher(fileName, 10*time.Millisecond, true, nil)
+	stopCh := make(chan struct{})
+	errCh := make(chan error)
+	go func() {
+		errCh <- watcher.Run(stopCh, make(chan Record))
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(stopCh)
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("Expected error nil, but got error %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the watcher stopped, but it is still sending")
 	}
 }
 
@@ -45,12 +64,12 @@ func TestCheckFile(t *testing.T) {
 		t.Fatalf("Cannot create the test file, err=%+v", err)
 	}
 	// test if the file can be opened and checked
-	watcher := InitWatcher(fileName, time.Second)
-	channel := make(chan string)
-	go watcher.checkFile(channel)
+	watcher := InitWatcher(fileName, time.Second, true, nil)
+	channel := make(chan Record)
+	go watcher.checkFile(nil, channel)
 	result := <-channel
-	if result != TestLog1 {
-		t.Fatalf("Expected %s, but got result %s", TestLog1, result)
+	if result.Message != TestLog1 {
+		t.Fatalf("Expected %s, but got result %s", TestLog1, result.Message)
 	}
 	// remove the test file created above
 	err = os.Remove(fileName)
@@ -61,63 +80,334 @@ func TestCheckFile(t *testing.T) {
 
 // Test if the basic checking content works
 func TestCheckContent(t *testing.T) {
-	watcher := InitWatcher("", time.Second)
-	channel := make(chan string)
+	watcher := InitWatcher("", time.Second, true, nil)
+	channel := make(chan Record)
 	expected := TestLog1
 	input := strings.NewReader(expected)
-	go watcher.check(input, channel)
+	go watcher.readLines(input, nil, channel)
 
 	result := <-channel
-	if result != expected {
-		t.Fatalf("Expected %s, but got result %s", expected, result)
+	if result.Message != expected {
+		t.Fatalf("Expected %s, but got result %s", expected, result.Message)
 	}
 }
 
 // Test if checking updated content works
 func TestCheckUpdate(t *testing.T) {
-	watcher := InitWatcher("", time.Second)
-	channel := make(chan string)
+	watcher := InitWatcher("", time.Second, true, nil)
+	channel := make(chan Record)
 	expected1 := TestLog1
 	expected2 := "Updated Input."
 
 	var buffer bytes.Buffer
 	buffer.WriteString(expected1)
 	input := strings.NewReader(buffer.String())
-	go watcher.check(input, channel)
+	go watcher.readLines(input, nil, channel)
 	result1 := <-channel
 
 	buffer.WriteString(expected2)
 	updatedInput := strings.NewReader(buffer.String())
-	go watcher.check(updatedInput, channel)
+	go watcher.readLines(updatedInput, nil, channel)
 	result2 := <-channel
 
-	if result1 != expected1 {
-		t.Fatalf("Expected %s, but got result %s", expected1, result1)
+	if result1.Message != expected1 {
+		t.Fatalf("Expected %s, but got result %s", expected1, result1.Message)
 	}
-	if result2 != expected2 {
-		t.Fatalf("Expected %s, but got result %s", expected2, result2)
+	if result2.Message != expected2 {
+		t.Fatalf("Expected %s, but got result %s", expected2, result2.Message)
 	}
 }
 
-// Test if checking rotated input works
+// Helper function to append given log to given file
+func appendLog(t *testing.T, fileName, log string) {
+	file, err := os.OpenFile(fileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("Cannot open the test file, err=%+v", err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(log + "\n"); err != nil {
+		t.Fatalf("Cannot write the test file, err=%+v", err)
+	}
+}
+
+// Helper function to check the file of given watcher and get the messages of records sent
+func checkFileMessages(watcher *Watcher) []string {
+	channel := make(chan Record, 10)
+	watcher.checkFile(nil, channel)
+	close(channel)
+	var messages []string
+	for record := range channel {
+		messages = append(messages, record.Message)
+	}
+	return messages
+}
+
+// Test if checking rotated file works: the rotated file is read to its end, and the new file is read once it is
+// written
 func TestCheckRotation(t *testing.T) {
-	watcher := InitWatcher("", time.Second)
-	channel := make(chan string)
-	expected := TestLog1
-	input := strings.NewReader(expected)
-	go watcher.check(input, channel)
-	result := <-channel
+	fileName := "test-rotation.txt"
+	defer os.Remove(fileName)
+	defer os.Remove(fileName + ".1")
+	appendLog(t, fileName, TestLog1)
+	watcher := InitWatcher(fileName, time.Second, true, nil)
+	defer watcher.closeCurFile()
+	if result := checkFileMessages(watcher); !reflect.DeepEqual(result, []string{TestLog1}) {
+		t.Fatalf("Expected %v, but got result %v", []string{TestLog1}, result)
+	}
+
+	// the log written after the last check but before rotation is not lost
+	appendLog(t, fileName, "before rotation")
+	if err := os.Rename(fileName, fileName+".1"); err != nil {
+		t.Fatalf("Cannot rotate the test file, err=%+v", err)
+	}
+	if result := checkFileMessages(watcher); !reflect.DeepEqual(result, []string{"before rotation"}) {
+		t.Fatalf("Expected %v, but got result %v", []string{"before rotation"}, result)
+	}
+
+	// the rotated file is still read while the new file is empty
+	if err := ioutil.WriteFile(fileName, nil, 0644); err != nil {
+		t.Fatalf("Cannot create the test file, err=%+v", err)
+	}
+	appendLog(t, fileName+".1", "after rotation")
+	if result := checkFileMessages(watcher); !reflect.DeepEqual(result, []string{"after rotation"}) {
+		t.Fatalf("Expected %v, but got result %v", []string{"after rotation"}, result)
+	}
+
+	appendLog(t, fileName+".1", "before switching")
+	appendLog(t, fileName, TestLog2)
+	expected := []string{"before switching", TestLog2}
+	if result := checkFileMessages(watcher); !reflect.DeepEqual(result, expected) {
+		t.Fatalf("Expected %v, but got result %v", expected, result)
+	}
+}
 
-	// updated the date of log as mock of rotating
-	rotatedExpected := TestLog2
-	rotatedInput := strings.NewReader(rotatedExpected)
-	go watcher.check(rotatedInput, channel)
-	rotatedResult := <-channel
+// Test if checking truncated file works, even if its first line is the same as before
+func TestCheckTruncation(t *testing.T) {
+	fileName := "test-truncation.txt"
+	defer os.Remove(fileName)
+	appendLog(t, fileName, TestLog1)
+	appendLog(t, fileName, TestLog2)
+	watcher := InitWatcher(fileName, time.Second, true, nil)
+	defer watcher.closeCurFile()
+	if result := checkFileMessages(watcher); len(result) != 2 {
+		t.Fatalf("Expected 2 logs, but got result %v", result)
+	}
+
+	// copytruncate
+	if err := os.Truncate(fileName, 0); err != nil {
+		t.Fatalf("Cannot truncate the test file, err=%+v", err)
+	}
+	appendLog(t, fileName, TestLog1)
+	if result := checkFileMessages(watcher); !reflect.DeepEqual(result, []string{TestLog1}) {
+		t.Fatalf("Expected %v, but got result %v", []string{TestLog1}, result)
+	}
+}
 
-	if result != expected {
-		t.Fatalf("Expected %s, but got result %s", expected, result)
+// Test if checking file shorter than 64 bytes works
+func TestCheckSmallFile(t *testing.T) {
+	fileName := "test-small.txt"
+	defer os.Remove(fileName)
+	appendLog(t, fileName, "short")
+	watcher := InitWatcher(fileName, time.Second, true, nil)
+	defer watcher.closeCurFile()
+	if result := checkFileMessages(watcher); !reflect.DeepEqual(result, []string{"short"}) {
+		t.Fatalf("Expected %v, but got result %v", []string{"short"}, result)
+	}
+	appendLog(t, fileName, "still short")
+	if result := checkFileMessages(watcher); !reflect.DeepEqual(result, []string{"still short"}) {
+		t.Fatalf("Expected %v, but got result %v", []string{"still short"}, result)
+	}
+}
+
+// Test if the watcher sends records of its file with their offsets, and stops when the stop channel is closed
+func TestWatcherRun(t *testing.T) {
+	fileName := "test-run.txt"
+	content := TestLog1 + "\n" + TestLog2 + "\n"
+	if err := ioutil.WriteFile(fileName, []byte(content), 0644); err != nil {
+		t.Fatalf("Cannot create the test file, err=%+v", err)
+	}
+	defer os.Remove(fileName)
+
+	watcher := InitWatcher(fileName, 10*time.Millisecond, true, nil)
+	stopCh := make(chan struct{})
+	channel := make(chan Record)
+	errCh := make(chan error)
+	go func() {
+		errCh <- watcher.Run(stopCh, channel)
+	}()
+
+	expected := []Record{
+		{Message: TestLog1, Source: fileName, Offset: int64(len(TestLog1) + 1)},
+		{Message: TestLog2, Source: fileName, Offset: int64(len(content))},
+	}
+	for _, record := range expected {
+		if result := <-channel; !reflect.DeepEqual(result, record) {
+			t.Fatalf("Expected %+v, but got result %+v", record, result)
+		}
+	}
+	close(stopCh)
+	if err := <-errCh; err != nil {
+		t.Fatalf("Expected error nil, but got error %s", err)
 	}
-	if rotatedResult != rotatedExpected {
-		t.Fatalf("Expected %s, but got result %s", rotatedExpected, rotatedResult)
+}
+
+// Test if the watcher resumes its file from the checkpoint of the same file, and reads another file from its
+// beginning
+func TestWatcherResumeFromCheckpoint(t *testing.T) {
+	fileName := "test-checkpoint.txt"
+	defer os.Remove(fileName)
+	appendLog(t, fileName, TestLog1)
+	appendLog(t, fileName, TestLog2)
+	info, err := os.Stat(fileName)
+	if err != nil {
+		t.Fatalf("Cannot check the test file, err=%+v", err)
+	}
+	device, inode, ok := getFileID(info)
+	if !ok {
+		t.Skip("File identity is not supported")
+	}
+	offset := int64(len(TestLog1) + 1)
+
+	store := &CheckpointStore{checkpoints: make(map[string]Checkpoint)}
+	store.Set(Checkpoint{Source: fileName, Device: device, Inode: inode, Offset: offset})
+	watcher := InitWatcher(fileName, time.Second, true, store)
+	channel := make(chan Record, 10)
+	watcher.checkFile(nil, channel)
+	watcher.closeCurFile()
+	close(channel)
+	var records []Record
+	for record := range channel {
+		records = append(records, record)
+	}
+	if len(records) != 1 || records[0].Message != TestLog2 {
+		t.Fatalf("Expected %v, but got result %+v", []string{TestLog2}, records)
+	}
+	// the checkpoint is kept by the parser once the record is handled
+	expected := &Checkpoint{Source: fileName, Device: device, Inode: inode, Offset: info.Size()}
+	if result := records[0].Checkpoint; !reflect.DeepEqual(result, expected) {
+		t.Fatalf("Expected %v, but got result %v", expected, result)
+	}
+
+	// the checkpoint of a rotated file is not used
+	store.Set(Checkpoint{Source: fileName, Device: device, Inode: inode + 1, Offset: offset})
+	watcher = InitWatcher(fileName, time.Second, true, store)
+	defer watcher.closeCurFile()
+	if result := checkFileMessages(watcher); !reflect.DeepEqual(result, []string{TestLog1, TestLog2}) {
+		t.Fatalf("Expected %v, but got result %v", []string{TestLog1, TestLog2}, result)
+	}
+}
+
+// Helper function to write given content to the end of given file without newline
+func appendContent(t *testing.T, fileName, content string) {
+	file, err := os.OpenFile(fileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("Cannot open the test file, err=%+v", err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(content); err != nil {
+		t.Fatalf("Cannot write the test file, err=%+v", err)
+	}
+}
+
+// Test if the incomplete last line is held back until it's complete or times out
+func TestCheckPartialLine(t *testing.T) {
+	defer func(now func() time.Time) { timeNow = now }(timeNow)
+	curTime := time.Now()
+	timeNow = func() time.Time { return curTime }
+	var counted []string
+	defer SetLineCounter(countLine)
+	SetLineCounter(func(fileName, reason string) { counted = append(counted, reason) })
+
+	fileName := "test-partial.txt"
+	defer os.Remove(fileName)
+	appendLog(t, fileName, TestLog1)
+	appendContent(t, fileName, "first half")
+	watcher := InitWatcher(fileName, time.Second, true, nil)
+	watcher.SetLineLimits(DefaultMaxLineLength, 5*time.Second)
+	defer watcher.closeCurFile()
+	if result := checkFileMessages(watcher); !reflect.DeepEqual(result, []string{TestLog1}) {
+		t.Fatalf("Expected %v, but got result %v", []string{TestLog1}, result)
+	}
+	if watcher.lastReadPosition != int64(len(TestLog1)+1) {
+		t.Fatalf("Expected last read position %v, but got %v", len(TestLog1)+1, watcher.lastReadPosition)
+	}
+
+	// the line is read once it's complete
+	curTime = curTime.Add(time.Second)
+	appendLog(t, fileName, " second half")
+	appendContent(t, fileName, "incomplete")
+	if result := checkFileMessages(watcher); !reflect.DeepEqual(result, []string{"first half second half"}) {
+		t.Fatalf("Expected %v, but got result %v", []string{"first half second half"}, result)
+	}
+
+	// the line is read as it is after the timeout
+	if result := checkFileMessages(watcher); len(result) != 0 {
+		t.Fatalf("Expected no logs, but got result %v", result)
+	}
+	curTime = curTime.Add(5 * time.Second)
+	if result := checkFileMessages(watcher); !reflect.DeepEqual(result, []string{"incomplete"}) {
+		t.Fatalf("Expected %v, but got result %v", []string{"incomplete"}, result)
+	}
+	if !reflect.DeepEqual(counted, []string{LineIncomplete}) {
+		t.Fatalf("Expected counted %v, but got result %v", []string{LineIncomplete}, counted)
+	}
+}
+
+// Test if the incomplete last line of the rotated file is read before switching to the new file
+func TestCheckPartialLineRotation(t *testing.T) {
+	fileName := "test-partial-rotation.txt"
+	defer os.Remove(fileName)
+	defer os.Remove(fileName + ".1")
+	appendContent(t, fileName, "incomplete")
+	watcher := InitWatcher(fileName, time.Second, true, nil)
+	watcher.SetLineLimits(DefaultMaxLineLength, time.Hour)
+	defer watcher.closeCurFile()
+	if result := checkFileMessages(watcher); len(result) != 0 {
+		t.Fatalf("Expected no logs, but got result %v", result)
+	}
+
+	if err := os.Rename(fileName, fileName+".1"); err != nil {
+		t.Fatalf("Cannot rotate the test file, err=%+v", err)
+	}
+	appendLog(t, fileName, TestLog1)
+	if result := checkFileMessages(watcher); !reflect.DeepEqual(result, []string{"incomplete", TestLog1}) {
+		t.Fatalf("Expected %v, but got result %v", []string{"incomplete", TestLog1}, result)
+	}
+}
+
+// Test if the lines longer than the maximum line length are skipped, even if they are written in pieces
+func TestCheckOverlongLine(t *testing.T) {
+	var counted []string
+	defer SetLineCounter(countLine)
+	SetLineCounter(func(fileName, reason string) { counted = append(counted, reason) })
+
+	fileName := "test-overlong.txt"
+	defer os.Remove(fileName)
+	appendLog(t, fileName, strings.Repeat("x", 100))
+	appendLog(t, fileName, "short")
+	appendContent(t, fileName, strings.Repeat("y", 60))
+	watcher := InitWatcher(fileName, time.Second, true, nil)
+	watcher.SetLineLimits(50, time.Hour)
+	defer watcher.closeCurFile()
+	if result := checkFileMessages(watcher); !reflect.DeepEqual(result, []string{"short"}) {
+		t.Fatalf("Expected %v, but got result %v", []string{"short"}, result)
+	}
+
+	// the rest of the overlong line is skipped as well
+	appendLog(t, fileName, strings.Repeat("y", 10))
+	appendLog(t, fileName, "still short")
+	if result := checkFileMessages(watcher); !reflect.DeepEqual(result, []string{"still short"}) {
+		t.Fatalf("Expected %v, but got result %v", []string{"still short"}, result)
+	}
+	if !reflect.DeepEqual(counted, []string{LineOverlong, LineOverlong}) {
+		t.Fatalf("Expected counted %v, but got result %v", []string{LineOverlong, LineOverlong}, counted)
+	}
+
+	// lines longer than the buffer of reader are read as well
+	long := strings.Repeat("z", 10000)
+	watcher.SetLineLimits(DefaultMaxLineLength, time.Hour)
+	appendLog(t, fileName, long)
+	if result := checkFileMessages(watcher); !reflect.DeepEqual(result, []string{long}) {
+		t.Fatalf("Expected a line of %v bytes, but got result %v", len(long), len(result))
 	}
 }
@@ -5,8 +5,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
 	"time"
 
+	"github.com/box/kube-iptables-tailer/drop"
 	"github.com/box/kube-iptables-tailer/util"
 	"go.uber.org/zap"
 	v1 "k8s.io/api/core/v1"
@@ -47,7 +49,7 @@ const indexerName = "podIp"
 
 type Locator interface {
 	Run(stopCh <-chan struct{})
-	LocatePod(ip string) (*v1.Pod, error)
+	LocatePod(ip net.IP) (*v1.Pod, error)
 }
 
 // PodLocator handles the process of locating corresponding Pods having iptables packet drops in Kubernetes cluster.
@@ -94,12 +96,13 @@ func getPodLocator(listerWatcher cache.ListerWatcher) *PodLocator {
 func podIPIndexer() func(obj interface{}) ([]string, error) {
 	indexFunc := func(obj interface{}) ([]string, error) {
 		if pod, ok := obj.(*v1.Pod); ok {
+			podIPs := getPodIPs(pod)
 			zap.L().Info("Indexing pod",
 				zap.String("pod_name", pod.Name),
 				zap.String("pod_namespace", pod.Namespace),
-				zap.String("pod_ip", pod.Status.PodIP),
+				zap.Strings("pod_ips", podIPs),
 			)
-			return []string{pod.Status.PodIP}, nil
+			return podIPs, nil
 		} else {
 			return []string{""}, fmt.Errorf("unable to cast object to *v1.Pod: obj=%+v",
 				util.PrettyPrint(obj))
@@ -108,6 +111,19 @@ func podIPIndexer() func(obj interface{}) ([]string, error) {
 	return indexFunc
 }
 
+// Helper function to get all the IPs of given pod in canonical form, dual-stack pods have one IP for each family
+func getPodIPs(pod *v1.Pod) []string {
+	var podIPs []string
+	for _, podIP := range pod.Status.PodIPs {
+		podIPs = append(podIPs, util.GetCanonicalIP(podIP.IP))
+	}
+	// PodIPs is not populated by older Kubernetes versions
+	if len(podIPs) == 0 {
+		podIPs = append(podIPs, util.GetCanonicalIP(pod.Status.PodIP))
+	}
+	return podIPs
+}
+
 func (locator *PodLocator) Run(stopCh <-chan struct{}) {
 	go locator.informer.Run(stopCh)
 
@@ -117,7 +133,8 @@ func (locator *PodLocator) Run(stopCh <-chan struct{}) {
 	}
 }
 
-func (locator *PodLocator) LocatePod(ip string) (*v1.Pod, error) {
+func (locator *PodLocator) LocatePod(podIP net.IP) (*v1.Pod, error) {
+	ip := podIP.String()
 	items, err := locator.informer.GetIndexer().ByIndex(indexerName, ip)
 	if err != nil {
 		return nil, errors.New(fmt.Sprintf("Error looking up pod: ip=%v", ip))
@@ -144,7 +161,7 @@ func (locator *PodLocator) LocatePod(ip string) (*v1.Pod, error) {
  *    be sharing the host IP, therefore it's impossible to distinguish which pod is the src/dst.
  * 3. If no pod is found, attempt to resolve the IP to hostname.
  */
-func getNamespaceOrHostName(pod *v1.Pod, ip string, resolver DnsResolver) string {
+func getNamespaceOrHostName(pod *v1.Pod, ip net.IP, resolver DnsResolver) string {
 	if pod != nil {
 		if !pod.Spec.HostNetwork {
 			zap.L().Debug(
@@ -182,12 +199,12 @@ func getNamespaceOrHostName(pod *v1.Pod, ip string, resolver DnsResolver) string
 			return pod.Spec.NodeName
 		}
 	}
-	zap.L().Debug("Pod spec not found, using reverse dns lookup", zap.String("ip", ip))
+	zap.L().Debug("Pod spec not found, using reverse dns lookup", zap.String("ip", ip.String()))
 	return getHostName(resolver, ip)
 }
 
 // Helper function to construct packet drop message
-func getPacketDropMessage(otherSideServiceName string, ip string, port string, proto string, direction TrafficDirection) string {
+func getPacketDropMessage(otherSideServiceName string, ip net.IP, packetDrop drop.PacketDrop, direction TrafficDirection) string {
 	var buffer bytes.Buffer
 	buffer.WriteString("Packet dropped")
 	// append traffic direction
@@ -198,15 +215,61 @@ func getPacketDropMessage(otherSideServiceName string, ip string, port string, p
 	}
 	// append other side's service name
 	buffer.WriteString(otherSideServiceName)
-	if otherSideServiceName != ip && ip != "" {
+	if ip != nil && otherSideServiceName != ip.String() {
 		buffer.WriteString(fmt.Sprintf(" (%s)", ip))
 	}
-	buffer.WriteString(fmt.Sprintf(" on port %s/%s", port, proto))
+	buffer.WriteString(" " + getTrafficDescription(packetDrop))
+	// CNIs dropping the packets on their own tell why, e.g. "Policy denied" of Cilium
+	if packetDrop.DropReason != "" {
+		buffer.WriteString(": " + packetDrop.DropReason)
+	}
+	return buffer.String()
+}
+
+// Helper function to describe the dropped traffic: "on port 1234/TCP" for protocols having ports,
+// "for ICMP echo-request" for ICMP, and "for protocol 47" for any other protocol
+func getTrafficDescription(packetDrop drop.PacketDrop) string {
+	// ARP packets logged by ebtables or arptables have no protocol, e.g. "for ARP request"
+	if packetDrop.IsArp() {
+		return fmt.Sprintf("for ARP %s", drop.GetArpOpcodeName(packetDrop))
+	}
+	if packetDrop.Proto.HasPorts() && packetDrop.DstPort != 0 {
+		// TCP flags tell if it's a new connection or a packet of an established one, e.g. "[SYN]" or "[ACK PSH]"
+		if packetDrop.TCPFlags != 0 {
+			return fmt.Sprintf("on port %d/%s [%s]", packetDrop.DstPort, packetDrop.Proto, packetDrop.TCPFlags)
+		}
+		return fmt.Sprintf("on port %d/%s", packetDrop.DstPort, packetDrop.Proto)
+	}
+	icmpTypeName := drop.GetIcmpTypeName(packetDrop)
+	if icmpTypeName == "" {
+		return fmt.Sprintf("for protocol %s", packetDrop.Proto)
+	}
+
+	var buffer bytes.Buffer
+	buffer.WriteString(fmt.Sprintf("for %s %s", packetDrop.Proto, icmpTypeName))
+	if packetDrop.Mtu != 0 {
+		buffer.WriteString(fmt.Sprintf(" with MTU %d", packetDrop.Mtu))
+	}
+	// ICMP errors are about the original packet they carry
+	if embedded := packetDrop.Embedded; embedded.SrcIP != nil {
+		if embedded.Proto.HasPorts() && embedded.DstPort != 0 {
+			buffer.WriteString(fmt.Sprintf(" about port %d/%s", embedded.DstPort, embedded.Proto))
+		} else {
+			buffer.WriteString(fmt.Sprintf(" about protocol %s", embedded.Proto))
+		}
+	}
 	return buffer.String()
 }
 
 // Get the host name of given ip from dns, return IP address if host name cannot be found
-func getHostName(resolver DnsResolver, ipAddress string) string {
+func getHostName(resolver DnsResolver, ip net.IP) string {
+	ipAddress := ip.String()
+	// IPv6 link-local and multicast addresses are never registered in reverse DNS
+	if ip.To4() == nil && (ip.IsLinkLocalUnicast() || ip.IsMulticast()) {
+		zap.L().Debug("Skipping dns lookup for IPv6 address", zap.String("ip", ipAddress))
+		return ipAddress
+	}
+
 	zap.L().Debug("Performing dns lookup")
 	addr, err := resolver.LookupAddr(context.Background(), ipAddress)
 	if err != nil || len(addr) == 0 {
@@ -4,8 +4,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/box/kube-iptables-tailer/drop"
 	"k8s.io/api/core/v1"
 	"net"
+	"reflect"
 	"testing"
 )
 
@@ -31,16 +33,16 @@ func TestGetServiceNameFromIP(t *testing.T) {
 	pod := &v1.Pod{}
 	pod.Namespace = expected
 	pod.Spec.HostNetwork = false
-	result := getNamespaceOrHostName(pod, "", net.DefaultResolver)
+	result := getNamespaceOrHostName(pod, nil, net.DefaultResolver)
 	if result != expected {
 		t.Fatalf("Expected: %v, but got result: %v", expected, result)
 	}
 
 	// test for pod using hostNetworking but without spec.NodeName
 	expectedDns := "test-hostname-dns"
-	hostIP := "123.456.789"
+	hostIP := net.ParseIP("123.45.67.89")
 	mockedResolver := initMockDnsResolver()
-	mockedResolver.hostNames[hostIP] = []string{expectedDns}
+	mockedResolver.hostNames[hostIP.String()] = []string{expectedDns}
 	pod.Spec.HostNetwork = true
 	result = getNamespaceOrHostName(pod, hostIP, mockedResolver)
 	if result != expectedDns {
@@ -67,49 +69,62 @@ func TestGetPacketDropMessageForPods(t *testing.T) {
 	namespace := "pod-name-test"
 	testPod := &v1.Pod{}
 	testPod.Namespace = namespace
-	ipAddress := "123.456.789"
-	dstPort := "1234"
-	proto := "TCP"
+	ipAddress := net.ParseIP("123.45.67.89")
+	dstPort := uint16(1234)
+	proto := drop.ProtoTCP
+	packetDrop := drop.PacketDrop{DstPort: dstPort, Proto: proto}
 	serviceName := getNamespaceOrHostName(testPod, ipAddress, net.DefaultResolver)
 	// test send traffic
-	resultSending := getPacketDropMessage(serviceName, ipAddress, dstPort, proto, send)
-	expectedSending := fmt.Sprintf("Packet dropped when sending traffic to %s (%s) on port %s/%s",
+	resultSending := getPacketDropMessage(serviceName, ipAddress, packetDrop, send)
+	expectedSending := fmt.Sprintf("Packet dropped when sending traffic to %s (%s) on port %d/%s",
 		namespace, ipAddress, dstPort, proto)
 	if resultSending != expectedSending {
 		t.Fatalf("Expected: %v, but got result: %v", expectedSending, resultSending)
 	}
 
 	// test receive traffic
-	resultReceiving := getPacketDropMessage(serviceName, ipAddress, dstPort, proto, receive)
-	expectedReceiving := fmt.Sprintf("Packet dropped when receiving traffic from %s (%s) on port %s/%s",
+	resultReceiving := getPacketDropMessage(serviceName, ipAddress, packetDrop, receive)
+	expectedReceiving := fmt.Sprintf("Packet dropped when receiving traffic from %s (%s) on port %d/%s",
 		namespace, ipAddress, dstPort, proto)
 	if resultReceiving != expectedReceiving {
 		t.Fatalf("Expected: %v, but got result: %v", expectedReceiving, resultReceiving)
 	}
 }
 
+// Test if getPacketDropMessage() appends the drop reason of the packets dropped by CNIs, e.g. Cilium
+func TestGetPacketDropMessageWithDropReason(t *testing.T) {
+	ipAddress := net.ParseIP("123.45.67.89")
+	packetDrop := drop.PacketDrop{DstPort: 80, Proto: drop.ProtoTCP, DropReason: "Policy denied"}
+	result := getPacketDropMessage("namespace", ipAddress, packetDrop, send)
+	expected := "Packet dropped when sending traffic to namespace (123.45.67.89) on port 80/TCP: Policy denied"
+	if result != expected {
+		t.Fatalf("Expected: %v, but got result: %v", expected, result)
+	}
+}
+
 // Test if getPacketDropMessage() works for hosts
 func TestGetPacketDropMessageForHosts(t *testing.T) {
 	// test when DNS lookup exists
-	ipAddress := "123.456.789"
+	ipAddress := net.ParseIP("123.45.67.89")
 	hostName := "mocked-host"
-	dstPort := "1234"
-	proto := "TCP"
+	dstPort := uint16(1234)
+	proto := drop.ProtoTCP
+	packetDrop := drop.PacketDrop{DstPort: dstPort, Proto: proto}
 	mockedResolver := initMockDnsResolver()
-	mockedResolver.hostNames[ipAddress] = []string{hostName}
+	mockedResolver.hostNames[ipAddress.String()] = []string{hostName}
 	serviceName := getNamespaceOrHostName(nil, ipAddress, mockedResolver)
 
 	// test send traffic
-	resultSending := getPacketDropMessage(serviceName, ipAddress, dstPort, proto, send)
-	expectedSending := fmt.Sprintf("Packet dropped when sending traffic to %s (%s) on port %s/%s",
+	resultSending := getPacketDropMessage(serviceName, ipAddress, packetDrop, send)
+	expectedSending := fmt.Sprintf("Packet dropped when sending traffic to %s (%s) on port %d/%s",
 		hostName, ipAddress, dstPort, proto)
 	if resultSending != expectedSending {
 		t.Fatalf("Expected: %v, but got result: %v", expectedSending, resultSending)
 	}
 
 	// test receive traffic
-	resultReceiving := getPacketDropMessage(serviceName, ipAddress, dstPort, proto, receive)
-	expectedReceiving := fmt.Sprintf("Packet dropped when receiving traffic from %s (%s) on port %s/%s",
+	resultReceiving := getPacketDropMessage(serviceName, ipAddress, packetDrop, receive)
+	expectedReceiving := fmt.Sprintf("Packet dropped when receiving traffic from %s (%s) on port %d/%s",
 		hostName, ipAddress, dstPort, proto)
 	if resultReceiving != expectedReceiving {
 		t.Fatalf("Expected: %v, but got result: %v", expectedReceiving, resultReceiving)
@@ -118,8 +133,8 @@ func TestGetPacketDropMessageForHosts(t *testing.T) {
 	// test when DNS lookup returns empty hostname, should return IP address
 	mockedResolver = initMockDnsResolver()
 	serviceName = getNamespaceOrHostName(nil, ipAddress, mockedResolver)
-	resultDnsEmpty := getPacketDropMessage(serviceName, ipAddress, dstPort, proto, send)
-	expectedDnsEmpty := fmt.Sprintf("Packet dropped when sending traffic to %s on port %s/%s",
+	resultDnsEmpty := getPacketDropMessage(serviceName, ipAddress, packetDrop, send)
+	expectedDnsEmpty := fmt.Sprintf("Packet dropped when sending traffic to %s on port %d/%s",
 		ipAddress, dstPort, proto)
 	if resultSending != expectedSending {
 		t.Fatalf("Expected: %v, but got result: %v", expectedDnsEmpty, resultDnsEmpty)
@@ -129,10 +144,108 @@ func TestGetPacketDropMessageForHosts(t *testing.T) {
 	mockedResolver = initMockDnsResolver()
 	mockedResolver.err = errors.New("DNS lookup fails")
 	serviceName = getNamespaceOrHostName(nil, ipAddress, mockedResolver)
-	resultDnsFails := getPacketDropMessage(serviceName, ipAddress, dstPort, proto, receive)
-	expectedDnsFails := fmt.Sprintf("Packet dropped when sending traffic to %s on port %s/%s",
+	resultDnsFails := getPacketDropMessage(serviceName, ipAddress, packetDrop, receive)
+	expectedDnsFails := fmt.Sprintf("Packet dropped when sending traffic to %s on port %d/%s",
 		ipAddress, dstPort, proto)
 	if resultSending != expectedSending {
 		t.Fatalf("Expected: %v, but got result: %v", expectedDnsFails, resultDnsFails)
 	}
 }
+
+// Test if podIPIndexer() indexes all the IPs of dual-stack pods
+func TestPodIPIndexerDualStack(t *testing.T) {
+	pod := &v1.Pod{}
+	pod.Status.PodIP = "10.244.1.5"
+	pod.Status.PodIPs = []v1.PodIP{{IP: "10.244.1.5"}, {IP: "fd00:10:244:1:0:0:0:5"}}
+	result, err := podIPIndexer()(pod)
+	if err != nil {
+		t.Fatalf("Expected error nil, but got error %s", err)
+	}
+	expected := []string{"10.244.1.5", "fd00:10:244:1::5"}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("Expected: %v, but got result: %v", expected, result)
+	}
+
+	// test for pod without PodIPs
+	pod.Status.PodIPs = nil
+	result, err = podIPIndexer()(pod)
+	if err != nil {
+		t.Fatalf("Expected error nil, but got error %s", err)
+	}
+	expected = []string{"10.244.1.5"}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("Expected: %v, but got result: %v", expected, result)
+	}
+}
+
+// Test if getHostName() works for IPv6 addresses
+func TestGetHostNameIPv6(t *testing.T) {
+	expectedDns := "test-hostname-dns"
+	mockedResolver := initMockDnsResolver()
+	mockedResolver.hostNames["fd00:10:244:1::5"] = []string{expectedDns}
+	// ip6tables logs IPv6 addresses without compression
+	result := getHostName(mockedResolver, net.ParseIP("fd00:0010:0244:0001:0000:0000:0000:0005"))
+	if result != expectedDns {
+		t.Fatalf("Expected: %v, but got result: %v", expectedDns, result)
+	}
+
+	// test for link-local address which should not be looked up
+	linkLocal := "fe80::1"
+	mockedResolver.hostNames[linkLocal] = []string{expectedDns}
+	result = getHostName(mockedResolver, net.ParseIP("fe80:0000:0000:0000:0000:0000:0000:0001"))
+	if result != linkLocal {
+		t.Fatalf("Expected: %v, but got result: %v", linkLocal, result)
+	}
+}
+
+// Helper function to get the pointer of given ICMP type
+func getIcmpType(icmpType uint8) *uint8 {
+	return &icmpType
+}
+
+// Test if getPacketDropMessage() works for protocols without ports
+func TestGetPacketDropMessageWithoutPorts(t *testing.T) {
+	ipAddress := net.ParseIP("10.244.1.5")
+	serviceName := "test-namespace"
+	testCases := []struct {
+		packetDrop drop.PacketDrop
+		expected   string
+	}{
+		{
+			packetDrop: drop.PacketDrop{Proto: drop.ProtoICMP, IcmpType: getIcmpType(8), IcmpID: 1234, IcmpSeq: 1},
+			expected:   "for ICMP echo-request",
+		},
+		{
+			packetDrop: drop.PacketDrop{Proto: drop.ProtoICMP, IcmpType: getIcmpType(3), IcmpCode: 4, Mtu: 1400,
+				Embedded: drop.EmbeddedPacket{SrcIP: net.ParseIP("10.244.2.7"), DstIP: net.ParseIP("192.168.10.4"),
+					Proto: drop.ProtoTCP, SrcPort: 443, DstPort: 51234}},
+			expected: "for ICMP destination-unreachable (frag-needed) with MTU 1400 about port 51234/TCP",
+		},
+		{
+			packetDrop: drop.PacketDrop{Proto: drop.ProtoICMPv6, IcmpType: getIcmpType(2), Mtu: 1280,
+				Embedded: drop.EmbeddedPacket{SrcIP: net.ParseIP("fd00:10:244:2::7"),
+					DstIP: net.ParseIP("fd00:10:244:1::5"), Proto: drop.ProtoESP}},
+			expected: "for ICMPv6 packet-too-big with MTU 1280 about protocol ESP",
+		},
+		{
+			packetDrop: drop.PacketDrop{Proto: drop.ProtoGRE},
+			expected:   "for protocol 47",
+		},
+		{
+			packetDrop: drop.PacketDrop{Proto: drop.ProtoTCP, DstPort: 8080, TCPFlags: drop.TCPFlagSYN},
+			expected:   "on port 8080/TCP [SYN]",
+		},
+		{
+			packetDrop: drop.PacketDrop{EtherType: drop.EtherTypeARP, ArpOpcode: 1},
+			expected:   "for ARP request",
+		},
+	}
+	for _, testCase := range testCases {
+		result := getPacketDropMessage(serviceName, ipAddress, testCase.packetDrop, send)
+		expected := fmt.Sprintf("Packet dropped when sending traffic to %s (%s) %s",
+			serviceName, ipAddress, testCase.expected)
+		if result != expected {
+			t.Fatalf("Expected: %v, but got result: %v", expected, result)
+		}
+	}
+}
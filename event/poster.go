@@ -25,7 +25,7 @@ import (
 type Poster struct {
 	kubeClient         *kubernetes.Clientset
 	recorder           record.EventRecorder
-	eventSubmitTimeMap map[string]time.Time // (srcIP+dstIP) as key, posted time as value
+	eventSubmitTimeMap map[string]time.Time // getEventKey() as key, posted time as value
 	backoff            backoff.BackOff      // used for retry when api server is down
 	locator            Locator
 }
@@ -87,6 +87,8 @@ func (poster *Poster) Run(stopCh <-chan struct{}, packetDropCh <-chan drop.Packe
 
 		// reset the backoff to handle the next packet drop
 		poster.backoff.Reset()
+		// the log of the packet drop can be skipped after restarts now
+		packetDrop.Done()
 	}
 }
 
@@ -108,20 +110,20 @@ func (poster *Poster) handle(packetDrop drop.PacketDrop) error {
 	srcName := getNamespaceOrHostName(srcPod, packetDrop.SrcIP, net.DefaultResolver)
 	dstName := getNamespaceOrHostName(dstPod, packetDrop.DstIP, net.DefaultResolver)
 	if srcPod != nil && !srcPod.Spec.HostNetwork {
-		message := getPacketDropMessage(dstName, packetDrop.DstIP, packetDrop.DstPort, packetDrop.Proto, send)
-		if err := poster.submitEvent(srcPod, message); err != nil {
+		message := getPacketDropMessage(dstName, packetDrop.DstIP, packetDrop, send)
+		if err := poster.submitEvent(srcPod, packetDrop, message); err != nil {
 			return err
 		}
 	}
 	if dstPod != nil && !dstPod.Spec.HostNetwork {
-		message := getPacketDropMessage(srcName, packetDrop.SrcIP, packetDrop.DstPort, packetDrop.Proto, receive)
-		if err := poster.submitEvent(dstPod, message); err != nil {
+		message := getPacketDropMessage(srcName, packetDrop.SrcIP, packetDrop, receive)
+		if err := poster.submitEvent(dstPod, packetDrop, message); err != nil {
 			return err
 		}
 	}
-	metrics.GetInstance().ProcessPacketDrop(srcName, dstName)
+	metrics.GetInstance().ProcessPacketDrop(srcName, dstName, packetDrop.GetProtocolName(), packetDrop.GetMetricLabels())
 	// update poster's eventSubmitTimeMap
-	poster.eventSubmitTimeMap[packetDrop.SrcIP+packetDrop.DstIP] = time.Now()
+	poster.eventSubmitTimeMap[getEventKey(packetDrop)] = time.Now()
 	return nil
 }
 
@@ -133,8 +135,7 @@ func (poster *Poster) shouldIgnore(packetDrop drop.PacketDrop) bool {
 		return true
 	}
 	logTime := packetDrop.GetLogTime() //  the error would be handled in expiration check called above
-	key := packetDrop.SrcIP + packetDrop.DstIP
-	lastPostedTime := poster.eventSubmitTimeMap[key]
+	lastPostedTime := poster.eventSubmitTimeMap[getEventKey(packetDrop)]
 	repeatEventIntervalMinutes := float64(util.GetEnvIntOrDefault(
 		util.RepeatedEventIntervalMinutes, util.DefaultRepeatedEventIntervalMinutes))
 	if !lastPostedTime.IsZero() && logTime.Sub(lastPostedTime).Minutes() <= repeatEventIntervalMinutes {
@@ -145,14 +146,23 @@ func (poster *Poster) shouldIgnore(packetDrop drop.PacketDrop) bool {
 	return false
 }
 
-// Submit an event using kube API with message attached
-func (poster Poster) submitEvent(pod *v1.Pod, message string) error {
+// Helper function to get the key of given PacketDrop in eventSubmitTimeMap from its source and destination IPs
+func getEventKey(packetDrop drop.PacketDrop) string {
+	return packetDrop.SrcIP.String() + "-" + packetDrop.DstIP.String()
+}
+
+// Submit an event of given PacketDrop using kube API with message attached, the log prefix of PacketDrop may
+// override the event type, reason and message
+func (poster Poster) submitEvent(pod *v1.Pod, packetDrop drop.PacketDrop, message string) error {
 	ref, err := reference.GetReference(scheme.Scheme, pod)
 	if err != nil {
 		return err
 	}
-	reason := util.GetEnvStringOrDefault(util.KubeEventDisplayReason, util.DefaultKubeEventDisplayReason)
-	poster.recorder.Event(ref, v1.EventTypeWarning, reason, message)
+	eventType := packetDrop.GetEventType(v1.EventTypeWarning)
+	reason := packetDrop.GetEventReason(
+		util.GetEnvStringOrDefault(util.KubeEventDisplayReason, util.DefaultKubeEventDisplayReason))
+	message = packetDrop.GetEventMessage(message)
+	poster.recorder.Event(ref, eventType, reason, message)
 	zap.L().Info("Submitted event", zap.String("pod_name", ref.Name), zap.String("event_message", message))
 	return nil
 }
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"flag"
 	"math"
+	"net"
 	"os"
 	"strings"
 	"testing"
@@ -21,7 +22,7 @@ type DummyLocator struct{}
 func (loc *DummyLocator) Run(stopCh <-chan struct{}) {
 	// no-op
 }
-func (loc *DummyLocator) LocatePod(ip string) (*v1.Pod, error) {
+func (loc *DummyLocator) LocatePod(ip net.IP) (*v1.Pod, error) {
 	return nil, errors.New("simulating a pod lookup error")
 }
 
@@ -53,9 +54,9 @@ func TestShouldIgnoreSameEvent(t *testing.T) {
 	poster := Poster{}
 	poster.eventSubmitTimeMap = make(map[string]time.Time)
 	curTime := time.Now()
-	packetDrop := drop.PacketDrop{LogTime: curTime, SrcIP: "1.1.1", DstIP: "2.2.2"}
+	packetDrop := drop.PacketDrop{LogTime: curTime, SrcIP: net.ParseIP("1.1.1.1"), DstIP: net.ParseIP("2.2.2.2")}
 	// insert a mocked time when same event was submitted recently (within the interval threshold)
-	poster.eventSubmitTimeMap[packetDrop.SrcIP+packetDrop.DstIP] =
+	poster.eventSubmitTimeMap[getEventKey(packetDrop)] =
 		curTime.Add(-util.DefaultRepeatedEventIntervalMinutes*time.Minute + time.Minute)
 
 	result := poster.shouldIgnore(packetDrop)
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"flag"
 	"fmt"
 	"github.com/box/kube-iptables-tailer/drop"
@@ -9,10 +11,13 @@ import (
 	"github.com/box/kube-iptables-tailer/util"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"sync"
+	"syscall"
 	"time"
 )
 
@@ -32,31 +37,289 @@ func main() {
 	flag.Parse()
 
 	stopCh := make(chan struct{})
-	var vg sync.WaitGroup
-	vg.Add(4)
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		sig := <-sigCh
+		zap.L().Info("Stopping", zap.String("signal", sig.String()))
+		close(stopCh)
+	}()
+	// parsers (and the NFLOG watcher) write to the channel of PacketDrop, which is closed once all of them stop
+	var parsers, poster, checkpointStore sync.WaitGroup
+	checkpointStopCh := make(chan struct{})
 
+	logPrefixes := getLogPrefixes()
+	var logPaths []drop.LogPath
+	if os.Getenv(util.NflogGroup) == "" {
+		logPaths = getLogPaths(logPrefixes)
+	}
+	metrics.SetLabelNames(getLabelNames(logPrefixes, logPaths))
+	metrics.SetProtoLabel(util.GetEnvBoolOrDefault(util.MetricsProtoLabel, util.DefaultMetricsProtoLabel))
+	drop.SetLineCounter(metrics.GetInstance().ProcessIrregularLine)
+	drop.SetYearRollover(time.Duration(util.GetEnvIntOrDefault(util.PacketDropLogYearRolloverMinutes,
+		util.DefaultPacketDropLogYearRolloverMinutes)) * time.Minute)
 	go startMetricsServer(util.GetEnvIntOrDefault(util.MetricsServerPort, util.DefaultMetricsServerPort))
 
 	//prepare channels
-	logChangeCh := make(chan string)
 	bufferSize := util.GetEnvIntOrDefault(util.PacketDropChannelBufferSize, util.DefaultPacketDropsChannelBufferSize)
 	packetDropCh := make(chan drop.PacketDrop, bufferSize)
 
-	go startPoster(packetDropCh, stopCh)
-
-	logPrefix := util.GetRequiredEnvString(util.IptablesLogPrefix)
-	go startParsing(logPrefix, logChangeCh, packetDropCh)
+	poster.Add(1)
+	go startPoster(packetDropCh, stopCh, &poster)
 
-	if journalDir := os.Getenv(util.JournalDirectory); journalDir != "" {
-		go startJournalWatcher(journalDir, logChangeCh)
+	if os.Getenv(util.NflogGroup) != "" {
+		// packets logged to NFLOG group are turned into PacketDrop directly without parsing raw logs
+		parsers.Add(1)
+		go startNflogWatcher(util.GetRequiredEnvInt(util.NflogGroup), logPrefixes, stopCh, packetDropCh, &parsers)
 	} else {
-		fileName := util.GetRequiredEnvString(util.IptablesLogPath)
+		checkpoints := getCheckpointStore(checkpointStopCh, &checkpointStore)
+		// records of journal, kmsg and syslog have their own timestamps and host names, the log format is only used
+		// by raw logs
+		if journalDir := os.Getenv(util.JournalDirectory); journalDir != "" {
+			recordCh := make(chan drop.Record)
+			parsers.Add(1)
+			go startParsing(logPrefixes, getDefaultLogFormat(), recordCh, packetDropCh, checkpoints, &parsers)
+			go startSource(getJournalWatcher(journalDir, logPrefixes, checkpoints), stopCh, recordCh)
+		}
+		if kmsgPath := os.Getenv(util.KmsgPath); kmsgPath != "" {
+			recordCh := make(chan drop.Record)
+			parsers.Add(1)
+			go startParsing(logPrefixes, getDefaultLogFormat(), recordCh, packetDropCh, checkpoints, &parsers)
+			go startSource(drop.InitKmsgWatcher(kmsgPath, checkpoints), stopCh, recordCh)
+		}
+		if address := os.Getenv(util.SyslogListenAddress); address != "" {
+			recordCh := make(chan drop.Record)
+			parsers.Add(1)
+			go startParsing(logPrefixes, getDefaultLogFormat(), recordCh, packetDropCh, checkpoints, &parsers)
+			go startSource(getSyslogReceiver(address), stopCh, recordCh)
+		}
+		if util.GetEnvBoolOrDefault(util.CiliumMonitor, util.DefaultCiliumMonitor) {
+			// drop notifications of cilium monitor are JSON objects decoded directly, whatever the log format is
+			recordCh := make(chan drop.Record)
+			parsers.Add(1)
+			go startParsing(logPrefixes, getLogFormat(drop.LogFormatCiliumJSON, "", ""), recordCh, packetDropCh,
+				checkpoints, &parsers)
+			go startSource(drop.InitCiliumMonitor(), stopCh, recordCh)
+		}
 		watchSeconds := util.GetEnvIntOrDefault(util.WatchLogsIntervalSeconds, util.DefaultWatchLogsIntervalSecond)
-		go startWatcher(fileName, time.Duration(watchSeconds)*time.Second, logChangeCh)
+		usePolling := util.GetEnvStringOrDefault(util.WatchLogsMode, util.DefaultWatchLogsMode) ==
+			util.WatchLogsModePolling
+		maxLineLength := util.GetEnvIntOrDefault(util.MaxLogLineLength, util.DefaultMaxLogLineLength)
+		partialLineSeconds := util.GetEnvIntOrDefault(util.PartialLineTimeoutSeconds,
+			util.DefaultPartialLineTimeoutSeconds)
+		for _, logPath := range logPaths {
+			// each log path is parsed by its own log prefixes and log format
+			logFormat := getLogPathFormat(logPath)
+			recordCh := make(chan drop.Record)
+			parsers.Add(1)
+			go startParsing(logPath.Prefixes, logFormat, recordCh, packetDropCh, checkpoints, &parsers)
+
+			watcher := drop.InitGlobWatcher(logPath.Path, time.Duration(watchSeconds)*time.Second, usePolling,
+				checkpoints)
+			watcher.SetLineLimits(maxLineLength, time.Duration(partialLineSeconds)*time.Second)
+			if util.GetEnvBoolOrDefault(util.BackfillRotatedLogs, util.DefaultBackfillRotatedLogs) {
+				expiredMinutes := util.GetEnvIntOrDefault(
+					util.PacketDropExpirationMinutes, util.DefaultPacketDropExpirationMinutes)
+				watcher.EnableBackfill(logFormat, time.Duration(expiredMinutes)*time.Minute)
+			}
+			go startSource(watcher, stopCh, recordCh)
+		}
+	}
+
+	// the packet drops read before stopping are handled before the checkpoints are saved for the last time
+	parsers.Wait()
+	close(packetDropCh)
+	poster.Wait()
+	close(checkpointStopCh)
+	checkpointStore.Wait()
+}
+
+//Get the log prefixes to match from IPTABLES_LOG_PREFIXES, or the single prefix of IPTABLES_LOG_PREFIX. Return nil if
+//neither is set but every log path of IPTABLES_LOG_PATHS may have its own log prefixes.
+func getLogPrefixes() drop.LogPrefixes {
+	if config := os.Getenv(util.IptablesLogPrefixes); config != "" {
+		logPrefixes, err := drop.ParseLogPrefixes(config)
+		if err != nil {
+			zap.L().Fatal("Cannot parse log prefixes", zap.String("error", err.Error()))
+		}
+		return logPrefixes
+	}
+	if os.Getenv(util.IptablesLogPrefix) == "" && os.Getenv(util.IptablesLogPaths) != "" && !hasOtherSources() &&
+		os.Getenv(util.NflogGroup) == "" {
+		return nil
+	}
+	logPrefix, err := drop.InitLogPrefix(util.GetRequiredEnvString(util.IptablesLogPrefix))
+	if err != nil {
+		zap.L().Fatal("Cannot parse log prefix", zap.String("error", err.Error()))
+	}
+	return drop.LogPrefixes{logPrefix}
+}
+
+//Get the log files to watch from IPTABLES_LOG_PATHS, or the single log file of IPTABLES_LOG_PATH unless other sources
+//are read instead, in which case IPTABLES_LOG_PATH is ignored. The log paths without their own log prefixes use given
+//log prefixes.
+func getLogPaths(logPrefixes drop.LogPrefixes) []drop.LogPath {
+	var logPaths []drop.LogPath
+	if config := os.Getenv(util.IptablesLogPaths); config != "" {
+		var err error
+		if logPaths, err = drop.ParseLogPaths(config); err != nil {
+			zap.L().Fatal("Cannot parse log paths", zap.String("error", err.Error()))
+		}
+	} else if !hasOtherSources() {
+		logPaths = []drop.LogPath{{Path: util.GetRequiredEnvString(util.IptablesLogPath)}}
+	}
+	for i := range logPaths {
+		if logPaths[i].Prefixes == nil {
+			if logPrefixes == nil {
+				zap.L().Fatal(fmt.Sprintf("Missing log prefixes of log path %v", logPaths[i].Path))
+			}
+			logPaths[i].Prefixes = logPrefixes
+		}
+	}
+	return logPaths
+}
+
+//Check if any source other than log files is set, i.e. the journal, kmsg, syslog or cilium monitor
+func hasOtherSources() bool {
+	return os.Getenv(util.JournalDirectory) != "" || os.Getenv(util.KmsgPath) != "" ||
+		os.Getenv(util.SyslogListenAddress) != "" ||
+		util.GetEnvBoolOrDefault(util.CiliumMonitor, util.DefaultCiliumMonitor)
+}
+
+//Get the names of the metric labels set by given log prefixes and the log prefixes of given log paths
+func getLabelNames(logPrefixes drop.LogPrefixes, logPaths []drop.LogPath) []string {
+	allPrefixes := append(drop.LogPrefixes{}, logPrefixes...)
+	for _, logPath := range logPaths {
+		allPrefixes = append(allPrefixes, logPath.Prefixes...)
+	}
+	return allPrefixes.LabelNames()
+}
+
+//Get the log format of PACKET_DROP_LOG_FORMAT with its time layout and time zone
+func getDefaultLogFormat() *drop.LogFormat {
+	return getLogFormat(util.GetEnvStringOrDefault(util.PacketDropLogFormat, util.DefaultPacketDropLogFormat),
+		os.Getenv(util.PacketDropLogTimeLayout), os.Getenv(util.PacketDropLogTimeZone))
+}
+
+//Get the log format of given log path, which uses the log format of PACKET_DROP_LOG_FORMAT and its time layout unless
+//it has its own log format
+func getLogPathFormat(logPath drop.LogPath) *drop.LogFormat {
+	format, timeLayout := logPath.Format, logPath.TimeLayout
+	if format == "" {
+		format = util.GetEnvStringOrDefault(util.PacketDropLogFormat, util.DefaultPacketDropLogFormat)
+		if timeLayout == "" {
+			timeLayout = os.Getenv(util.PacketDropLogTimeLayout)
+		}
+	}
+	timeZone := logPath.TimeZone
+	if timeZone == "" {
+		timeZone = os.Getenv(util.PacketDropLogTimeZone)
+	}
+	return getLogFormat(format, timeLayout, timeZone)
+}
+
+//Get the journal watcher of given journal directory with the journal matches of JOURNAL_MATCHES, which filters the
+//messages by given log prefixes unless JOURNAL_MATCH_LOG_PREFIXES is false
+func getJournalWatcher(journalDir string, logPrefixes drop.LogPrefixes,
+	checkpoints *drop.CheckpointStore) *drop.JournalWatcher {
+	matches, err := drop.ParseJournalMatches(util.GetEnvStringOrDefault(util.JournalMatches,
+		util.DefaultJournalMatches))
+	if err != nil {
+		zap.L().Fatal("Cannot parse journal matches", zap.String("error", err.Error()))
+	}
+	lookbackMinutes := util.GetEnvIntOrDefault(util.JournalLookbackMinutes, util.DefaultJournalLookbackMinutes)
+	watcher := drop.InitJournalWatcher(journalDir, matches, time.Duration(lookbackMinutes)*time.Minute, checkpoints)
+	if util.GetEnvBoolOrDefault(util.JournalMatchLogPrefixes, util.DefaultJournalMatchLogPrefixes) {
+		watcher.FilterMessages(logPrefixes)
+	}
+	return watcher
+}
+
+//Get the syslog receiver listening to given address by the protocol of SYSLOG_PROTOCOL, where the timestamps without
+//time zone are in PACKET_DROP_LOG_TIMEZONE, limiting its TCP connections by SYSLOG_MAX_CONNECTIONS and
+//SYSLOG_IDLE_TIMEOUT_SECONDS
+func getSyslogReceiver(address string) *drop.SyslogReceiver {
+	receiver, err := drop.InitSyslogReceiver(
+		util.GetEnvStringOrDefault(util.SyslogProtocol, util.DefaultSyslogProtocol), address, getSyslogTLSConfig(),
+		getTimeLocation(os.Getenv(util.PacketDropLogTimeZone)))
+	if err != nil {
+		zap.L().Fatal("Cannot init syslog receiver", zap.String("error", err.Error()))
+	}
+	maxConnections := util.GetEnvIntOrDefault(util.SyslogMaxConnections, util.DefaultSyslogMaxConnections)
+	if maxConnections <= 0 {
+		zap.L().Fatal("Invalid syslog max connections", zap.Int("max_connections", maxConnections))
+	}
+	idleTimeoutSeconds := util.GetEnvIntOrDefault(util.SyslogIdleTimeoutSeconds, util.DefaultSyslogIdleTimeoutSeconds)
+	receiver.SetConnectionLimits(maxConnections, time.Duration(idleTimeoutSeconds)*time.Second)
+	return receiver
+}
+
+//Get the TLS config of the syslog receiver from SYSLOG_TLS_CERT_FILE and SYSLOG_TLS_KEY_FILE, which requires and
+//verifies client certificates by SYSLOG_TLS_CA_FILE if it is set. Return nil if TLS is not used.
+func getSyslogTLSConfig() *tls.Config {
+	certFile := os.Getenv(util.SyslogTLSCertFile)
+	if certFile == "" {
+		return nil
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, util.GetRequiredEnvString(util.SyslogTLSKeyFile))
+	if err != nil {
+		zap.L().Fatal("Cannot load syslog TLS certificate", zap.String("error", err.Error()))
+	}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if caFile := os.Getenv(util.SyslogTLSCAFile); caFile != "" {
+		caCert, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			zap.L().Fatal("Cannot load syslog TLS CA", zap.String("error", err.Error()))
+		}
+		tlsConfig.ClientCAs = x509.NewCertPool()
+		if !tlsConfig.ClientCAs.AppendCertsFromPEM(caCert) {
+			zap.L().Fatal("Cannot load syslog TLS CA", zap.String("file", caFile))
+		}
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig
+}
+
+//Get the location of given time zone, or the local time zone if it is empty
+func getTimeLocation(timeZone string) *time.Location {
+	if timeZone == "" {
+		return time.Local
+	}
+	location, err := time.LoadLocation(timeZone)
+	if err != nil {
+		zap.L().Fatal("Cannot load time zone", zap.String("error", err.Error()))
+	}
+	return location
+}
+
+//Get the log format of given built-in name, grok-like pattern or regular expression with given time layouts and
+//time zone of the timestamps without one
+func getLogFormat(format, timeLayout, timeZone string) *drop.LogFormat {
+	logFormat, err := drop.InitLogFormat(format, timeLayout, getTimeLocation(timeZone))
+	if err != nil {
+		zap.L().Fatal("Cannot parse log format", zap.String("error", err.Error()))
 	}
+	return logFormat
+}
 
-	vg.Wait()
-	close(stopCh)
+//Get the checkpoint store of CHECKPOINT_PATH and start saving it until given stop channel is closed, return nil if
+//CHECKPOINT_PATH is not set
+func getCheckpointStore(stopCh <-chan struct{}, wg *sync.WaitGroup) *drop.CheckpointStore {
+	path := os.Getenv(util.CheckpointPath)
+	if path == "" {
+		return nil
+	}
+	checkpoints, err := drop.InitCheckpointStore(path)
+	if err != nil {
+		zap.L().Fatal("Cannot load checkpoints", zap.String("error", err.Error()))
+	}
+	intervalSeconds := util.GetEnvIntOrDefault(util.CheckpointIntervalSeconds, util.DefaultCheckpointIntervalSeconds)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		checkpoints.Run(stopCh, time.Duration(intervalSeconds)*time.Second)
+	}()
+	return checkpoints
 }
 
 //Start metrics server on given listen address
@@ -68,8 +331,9 @@ func startMetricsServer(port int) {
 	}
 }
 
-//Start poster with given channel of PacketDrop
-func startPoster(packetDropCh <-chan drop.PacketDrop, stopCh <-chan struct{}) {
+//Start poster with given channel of PacketDrop until the channel is closed
+func startPoster(packetDropCh <-chan drop.PacketDrop, stopCh <-chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
 	poster, err := event.InitPoster()
 	if err != nil {
 		// cannot run the service without poster being created successfully
@@ -78,19 +342,29 @@ func startPoster(packetDropCh <-chan drop.PacketDrop, stopCh <-chan struct{}) {
 	poster.Run(stopCh, packetDropCh)
 }
 
-//Start watcher with given filename to watch, interval to check, and channel to store results
-func startWatcher(fileName string, interval time.Duration, logChangeCh chan<- string) {
-	watcher := drop.InitWatcher(fileName, interval)
-	watcher.Run(logChangeCh)
+//Start source with given channel to stop it and channel to store results, which is closed once the source stops
+func startSource(source drop.Source, stopCh <-chan struct{}, recordCh chan<- drop.Record) {
+	defer close(recordCh)
+	if err := source.Run(stopCh, recordCh); err != nil {
+		zap.L().Fatal("Failed to read logs", zap.String("error", err.Error()))
+	}
 }
 
-//Start journal watcher with given journal directory to watch, and channel to store results
-func startJournalWatcher(journalDir string, logChangeCh chan<- string) {
-	jWatcher := drop.InitJournalWatcher(journalDir)
-	jWatcher.Run(logChangeCh)
+//Start NFLOG watcher with given group to subscribe, log prefixes to match, and channel to store results until the
+//stop channel is closed
+func startNflogWatcher(group int, logPrefixes drop.LogPrefixes, stopCh <-chan struct{},
+	packetDropCh chan<- drop.PacketDrop, wg *sync.WaitGroup) {
+	defer wg.Done()
+	nflogWatcher := drop.InitNflogWatcher(group, logPrefixes)
+	if err := nflogWatcher.Run(stopCh, packetDropCh); err != nil {
+		zap.L().Fatal("Failed to read NFLOG packets", zap.String("error", err.Error()))
+	}
 }
 
-//Start parsing process with given channel to get raw logs and another channel to store paring results
-func startParsing(logPrefix string, logChangeCh <-chan string, packetDropCh chan<- drop.PacketDrop) {
-	drop.RunParsing(logPrefix, logChangeCh, packetDropCh)
+//Start parsing process with given log format, channel to get records and another channel to store paring results
+//until the channel of records is closed, keeping the checkpoints of the records in given checkpoint store
+func startParsing(logPrefixes drop.LogPrefixes, logFormat *drop.LogFormat, recordCh <-chan drop.Record,
+	packetDropCh chan<- drop.PacketDrop, checkpoints *drop.CheckpointStore, wg *sync.WaitGroup) {
+	defer wg.Done()
+	drop.RunParsing(logPrefixes, logFormat, recordCh, packetDropCh, checkpoints)
 }
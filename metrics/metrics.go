@@ -10,12 +10,20 @@ import (
 var instance *Metrics
 var once sync.Once
 
+// names of the extra labels set by log prefixes, see SetLabelNames()
+var extraLabelNames []string
+
+// whether packet drops are labeled by their protocols, see SetProtoLabel()
+var protoLabel bool
+
 // Metrics implements instrumentation of metrics for kube-iptables-tailer using Prometheus
 // registry is used by Prometheus to collect metrics
 // packetDropsCount is the Counters Collector in Prometheus having variable labels related to an iptables packet drop
+// irregularLinesCount is the Counters Collector of the log lines which are overlong or incomplete
 type Metrics struct {
-	registry         *prometheus.Registry
-	packetDropsCount *prometheus.CounterVec
+	registry            *prometheus.Registry
+	packetDropsCount    *prometheus.CounterVec
+	irregularLinesCount *prometheus.CounterVec
 }
 
 // Return the singleton instance of metrics
@@ -24,23 +32,53 @@ func GetInstance() *Metrics {
 	return instance
 }
 
+// Set the names of the extra labels of packet drops, which must be called before the instance is used
+func SetLabelNames(labelNames []string) {
+	extraLabelNames = labelNames
+}
+
+// Set whether packet drops are labeled by their protocols, which must be called before the instance is used. The label
+// is not set by default, as it splits the existing series of packet drops.
+func SetProtoLabel(enabled bool) {
+	protoLabel = enabled
+}
+
 // Helper function to init singleton object of Metrics
 func initMetricsSingleton() {
+	labelNames := []string{
+		"src",
+		"dst",
+	}
+	if protoLabel {
+		labelNames = append(labelNames, "proto")
+	}
 	packetDropCountsVec := prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "packet_drops_count",
 		Help: "Counter for number of packet drops handled; excludes expired and duplicates.",
+	},
+		append(labelNames, extraLabelNames...),
+	)
+
+	irregularLinesCountVec := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "irregular_log_lines_count",
+		Help: "Counter for number of log lines skipped as overlong, or read without newline after timeout.",
 	},
 		[]string{
-			"src",
-			"dst",
+			"file",
+			"reason",
 		},
 	)
 
 	// registry the count vector in prometheus
 	r := prometheus.NewRegistry()
 	r.MustRegister(packetDropCountsVec)
+	r.MustRegister(irregularLinesCountVec)
 
-	instance = &Metrics{packetDropsCount: packetDropCountsVec, registry: r}
+	instance = &Metrics{
+		packetDropsCount:    packetDropCountsVec,
+		irregularLinesCount: irregularLinesCountVec,
+		registry:            r,
+	}
 }
 
 // Return the handler of metrics
@@ -49,10 +87,24 @@ func (m *Metrics) GetHandler() http.Handler {
 	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
 }
 
-// Update the metrics by given service name
-func (m *Metrics) ProcessPacketDrop(src, dst string) {
-	m.packetDropsCount.With(prometheus.Labels{
+// Update the metrics by given service name, protocol name of the dropped packet (see drop.PacketDrop.GetProtocolName(),
+// only used if enabled by SetProtoLabel()) and extra labels
+func (m *Metrics) ProcessPacketDrop(src, dst, proto string, extraLabels map[string]string) {
+	labels := prometheus.Labels{
 		"src": src,
 		"dst": dst,
-	}).Inc()
+	}
+	if protoLabel {
+		labels["proto"] = proto
+	}
+	// the labels which are not set for the packet drop are empty
+	for _, name := range extraLabelNames {
+		labels[name] = extraLabels[name]
+	}
+	m.packetDropsCount.With(labels).Inc()
+}
+
+// Update the metrics by given log file and the reason why its line is irregular, see drop.SetLineCounter()
+func (m *Metrics) ProcessIrregularLine(fileName, reason string) {
+	m.irregularLinesCount.With(prometheus.Labels{"file": fileName, "reason": reason}).Inc()
 }
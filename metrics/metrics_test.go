@@ -2,6 +2,7 @@ package metrics
 
 import (
 	"fmt"
+	"github.com/box/kube-iptables-tailer/drop"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -9,8 +10,9 @@ import (
 )
 
 type TestCase struct {
-	src string
-	dst string
+	src   string
+	dst   string
+	proto drop.Protocol
 }
 
 // Test if Metrics can process packetDropsCount with its namespace, other side's service name, and traffic direction
@@ -21,8 +23,9 @@ func TestMetricsProcessPacketDrops(t *testing.T) {
 	// for trafficDirection, set it "SEND" if i is even, "RECEIVE" if i is odd
 	for i := 1; i <= 5; i++ {
 		testCase := TestCase{
-			src: fmt.Sprintf("test-namespace-%v", i),
-			dst: fmt.Sprintf("other-side-service-name-%v", i),
+			src:   fmt.Sprintf("test-namespace-%v", i),
+			dst:   fmt.Sprintf("other-side-service-name-%v", i),
+			proto: drop.ProtoTCP,
 		}
 		testCaseMap[testCase] = i
 	}
@@ -30,7 +33,7 @@ func TestMetricsProcessPacketDrops(t *testing.T) {
 	// trafficDirection is simulated as sending when namespace has odd number and receiving when it has even number
 	for testCase := range testCaseMap {
 		for i := 0; i < testCaseMap[testCase]; i++ {
-			GetInstance().ProcessPacketDrop(testCase.src, testCase.dst)
+			GetInstance().ProcessPacketDrop(testCase.src, testCase.dst, testCase.proto.String(), nil)
 		}
 	}
 	// check the actual metrics raw data with expected string
@@ -57,3 +60,45 @@ func requestContentBody(handler http.Handler) string {
 	handler.ServeHTTP(w, req)
 	return w.Body.String()
 }
+
+// Test if Metrics sets the extra labels and the protocols of packet drops if enabled, where the extra labels are empty
+// if the packet drop does not have them
+func TestMetricsProcessPacketDropsExtraLabels(t *testing.T) {
+	SetLabelNames([]string{"policy"})
+	SetProtoLabel(true)
+	initMetricsSingleton()
+	defer func() {
+		SetLabelNames(nil)
+		SetProtoLabel(false)
+		initMetricsSingleton()
+	}()
+
+	GetInstance().ProcessPacketDrop("src-namespace", "dst-namespace", drop.ProtoUDP.String(),
+		map[string]string{"policy": "deny-all"})
+	GetInstance().ProcessPacketDrop("src-namespace", "dst-namespace", drop.ProtoUDP.String(), nil)
+	metricsResult := requestContentBody(GetInstance().GetHandler())
+	for _, expected := range []string{
+		`packet_drops_count{dst="dst-namespace",policy="deny-all",proto="UDP",src="src-namespace"} 1`,
+		`packet_drops_count{dst="dst-namespace",policy="",proto="UDP",src="src-namespace"} 1`,
+	} {
+		if !strings.Contains(metricsResult, expected) {
+			t.Fatalf("Expected %s, but couldn't find it from result %s", expected, metricsResult)
+		}
+	}
+}
+
+// Test if Metrics counts the irregular log lines by their files and reasons
+func TestMetricsProcessIrregularLines(t *testing.T) {
+	GetInstance().ProcessIrregularLine("/var/log/iptables.log", drop.LineOverlong)
+	GetInstance().ProcessIrregularLine("/var/log/iptables.log", drop.LineOverlong)
+	GetInstance().ProcessIrregularLine("/var/log/iptables.log", drop.LineIncomplete)
+	metricsResult := requestContentBody(GetInstance().GetHandler())
+	for _, expected := range []string{
+		`irregular_log_lines_count{file="/var/log/iptables.log",reason="overlong"} 2`,
+		`irregular_log_lines_count{file="/var/log/iptables.log",reason="incomplete"} 1`,
+	} {
+		if !strings.Contains(metricsResult, expected) {
+			t.Fatalf("Expected %s, but couldn't find it from result %s", expected, metricsResult)
+		}
+	}
+}
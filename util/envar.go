@@ -12,12 +12,37 @@ const (
 	IptablesLogPrefix = "IPTABLES_LOG_PREFIX"
 	IptablesLogPath   = "IPTABLES_LOG_PATH"
 	JournalDirectory  = "JOURNAL_DIRECTORY"
+	NflogGroup        = "NFLOG_GROUP"
 )
 
 // optional env vars
 const (
 	KubeApiServer = "KUBE_API_SERVER" // default value is empty string
 
+	IptablesLogPrefixes = "IPTABLES_LOG_PREFIXES" // replaces IPTABLES_LOG_PREFIX if it is set
+
+	IptablesLogPaths = "IPTABLES_LOG_PATHS" // replaces IPTABLES_LOG_PATH if it is set
+
+	KmsgPath = "KMSG_PATH" // default value is empty string, which doesn't read the kernel ring buffer
+
+	SyslogListenAddress = "SYSLOG_LISTEN_ADDRESS" // default value is empty string, which doesn't receive syslog
+
+	SyslogProtocol        = "SYSLOG_PROTOCOL"
+	DefaultSyslogProtocol = "udp"
+
+	SyslogTLSCertFile = "SYSLOG_TLS_CERT_FILE" // default value is empty string, which doesn't use TLS
+	SyslogTLSKeyFile  = "SYSLOG_TLS_KEY_FILE"
+	SyslogTLSCAFile   = "SYSLOG_TLS_CA_FILE" // default value is empty string, which doesn't verify client certificates
+
+	SyslogMaxConnections        = "SYSLOG_MAX_CONNECTIONS"
+	DefaultSyslogMaxConnections = 100
+
+	SyslogIdleTimeoutSeconds        = "SYSLOG_IDLE_TIMEOUT_SECONDS"
+	DefaultSyslogIdleTimeoutSeconds = 300
+
+	CiliumMonitor        = "CILIUM_MONITOR" // runs "cilium monitor" to read the drop notifications of Cilium
+	DefaultCiliumMonitor = false
+
 	KubeEventDisplayReason        = "KUBE_EVENT_DISPLAY_REASON"
 	DefaultKubeEventDisplayReason = "PacketDrop"
 
@@ -27,6 +52,9 @@ const (
 	MetricsServerPort        = "METRICS_SERVER_PORT"
 	DefaultMetricsServerPort = 9090
 
+	MetricsProtoLabel        = "METRICS_PROTO_LABEL"
+	DefaultMetricsProtoLabel = false
+
 	LogLevel        = "LOG_LEVEL"
 	DefaultLogLevel = "INFO"
 
@@ -36,6 +64,14 @@ const (
 	PacketDropLogTimeLayout        = "PACKET_DROP_LOG_TIME_LAYOUT"
 	DefaultPacketDropLogTimeLayout = "2006-01-02T15:04:05.000000-07:00"
 
+	PacketDropLogFormat        = "PACKET_DROP_LOG_FORMAT"
+	DefaultPacketDropLogFormat = "default"
+
+	PacketDropLogTimeZone = "PACKET_DROP_LOG_TIMEZONE" // default value is the local time zone
+
+	PacketDropLogYearRolloverMinutes        = "PACKET_DROP_LOG_YEAR_ROLLOVER_MINUTES"
+	DefaultPacketDropLogYearRolloverMinutes = 1440
+
 	PacketDropExpirationMinutes        = "PACKET_DROP_EXPIRATION_MINUTES"
 	DefaultPacketDropExpirationMinutes = 10
 
@@ -45,6 +81,33 @@ const (
 	WatchLogsIntervalSeconds       = "WATCH_LOGS_INTERVAL_SECONDS"
 	DefaultWatchLogsIntervalSecond = 5
 
+	MaxLogLineLength        = "MAX_LOG_LINE_LENGTH"
+	DefaultMaxLogLineLength = 65536
+
+	PartialLineTimeoutSeconds        = "PARTIAL_LINE_TIMEOUT_SECONDS"
+	DefaultPartialLineTimeoutSeconds = 5
+
+	WatchLogsMode        = "WATCH_LOGS_MODE"
+	DefaultWatchLogsMode = "inotify"
+	WatchLogsModePolling = "polling"
+
+	JournalMatches        = "JOURNAL_MATCHES"
+	DefaultJournalMatches = "SYSLOG_IDENTIFIER=kernel"
+
+	JournalMatchLogPrefixes        = "JOURNAL_MATCH_LOG_PREFIXES"
+	DefaultJournalMatchLogPrefixes = true
+
+	JournalLookbackMinutes        = "JOURNAL_LOOKBACK_MINUTES"
+	DefaultJournalLookbackMinutes = 0
+
+	BackfillRotatedLogs        = "BACKFILL_ROTATED_LOGS"
+	DefaultBackfillRotatedLogs = false
+
+	CheckpointPath = "CHECKPOINT_PATH" // default value is empty string, which doesn't keep checkpoints
+
+	CheckpointIntervalSeconds        = "CHECKPOINT_INTERVAL_SECONDS"
+	DefaultCheckpointIntervalSeconds = 5
+
 	PodIdentifier        = "POD_IDENTIFIER"
 	DefaultPodIdentifier = "namespace"
 	PodIdentifierLabel   = "POD_IDENTIFIER_LABEL"
@@ -79,6 +142,18 @@ func GetEnvIntOrDefault(key string, def int) int {
 	return def
 }
 
+func GetEnvBoolOrDefault(key string, def bool) bool {
+	if env := os.Getenv(key); env != "" {
+		val, err := strconv.ParseBool(env)
+		if err != nil {
+			zap.L().Warn(fmt.Sprintf("Invalid value for %v: using default: %v", key, def))
+			return def
+		}
+		return val
+	}
+	return def
+}
+
 func GetEnvStringOrDefault(key string, def string) string {
 	if val := os.Getenv(key); len(val) > 0 {
 		return val
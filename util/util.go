@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"go.uber.org/zap"
+	"net"
 	"time"
 )
 
@@ -17,6 +18,16 @@ func PrettyPrint(i interface{}) string {
 	}
 }
 
+// Return the canonical form of given IP address, or the given string itself if it's not an IP address.
+// ip6tables logs IPv6 addresses without compression (e.g. "fd00:0000:0000:0000:0000:0000:0000:0001")
+// while Kubernetes and DNS use the compressed form (e.g. "fd00::1").
+func GetCanonicalIP(ip string) string {
+	if parsed := net.ParseIP(ip); parsed != nil {
+		return parsed.String()
+	}
+	return ip
+}
+
 // Utility functions for packet drop testing
 func GetExpiredTimeIn(expirationMinutes int) time.Time {
 	duration := time.Duration(expirationMinutes) * time.Minute